@@ -0,0 +1,48 @@
+package base_entity
+
+// EventSource an entity that records domain events while it is mutated.
+// PullEvents returns the recorded events and clears them.
+//
+// Repositories take entities by value and drain the copy they write, so PullEvents must clear the
+// events of every copy of the entity, or the caller's entity publishes them again on its next
+// write. Embed Events to get that; otherwise continue with the entity the repository returns.
+type EventSource interface {
+	PullEvents() []any
+}
+
+// EventRecorder an EventSource events can be recorded back into, e.g. when the transaction that
+// pulled them aborts and is retried
+type EventRecorder interface {
+	EventSource
+	Record(event any)
+}
+
+// Events an embeddable EventRecorder holding its events behind a pointer, so that copies of the
+// entity share them and draining one drains all. Embed it with a `bson:"-"` tag:
+//
+//	type Account struct {
+//		base_entity.Events `bson:"-"`
+//		Id bson.ObjectID `bson:"_id"`
+//	}
+type Events struct {
+	recorded *[]any
+}
+
+// Record add an event to publish after the entity's next write
+func (e *Events) Record(event any) {
+	if e.recorded == nil {
+		e.recorded = new([]any)
+	}
+	*e.recorded = append(*e.recorded, event)
+}
+
+// PullEvents return the recorded events and clear them for every copy of the entity
+func (e Events) PullEvents() []any {
+	if e.recorded == nil {
+		return nil
+	}
+
+	events := *e.recorded
+	*e.recorded = nil
+	return events
+}
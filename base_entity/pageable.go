@@ -1,5 +1,7 @@
 package base_entity
 
+import "go.mongodb.org/mongo-driver/v2/bson"
+
 type PageableDBResponse[T Entity] struct {
 	Data             []T
 	NumberPerPage    int64
@@ -11,4 +13,6 @@ type PageableDBResponse[T Entity] struct {
 type PageableDBRequest struct {
 	NumberPerPage int64
 	LastItemId    string
+	// Projection optionally limits the fields fetched for each item; the _id is always kept
+	Projection bson.M
 }
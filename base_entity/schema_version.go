@@ -0,0 +1,21 @@
+package base_entity
+
+// SchemaVersionField the document field holding the schema version of a stored document.
+// Documents without it are at version 0.
+const SchemaVersionField = "_schemaVersion"
+
+// SchemaVersioned an entity whose stored shape changes over time. SchemaVersion returns the
+// version the entity's struct decodes, and the entity stores it in a SchemaVersionField field:
+//
+//	type User struct {
+//		Id      bson.ObjectID `bson:"_id"`
+//		Version int           `bson:"_schemaVersion"`
+//	}
+//
+//	func (User) SchemaVersion() int { return 2 }
+//
+// Repository writes set the field to SchemaVersion, and older documents are upgraded on read by the
+// repository's Upgrader.
+type SchemaVersioned interface {
+	SchemaVersion() int
+}
@@ -0,0 +1,6 @@
+package base_entity
+
+type UpsertResult[T Entity] struct {
+	Entity   *T
+	Inserted bool
+}
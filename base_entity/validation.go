@@ -0,0 +1,42 @@
+package base_entity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validatable an entity checking its own invariants before it is written.
+// Validate may return a *ValidationError to report several fields at once. Any other error is
+// reported as a FieldError with an empty path.
+type Validatable interface {
+	Validate() error
+}
+
+// FieldError a field that failed a validation rule
+type FieldError struct {
+	// Path the dotted bson path of the field, e.g. address.city or tags.2
+	Path string
+	// Rule the rule that failed, e.g. required or min
+	Rule    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s %s", e.Path, e.Message)
+}
+
+// ValidationError every field error of an invalid entity
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Error()
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
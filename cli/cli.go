@@ -0,0 +1,250 @@
+// Package cli implements the mongo-data command line tool. cmd/mongo-data runs it as is; build
+// your own binary around App to add your migrations and index declarations:
+//
+//	func main() {
+//		users, _ := indexes.For[User]()
+//		cli.Main(cli.App{
+//			Migrations: migrate.Registered(),
+//			Indexes:    map[string][]indexes.Index{"users": users},
+//		})
+//	}
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/hub1989/mongo-data/v4/configuration"
+	"github.com/hub1989/mongo-data/v4/indexes"
+	"github.com/hub1989/mongo-data/v4/migrate"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Environment variables the connection flags default to. Every other client setting of
+// configuration.Config is read from the variables starting with EnvPrefix as well, e.g.
+// MONGO_MAX_POOL_SIZE.
+const (
+	EnvPrefix   = "MONGO"
+	URIEnv      = "MONGO_URI"
+	DatabaseEnv = "MONGO_DATABASE"
+	ConfigEnv   = "MONGO_CONFIG"
+)
+
+// ErrUsage returned for invalid command lines, after the usage has been printed
+var ErrUsage = errors.New("invalid usage")
+
+const usage = `Usage: mongo-data [flags] <command> [arguments]
+
+Commands:
+  migrate up|down|status|to     apply, revert or list migrations
+  indexes sync                  create the App's declared indexes and report drift
+  collections stats             document counts and sizes
+  export <collection>           write documents as extended JSON lines
+  import <collection>           insert extended JSON lines
+  explain <collection>          show the query plan of a filter
+
+Flags:
+`
+
+// App the command line tool with the migrations and index declarations it works with
+type App struct {
+	Migrations []migrate.Migration
+	// Indexes the declared indexes by collection name
+	Indexes map[string][]indexes.Index
+
+	// Stdin, Stdout and Stderr default to the process's
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Main run the app with the process's arguments and exit with its status
+func Main(app App) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := app.Run(ctx, os.Args[1:])
+	stop()
+
+	switch {
+	case errors.Is(err, ErrUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(app.stderr(), "error:", err)
+		os.Exit(1)
+	}
+}
+
+// Run execute the command line args.
+// Without -v the logger's level is raised to warnings while the command runs and restored after.
+func (a App) Run(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("mongo-data", flag.ContinueOnError)
+	flags.SetOutput(a.stderr())
+	flags.Usage = func() {
+		fmt.Fprint(a.stderr(), usage)
+		flags.PrintDefaults()
+	}
+
+	uri := flags.String("uri", os.Getenv(URIEnv), "connection string (env "+URIEnv+")")
+	database := flags.String("database", os.Getenv(DatabaseEnv), "database name (env "+DatabaseEnv+")")
+	configFile := flags.String("config", os.Getenv(ConfigEnv), "YAML client configuration file (env "+ConfigEnv+")")
+	verbose := flags.Bool("v", false, "log what the library does")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return ErrUsage
+	}
+
+	if !*verbose && log.IsLevelEnabled(log.InfoLevel) {
+		defer log.SetLevel(log.GetLevel())
+		log.SetLevel(log.WarnLevel)
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ErrUsage
+	}
+
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	run, ok := map[string]func(context.Context, *session, []string) error{
+		"migrate":     a.migrate,
+		"indexes":     a.indexes,
+		"collections": a.collections,
+		"export":      a.export,
+		"import":      a.importLines,
+		"explain":     a.explain,
+	}[command]
+	if !ok {
+		fmt.Fprintf(a.stderr(), "unknown command %q\n", command)
+		flags.Usage()
+		return ErrUsage
+	}
+
+	s := &session{uri: *uri, database: *database, configFile: *configFile}
+	defer s.close()
+
+	return run(ctx, s, rest)
+}
+
+// session connects lazily, so commands failing on their arguments do not need a server
+type session struct {
+	uri        string
+	database   string
+	configFile string
+	client     *mongo.Client
+}
+
+func (s *session) db() (*mongo.Database, error) {
+	if s.client != nil {
+		return s.client.Database(s.database), nil
+	}
+
+	config, err := s.config()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := configuration.NewDBConfigService(config).ConnectDB()
+	if err != nil {
+		return nil, fmt.Errorf("could not connect: %w", err)
+	}
+
+	s.client, s.database = client, config.Database
+	return client.Database(s.database), nil
+}
+
+// config the client configuration from the -config file and the environment, with the
+// connection flags applied over it
+func (s *session) config() (*configuration.Config, error) {
+	config := &configuration.Config{}
+	if s.configFile != "" {
+		if err := config.LoadFile(s.configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.LoadEnv(EnvPrefix); err != nil {
+		return nil, err
+	}
+
+	if s.uri != "" {
+		config.URI = s.uri
+	}
+	if s.database != "" {
+		config.Database = s.database
+	}
+	if config.URI == "" || config.Database == "" {
+		return nil, fmt.Errorf("set -uri and -database, %s and %s, or uri and database in the -config file", URIEnv, DatabaseEnv)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (s *session) close() {
+	if s.client != nil {
+		_ = s.client.Disconnect(context.Background())
+	}
+}
+
+// subcommand a flag set for a command, printing its usage on errors
+func (a App) subcommand(name, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(a.stderr())
+	flags.Usage = func() {
+		fmt.Fprintf(a.stderr(), "Usage: mongo-data %s %s\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse the command's flags, which may follow its positional arguments
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, ErrUsage
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func (a App) usageError(flags *flag.FlagSet, format string, args ...any) error {
+	fmt.Fprintf(a.stderr(), strings.TrimSuffix(format, "\n")+"\n", args...)
+	flags.Usage()
+	return ErrUsage
+}
+
+func (a App) stdin() io.Reader {
+	if a.Stdin != nil {
+		return a.Stdin
+	}
+	return os.Stdin
+}
+
+func (a App) stdout() io.Writer {
+	if a.Stdout != nil {
+		return a.Stdout
+	}
+	return os.Stdout
+}
+
+func (a App) stderr() io.Writer {
+	if a.Stderr != nil {
+		return a.Stderr
+	}
+	return os.Stderr
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hub1989/mongo-data/v4/configuration"
+	"github.com/hub1989/mongo-data/v4/indexes"
+	"github.com/hub1989/mongo-data/v4/migrate"
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func run(app App, args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	app.Stdout, app.Stderr = &stdout, &stderr
+	err := app.Run(context.Background(), args)
+	return stdout.String(), stderr.String(), err
+}
+
+func TestRun_Usage(t *testing.T) {
+	_, stderr, err := run(App{})
+	assert.ErrorIs(t, err, ErrUsage)
+	assert.Contains(t, stderr, "Usage: mongo-data")
+
+	_, stderr, err = run(App{}, "frobnicate")
+	assert.ErrorIs(t, err, ErrUsage)
+	assert.Contains(t, stderr, `unknown command "frobnicate"`)
+
+	_, stderr, err = run(App{}, "migrate", "sideways")
+	assert.ErrorIs(t, err, ErrUsage)
+	assert.Contains(t, stderr, `unknown migrate action "sideways"`)
+
+	_, stderr, err = run(App{}, "migrate", "to", "latest")
+	assert.ErrorIs(t, err, ErrUsage)
+	assert.Contains(t, stderr, `invalid version "latest"`)
+
+	_, stderr, err = run(App{}, "explain", "users", "-filter", "{bad")
+	assert.ErrorIs(t, err, ErrUsage)
+	assert.Contains(t, stderr, "invalid -filter")
+
+	_, _, err = run(App{}, "-h")
+	assert.Nil(t, err)
+}
+
+func TestRun_RestoresLogLevel(t *testing.T) {
+	defer log.SetLevel(log.GetLevel())
+	log.SetLevel(log.DebugLevel)
+
+	_, _, err := run(App{}, "frobnicate")
+	assert.ErrorIs(t, err, ErrUsage)
+	assert.Equal(t, log.DebugLevel, log.GetLevel())
+}
+
+func TestRun_NeedsConnection(t *testing.T) {
+	t.Setenv(URIEnv, "")
+	t.Setenv(DatabaseEnv, "")
+
+	_, _, err := run(App{}, "migrate", "status")
+	assert.ErrorContains(t, err, "set -uri and -database")
+
+	_, _, err = run(App{}, "indexes", "sync")
+	assert.ErrorContains(t, err, "no index declarations")
+
+	file := filepath.Join(t.TempDir(), "mongo.yaml")
+	assert.Nil(t, os.WriteFile(file, []byte("uri: mongodb://localhost:27017\ndatabase: app\nreadPreference: sideways\n"), 0o600))
+	_, _, err = run(App{}, "-config", file, "migrate", "status")
+	assert.ErrorIs(t, err, configuration.ErrInvalidConfig)
+	assert.ErrorContains(t, err, `unknown readPreference "sideways"`)
+}
+
+func TestHumanSize(t *testing.T) {
+	assert.Equal(t, "512 B", humanSize(512))
+	assert.Equal(t, "1.5 KiB", humanSize(1536))
+	assert.Equal(t, "3.0 GiB", humanSize(3<<30))
+}
+
+func TestCommands(t *testing.T) {
+	server := mongotest.Start(t)
+	database := server.Database(t)
+	ctx := context.Background()
+
+	declared := []indexes.Index{{Keys: bson.D{{Key: "name", Value: 1}}, Unique: true}}
+	app := App{
+		Migrations: []migrate.Migration{{
+			Version:     1,
+			Description: "create users",
+			Up: func(ctx context.Context, database *mongo.Database) error {
+				return database.CreateCollection(ctx, "users")
+			},
+		}},
+		Indexes: map[string][]indexes.Index{"users": declared},
+	}
+	connection := []string{"-uri", server.URI, "-database", database.Name()}
+
+	stdout, _, err := run(app, append(connection, "migrate", "up")...)
+	assert.Nil(t, err)
+	assert.Equal(t, "applied 1\n", stdout)
+
+	stdout, _, err = run(app, append(connection, "migrate", "status")...)
+	assert.Nil(t, err)
+	assert.Contains(t, stdout, "create users")
+
+	stdout, _, err = run(app, append(connection, "indexes", "sync", "-dry-run")...)
+	assert.Nil(t, err)
+	assert.Contains(t, stdout, "users: would create name_1")
+
+	_, _, err = run(app, append(connection, "indexes", "sync")...)
+	assert.Nil(t, err)
+
+	lines := `{"_id":{"$oid":"65a4f1c2e4b0a1b2c3d4e5f6"},"name":"alice","age":30}` + "\n\n" +
+		`{"_id":{"$oid":"65a4f1c2e4b0a1b2c3d4e5f7"},"name":"bob","age":{"$numberLong":"41"}}` + "\n"
+	app.Stdin = strings.NewReader(lines)
+	_, stderr, err := run(app, append(connection, "import", "users", "-batch", "1")...)
+	assert.Nil(t, err)
+	assert.Contains(t, stderr, "imported 2 documents")
+
+	stdout, _, err = run(app, append(connection, "export", "users", "-filter", `{"age":{"$gt":35}}`)...)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"_id":{"$oid":"65a4f1c2e4b0a1b2c3d4e5f7"},"name":"bob","age":41}`+"\n", stdout)
+
+	stdout, _, err = run(app, append(connection, "explain", "users", "-filter", `{"name":"bob"}`)...)
+	assert.Nil(t, err)
+	assert.Contains(t, stdout, "name_1")
+
+	stdout, _, err = run(app, append(connection, "collections", "stats")...)
+	assert.Nil(t, err)
+	assert.Contains(t, stdout, "users")
+
+	count, err := database.Collection("users").CountDocuments(ctx, bson.M{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+}
@@ -0,0 +1,299 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (a App) collections(ctx context.Context, s *session, args []string) error {
+	flags := a.subcommand("collections", "stats [collection...]")
+
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || positional[0] != "stats" {
+		return a.usageError(flags, "unknown or missing collections action")
+	}
+
+	database, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	names := positional[1:]
+	if len(names) == 0 {
+		filter := bson.M{"type": "collection", "name": bson.M{"$not": bson.Regex{Pattern: "^system\\."}}}
+		if names, err = database.ListCollectionNames(ctx, filter, options.ListCollections().SetNameOnly(true)); err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(a.stdout(), 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "COLLECTION\tDOCUMENTS\tSIZE\tSTORAGE\tINDEXES\tINDEX SIZE\t")
+	for _, name := range names {
+		stats, err := collectionStats(ctx, database.Collection(name))
+		if err != nil {
+			return fmt.Errorf("could not read stats of %s: %w", name, err)
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\t\n", name, stats.Count, humanSize(stats.Size), humanSize(stats.StorageSize), stats.Indexes, humanSize(stats.TotalIndexSize))
+	}
+	return w.Flush()
+}
+
+// storageStats the part of $collStats the stats command prints
+type storageStats struct {
+	Count          int64 `bson:"count"`
+	Size           int64 `bson:"size"`
+	StorageSize    int64 `bson:"storageSize"`
+	Indexes        int64 `bson:"nindexes"`
+	TotalIndexSize int64 `bson:"totalIndexSize"`
+}
+
+func collectionStats(ctx context.Context, collection *mongo.Collection) (storageStats, error) {
+	pipeline := mongo.Pipeline{{{Key: "$collStats", Value: bson.M{"storageStats": bson.M{}}}}}
+
+	var results []struct {
+		StorageStats storageStats `bson:"storageStats"`
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return storageStats{}, err
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return storageStats{}, err
+	}
+	if len(results) == 0 {
+		return storageStats{}, nil
+	}
+	return results[0].StorageStats, nil
+}
+
+// humanSize a size in human units
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value, exponent := float64(size)/unit, 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exponent])
+}
+
+func (a App) export(ctx context.Context, s *session, args []string) error {
+	flags := a.subcommand("export", "<collection> [-filter json] [-out file] [-canonical]")
+	filterJSON := flags.String("filter", "{}", "extended JSON query selecting the documents")
+	out := flags.String("out", "-", "file to write, - for stdout")
+	canonical := flags.Bool("canonical", false, "write canonical instead of relaxed extended JSON")
+
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return a.usageError(flags, "export takes one collection")
+	}
+
+	filter, err := document(*filterJSON)
+	if err != nil {
+		return a.usageError(flags, "invalid -filter: %v", err)
+	}
+
+	database, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	w := a.stdout()
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	cursor, err := database.Collection(positional[0]).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	buffered := bufio.NewWriter(w)
+	count := 0
+	for cursor.Next(ctx) {
+		line, err := bson.MarshalExtJSON(cursor.Current, *canonical, false)
+		if err != nil {
+			return err
+		}
+		buffered.Write(line)
+		buffered.WriteByte('\n')
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.stderr(), "exported %d documents from %s\n", count, positional[0])
+	return nil
+}
+
+func (a App) importLines(ctx context.Context, s *session, args []string) error {
+	flags := a.subcommand("import", "<collection> [-in file] [-drop] [-batch size]")
+	in := flags.String("in", "-", "file to read, - for stdin")
+	drop := flags.Bool("drop", false, "drop the collection first")
+	batchSize := flags.Int("batch", 1000, "documents per insert")
+
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *batchSize < 1 {
+		return a.usageError(flags, "import takes one collection and a positive batch size")
+	}
+
+	r := a.stdin()
+	if *in != "-" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	database, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	collection := database.Collection(positional[0])
+	if *drop {
+		if err := collection.Drop(ctx); err != nil {
+			return err
+		}
+	}
+
+	count, err := importDocuments(ctx, collection, r, *batchSize)
+	fmt.Fprintf(a.stderr(), "imported %d documents into %s\n", count, positional[0])
+	return err
+}
+
+// importDocuments insert the extended JSON lines of r in batches
+func importDocuments(ctx context.Context, collection *mongo.Collection, r io.Reader, batchSize int) (int, error) {
+	scanner := bufio.NewScanner(r)
+	// a line holds a whole document, which may be up to 16MB
+	scanner.Buffer(make([]byte, 0, 64*1024), 17*1024*1024)
+
+	count, line := 0, 0
+	batch := make([]any, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := collection.InsertMany(ctx, batch); err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var document bson.D
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), false, &document); err != nil {
+			return count, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		batch = append(batch, document)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+	return count, flush()
+}
+
+func (a App) explain(ctx context.Context, s *session, args []string) error {
+	flags := a.subcommand("explain", "<collection> -filter json [-sort json] [-verbosity mode]")
+	filterJSON := flags.String("filter", "{}", "extended JSON query to explain")
+	sortJSON := flags.String("sort", "", "extended JSON sort")
+	verbosity := flags.String("verbosity", "queryPlanner", "queryPlanner, executionStats or allPlansExecution")
+
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return a.usageError(flags, "explain takes one collection")
+	}
+
+	filter, err := document(*filterJSON)
+	if err != nil {
+		return a.usageError(flags, "invalid -filter: %v", err)
+	}
+
+	find := bson.D{{Key: "find", Value: positional[0]}, {Key: "filter", Value: filter}}
+	if *sortJSON != "" {
+		sort, err := document(*sortJSON)
+		if err != nil {
+			return a.usageError(flags, "invalid -sort: %v", err)
+		}
+		find = append(find, bson.E{Key: "sort", Value: sort})
+	}
+
+	database, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	command := bson.D{{Key: "explain", Value: find}, {Key: "verbosity", Value: *verbosity}}
+	raw, err := database.RunCommand(ctx, command).Raw()
+	if err != nil {
+		return err
+	}
+
+	plan, err := bson.MarshalExtJSONIndent(raw, false, false, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(a.stdout(), string(plan))
+	return err
+}
+
+// document parse an extended JSON document given on the command line
+func document(extJSON string) (bson.D, error) {
+	d := bson.D{}
+	if err := bson.UnmarshalExtJSON([]byte(extJSON), false, &d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/hub1989/mongo-data/v4/indexes"
+)
+
+func (a App) indexes(ctx context.Context, s *session, args []string) error {
+	flags := a.subcommand("indexes", "sync [-dry-run] [-drop] [collection...]")
+	dryRun := flags.Bool("dry-run", false, "report what would change without changing anything")
+	drop := flags.Bool("drop", false, "drop indexes nothing declares")
+
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || positional[0] != "sync" {
+		return a.usageError(flags, "unknown or missing indexes action")
+	}
+
+	collections := positional[1:]
+	if len(collections) == 0 {
+		collections = slices.Sorted(maps.Keys(a.Indexes))
+	}
+	if len(collections) == 0 {
+		return fmt.Errorf("no index declarations: build your own binary around cli.App with Indexes set")
+	}
+
+	database, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		declared, ok := a.Indexes[collection]
+		if !ok {
+			return fmt.Errorf("no indexes are declared for %s", collection)
+		}
+
+		report, err := indexes.Sync(ctx, database.Collection(collection), declared, &indexes.SyncOptions{
+			DryRun:         *dryRun,
+			DropUndeclared: *drop,
+		})
+		if err != nil {
+			return err
+		}
+
+		a.printReport(collection, report, *dryRun)
+	}
+	return nil
+}
+
+func (a App) printReport(collection string, report *indexes.Report, dryRun bool) {
+	prefix := ""
+	if dryRun {
+		prefix = "would "
+	}
+
+	out := a.stdout()
+	for _, index := range report.Created {
+		fmt.Fprintf(out, "%s: %screate %s\n", collection, prefix, index)
+	}
+	for _, name := range report.Dropped {
+		fmt.Fprintf(out, "%s: %sdrop %s\n", collection, prefix, name)
+	}
+	for _, drift := range report.Drifted {
+		fmt.Fprintf(out, "%s: drift %s\n", collection, drift)
+	}
+	for _, name := range report.Undeclared {
+		fmt.Fprintf(out, "%s: undeclared %s\n", collection, name)
+	}
+	if report.InSync() {
+		fmt.Fprintf(out, "%s: in sync\n", collection)
+	}
+}
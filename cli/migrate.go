@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/migrate"
+)
+
+func (a App) migrate(ctx context.Context, s *session, args []string) error {
+	flags := a.subcommand("migrate", "up | down [-n count] | status | to <version>")
+	n := flags.Int("n", 1, "number of migrations down reverts")
+	collection := flags.String("collection", migrate.DefaultCollection, "collection recording the migrations")
+	wait := flags.Duration("lock-wait", time.Minute, "how long to wait for another migrator")
+
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return a.usageError(flags, "missing migrate action")
+	}
+
+	action := positional[0]
+	var target int64
+	switch {
+	case action == "to" && len(positional) == 2:
+		if target, err = strconv.ParseInt(positional[1], 10, 64); err != nil {
+			return a.usageError(flags, "invalid version %q", positional[1])
+		}
+	case action == "up" || action == "down" || action == "status":
+		if len(positional) != 1 {
+			return a.usageError(flags, "migrate %s takes no arguments", action)
+		}
+	default:
+		return a.usageError(flags, "unknown migrate action %q", action)
+	}
+
+	database, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	migrator := migrate.New(database, a.Migrations...)
+	migrator.Collection = *collection
+	migrator.Lock.Wait = *wait
+
+	var changed []int64
+	switch action {
+	case "status":
+		return a.printStatus(ctx, migrator)
+	case "up":
+		changed, err = migrator.Up(ctx)
+	case "down":
+		changed, err = migrator.Down(ctx, *n)
+	case "to":
+		changed, err = migrator.To(ctx, target)
+	}
+
+	for _, version := range changed {
+		verb := "applied"
+		if action == "down" || action == "to" && version > target {
+			verb = "reverted"
+		}
+		fmt.Fprintf(a.stdout(), "%s %d\n", verb, version)
+	}
+	if err == nil && len(changed) == 0 {
+		fmt.Fprintln(a.stdout(), "nothing to do")
+	}
+	return err
+}
+
+func (a App) printStatus(ctx context.Context, migrator *migrate.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(a.stdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATUS\tAPPLIED AT\tDESCRIPTION")
+	for _, status := range statuses {
+		state, appliedAt := "pending", ""
+		if status.Applied {
+			state, appliedAt = "applied", status.AppliedAt.Format(time.RFC3339)
+		}
+		if !status.Registered {
+			state = "unknown"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, state, appliedAt, status.Description)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,13 @@
+// Command mongo-data runs migrations and inspects collections.
+// It declares no indexes, so indexes sync needs a binary of your own built around package cli with
+// App.Indexes set.
+package main
+
+import (
+	"github.com/hub1989/mongo-data/v4/cli"
+	"github.com/hub1989/mongo-data/v4/migrate"
+)
+
+func main() {
+	cli.Main(cli.App{Migrations: migrate.Registered()})
+}
@@ -0,0 +1,316 @@
+package configuration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+)
+
+// ErrInvalidConfig returned by Validate and Load for configurations that cannot connect
+var ErrInvalidConfig = errors.New("invalid mongo configuration")
+
+// Config the settings of a mongo client. Zero values keep the driver's defaults, and settings
+// given here take precedence over the same options in the URI.
+// The yaml tags name the keys of a configuration file and the env tags the environment variables
+// LoadEnv reads, after the prefix: MONGO_MAX_POOL_SIZE or MONGO_TLS_CA_FILE for the prefix MONGO.
+type Config struct {
+	URI      string `yaml:"uri" env:"URI"`
+	Database string `yaml:"database" env:"DATABASE"`
+	AppName  string `yaml:"appName" env:"APP_NAME"`
+
+	MinPoolSize     uint64        `yaml:"minPoolSize" env:"MIN_POOL_SIZE"`
+	MaxPoolSize     uint64        `yaml:"maxPoolSize" env:"MAX_POOL_SIZE"`
+	MaxConnecting   uint64        `yaml:"maxConnecting" env:"MAX_CONNECTING"`
+	MaxConnIdleTime time.Duration `yaml:"maxConnIdleTime" env:"MAX_CONN_IDLE_TIME"`
+
+	ConnectTimeout         time.Duration `yaml:"connectTimeout" env:"CONNECT_TIMEOUT"`
+	ServerSelectionTimeout time.Duration `yaml:"serverSelectionTimeout" env:"SERVER_SELECTION_TIMEOUT"`
+	// SocketTimeout bounds every operation. The v2 driver replaced the socket timeout with the
+	// client-side operation timeout, which this sets.
+	SocketTimeout time.Duration `yaml:"socketTimeout" env:"SOCKET_TIMEOUT"`
+
+	// Compressors snappy, zlib or zstd, in order of preference
+	Compressors []string `yaml:"compressors" env:"COMPRESSORS"`
+
+	// ReadPreference primary, primaryPreferred, secondary, secondaryPreferred or nearest
+	ReadPreference string `yaml:"readPreference" env:"READ_PREFERENCE"`
+	// MaxStaleness how far behind the primary a secondary may be to be read from
+	MaxStaleness time.Duration `yaml:"maxStaleness" env:"MAX_STALENESS"`
+	// ReadConcern local, available, majority, linearizable or snapshot
+	ReadConcern string `yaml:"readConcern" env:"READ_CONCERN"`
+	// WriteConcern majority, a number of members or a tag set name
+	WriteConcern string `yaml:"writeConcern" env:"WRITE_CONCERN"`
+	Journal      *bool  `yaml:"journal" env:"JOURNAL"`
+
+	RetryWrites *bool `yaml:"retryWrites" env:"RETRY_WRITES"`
+	RetryReads  *bool `yaml:"retryReads" env:"RETRY_READS"`
+
+	TLS  TLSConfig  `yaml:"tls" env:"TLS"`
+	Auth AuthConfig `yaml:"auth" env:"AUTH"`
+}
+
+// TLSConfig the TLS settings of a Config. Setting a file enables TLS.
+type TLSConfig struct {
+	Enabled bool `yaml:"enabled" env:"ENABLED"`
+	// CAFile PEM certificates of the authorities the server certificate is checked against
+	CAFile string `yaml:"caFile" env:"CA_FILE"`
+	// CertificateKeyFile PEM client certificate and private key, e.g. for MONGODB-X509
+	CertificateKeyFile string `yaml:"certificateKeyFile" env:"CERTIFICATE_KEY_FILE"`
+	// Insecure skip the verification of the server certificate
+	Insecure bool `yaml:"insecure" env:"INSECURE"`
+}
+
+// AuthConfig the credentials of a Config
+type AuthConfig struct {
+	// Mechanism e.g. SCRAM-SHA-256, MONGODB-X509 or MONGODB-AWS. Empty lets the server negotiate.
+	Mechanism string `yaml:"mechanism" env:"MECHANISM"`
+	Source    string `yaml:"source" env:"SOURCE"`
+	Username  string `yaml:"username" env:"USERNAME"`
+	Password  string `yaml:"password" env:"PASSWORD"`
+}
+
+var (
+	compressors    = []string{"snappy", "zlib", "zstd"}
+	readConcerns   = []string{"local", "available", "majority", "linearizable", "snapshot"}
+	authMechanisms = []string{"SCRAM-SHA-1", "SCRAM-SHA-256", "MONGODB-X509", "MONGODB-AWS", "MONGODB-OIDC", "GSSAPI", "PLAIN"}
+	noPassword     = []string{"MONGODB-X509", "MONGODB-OIDC"}
+)
+
+// enabled reports whether TLS is enabled explicitly or by a file
+func (t TLSConfig) enabled() bool {
+	return t.Enabled || t.CAFile != "" || t.CertificateKeyFile != ""
+}
+
+// Validate check the configuration, reporting every problem at once
+func (c Config) Validate() error {
+	var problems []error
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.URI == "" {
+		problem("uri is required")
+	} else if err := options.Client().ApplyURI(c.URI).Validate(); err != nil {
+		problem("uri: %w", err)
+	}
+
+	if c.MaxPoolSize > 0 && c.MinPoolSize > c.MaxPoolSize {
+		problem("minPoolSize %d is above maxPoolSize %d", c.MinPoolSize, c.MaxPoolSize)
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"maxConnIdleTime", c.MaxConnIdleTime},
+		{"connectTimeout", c.ConnectTimeout},
+		{"serverSelectionTimeout", c.ServerSelectionTimeout},
+		{"socketTimeout", c.SocketTimeout},
+		{"maxStaleness", c.MaxStaleness},
+	}
+	for _, duration := range durations {
+		if duration.value < 0 {
+			problem("%s must not be negative", duration.name)
+		}
+	}
+
+	for _, compressor := range c.Compressors {
+		if !slices.Contains(compressors, compressor) {
+			problem("unknown compressor %q", compressor)
+		}
+	}
+
+	if c.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(c.ReadPreference)
+		if err != nil {
+			problem("unknown readPreference %q", c.ReadPreference)
+		} else if mode == readpref.PrimaryMode && c.MaxStaleness > 0 {
+			problem("maxStaleness cannot be used with the primary readPreference")
+		}
+	}
+	if c.ReadConcern != "" && !slices.Contains(readConcerns, c.ReadConcern) {
+		problem("unknown readConcern %q", c.ReadConcern)
+	}
+	if w, err := strconv.Atoi(c.WriteConcern); err == nil {
+		if w < 0 {
+			problem("writeConcern must not be negative")
+		}
+		if w == 0 && c.Journal != nil && *c.Journal {
+			problem("journal cannot be requested for an unacknowledged writeConcern")
+		}
+	}
+
+	if c.TLS.CAFile != "" {
+		if _, err := os.Stat(c.TLS.CAFile); err != nil {
+			problem("tls.caFile: %w", err)
+		}
+	}
+	if c.TLS.CertificateKeyFile != "" {
+		if _, err := os.Stat(c.TLS.CertificateKeyFile); err != nil {
+			problem("tls.certificateKeyFile: %w", err)
+		}
+	}
+
+	if c.Auth.Mechanism != "" && !slices.Contains(authMechanisms, c.Auth.Mechanism) {
+		problem("unknown auth mechanism %q", c.Auth.Mechanism)
+	}
+	if c.Auth.Password != "" && c.Auth.Username == "" {
+		problem("auth password is set without a username")
+	}
+	if slices.Contains(noPassword, c.Auth.Mechanism) && c.Auth.Password != "" {
+		problem("auth mechanism %s takes no password", c.Auth.Mechanism)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(problems...))
+}
+
+// ClientOptions the driver options for the configuration, starting from its URI
+func (c Config) ClientOptions() (*options.ClientOptions, error) {
+	clientOptions := options.Client().ApplyURI(c.URI)
+
+	if c.AppName != "" {
+		clientOptions.SetAppName(c.AppName)
+	}
+	if c.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(c.MinPoolSize)
+	}
+	if c.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(c.MaxPoolSize)
+	}
+	if c.MaxConnecting > 0 {
+		clientOptions.SetMaxConnecting(c.MaxConnecting)
+	}
+	if c.MaxConnIdleTime > 0 {
+		clientOptions.SetMaxConnIdleTime(c.MaxConnIdleTime)
+	}
+	if c.ConnectTimeout > 0 {
+		clientOptions.SetConnectTimeout(c.ConnectTimeout)
+	}
+	if c.ServerSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(c.ServerSelectionTimeout)
+	}
+	if c.SocketTimeout > 0 {
+		clientOptions.SetTimeout(c.SocketTimeout)
+	}
+	if len(c.Compressors) > 0 {
+		clientOptions.SetCompressors(c.Compressors)
+	}
+
+	if c.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(c.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+
+		var readPrefOptions []readpref.Option
+		if c.MaxStaleness > 0 {
+			readPrefOptions = append(readPrefOptions, readpref.WithMaxStaleness(c.MaxStaleness))
+		}
+		readPreference, err := readpref.New(mode, readPrefOptions...)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetReadPreference(readPreference)
+	}
+	if c.ReadConcern != "" {
+		clientOptions.SetReadConcern(&readconcern.ReadConcern{Level: c.ReadConcern})
+	}
+	if c.WriteConcern != "" || c.Journal != nil {
+		clientOptions.SetWriteConcern(c.writeConcern())
+	}
+
+	if c.RetryWrites != nil {
+		clientOptions.SetRetryWrites(*c.RetryWrites)
+	}
+	if c.RetryReads != nil {
+		clientOptions.SetRetryReads(*c.RetryReads)
+	}
+
+	if c.TLS.enabled() {
+		tlsConfig, err := c.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	if c.Auth != (AuthConfig{}) {
+		clientOptions.SetAuth(c.credential(clientOptions.Auth))
+	}
+
+	return clientOptions, nil
+}
+
+func (c Config) writeConcern() *writeconcern.WriteConcern {
+	concern := &writeconcern.WriteConcern{Journal: c.Journal}
+	if w, err := strconv.Atoi(c.WriteConcern); err == nil {
+		concern.W = w
+	} else if c.WriteConcern != "" {
+		concern.W = c.WriteConcern
+	}
+	return concern
+}
+
+// credential the auth settings merged over the credential of the URI
+func (c Config) credential(fromURI *options.Credential) options.Credential {
+	var credential options.Credential
+	if fromURI != nil {
+		credential = *fromURI
+	}
+
+	if c.Auth.Mechanism != "" {
+		credential.AuthMechanism = c.Auth.Mechanism
+	}
+	if c.Auth.Source != "" {
+		credential.AuthSource = c.Auth.Source
+	}
+	if c.Auth.Username != "" {
+		credential.Username = c.Auth.Username
+	}
+	if c.Auth.Password != "" {
+		credential.Password = c.Auth.Password
+		credential.PasswordSet = true
+	}
+	return credential
+}
+
+// config the tls.Config with the configured files loaded
+func (t TLSConfig) config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: t.Insecure}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if t.CertificateKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(t.CertificateKeyFile, t.CertificateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load %s: %w", t.CertificateKeyFile, err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
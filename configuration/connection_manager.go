@@ -0,0 +1,268 @@
+package configuration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+// ErrUnknownConnection returned for a connection name that was not registered
+var ErrUnknownConnection = errors.New("unknown connection")
+
+// ErrManagerClosed returned by a ConnectionManager after Close
+var ErrManagerClosed = errors.New("connection manager is closed")
+
+// DefaultConnection the name to register a service's only connection under
+const DefaultConnection = "default"
+
+// RetryOptions how a ConnectionManager retries connecting
+type RetryOptions struct {
+	// Attempts the number of connection attempts. Defaults to 5.
+	Attempts int
+	// InitialBackoff the wait after the first failed attempt, doubling after each further one.
+	// Defaults to 500ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts. Defaults to 10s.
+	MaxBackoff time.Duration
+}
+
+// ConnectionManager owns the clients of named connections: it connects each one when it is first
+// used, retrying with backoff, checks them with Ping and disconnects them all on Close.
+// It is safe for concurrent use.
+type ConnectionManager struct {
+	Retry RetryOptions
+	// Configure is called with the options of each connection before it connects, e.g. to add
+	// event monitors. Set it before any connection is used.
+	Configure func(name string, clientOptions *options.ClientOptions)
+	// ConnectReadPref the members a connection attempt pings before the connection counts as
+	// connected. Defaults to the primary; readpref.Nearest() connects while a replica set elects one.
+	ConnectReadPref *readpref.ReadPref
+
+	mu          sync.Mutex
+	connections map[string]*connection
+	closed      bool
+}
+
+// connection a registered configuration and its client once connected
+type connection struct {
+	mu     sync.Mutex
+	config Config
+	client *mongo.Client
+}
+
+// NewConnectionManager create a manager without connections
+func NewConnectionManager() *ConnectionManager {
+	return &ConnectionManager{connections: make(map[string]*connection)}
+}
+
+// Register add a connection under name, validating its configuration. Nothing connects yet.
+func (m *ConnectionManager) Register(name string, config *Config) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("connection %s: %w", name, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrManagerClosed
+	}
+	if _, ok := m.connections[name]; ok {
+		return fmt.Errorf("connection %s is already registered", name)
+	}
+
+	m.connections[name] = &connection{config: *config}
+	return nil
+}
+
+// Names the registered connection names, sorted
+func (m *ConnectionManager) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(m.connections))
+}
+
+// Client the client of the named connection, connecting it first if needed.
+// Returns ErrUnknownConnection for names that were not registered.
+func (m *ConnectionManager) Client(ctx context.Context, name string) (*mongo.Client, error) {
+	conn, err := m.connection(name)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.client != nil {
+		return conn.client, nil
+	}
+
+	// Close may have run while this waited for a concurrent connect
+	if m.isClosed() {
+		return nil, ErrManagerClosed
+	}
+
+	client, err := m.connect(ctx, name, conn.config)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.client = client
+	return client, nil
+}
+
+// Database the configured database of the named connection, connecting it first if needed
+func (m *ConnectionManager) Database(ctx context.Context, name string) (*mongo.Database, error) {
+	conn, err := m.connection(name)
+	if err != nil {
+		return nil, err
+	}
+	if conn.config.Database == "" {
+		return nil, fmt.Errorf("connection %s has no database configured", name)
+	}
+
+	client, err := m.Client(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return client.Database(conn.config.Database), nil
+}
+
+// Ping check that the primary of the named connection answers, connecting it first if needed
+func (m *ConnectionManager) Ping(ctx context.Context, name string) error {
+	client, err := m.Client(ctx, name)
+	if err != nil {
+		return err
+	}
+	return client.Ping(ctx, readpref.Primary())
+}
+
+// PingAll ping every registered connection, returning the errors by connection name.
+// The map is empty when all of them are healthy.
+func (m *ConnectionManager) PingAll(ctx context.Context) map[string]error {
+	failures := make(map[string]error)
+	for _, name := range m.Names() {
+		if err := m.Ping(ctx, name); err != nil {
+			failures[name] = err
+		}
+	}
+	return failures
+}
+
+// Close disconnect every connected client, waiting for in-flight operations until ctx is done.
+// The manager cannot be used afterwards.
+func (m *ConnectionManager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	connections := slices.Collect(maps.Values(m.connections))
+	m.mu.Unlock()
+
+	var errs []error
+	for _, conn := range connections {
+		conn.mu.Lock()
+		if conn.client != nil {
+			if err := conn.client.Disconnect(ctx); err != nil {
+				errs = append(errs, err)
+			}
+			conn.client = nil
+		}
+		conn.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (m *ConnectionManager) connection(name string) (*connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrManagerClosed
+	}
+
+	conn, ok := m.connections[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownConnection, name)
+	}
+	return conn, nil
+}
+
+func (m *ConnectionManager) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+// connect create the client of a connection, retrying with exponential backoff
+func (m *ConnectionManager) connect(ctx context.Context, name string, config Config) (*mongo.Client, error) {
+	clientOptions, err := config.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	if m.Configure != nil {
+		m.Configure(name, clientOptions)
+	}
+
+	retry := m.retryOptions()
+	backoff := retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		client, err := connect(ctx, clientOptions, m.connectReadPref())
+		if err == nil {
+			log.WithFields(log.Fields{
+				"connection": name,
+				"attempt":    attempt,
+			}).Info("connected to DB: ", config.Database)
+			return client, nil
+		}
+
+		if attempt == retry.Attempts {
+			return nil, fmt.Errorf("could not connect %s after %d attempts: %w", name, attempt, err)
+		}
+
+		log.WithError(err).WithFields(log.Fields{
+			"connection": name,
+			"attempt":    attempt,
+		}).Warn("could not connect, retrying in ", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("could not connect %s: %w", name, errors.Join(ctx.Err(), err))
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, retry.MaxBackoff)
+	}
+}
+
+func (m *ConnectionManager) connectReadPref() *readpref.ReadPref {
+	if m.ConnectReadPref == nil {
+		return readpref.Primary()
+	}
+	return m.ConnectReadPref
+}
+
+func (m *ConnectionManager) retryOptions() RetryOptions {
+	retry := m.Retry
+	if retry.Attempts <= 0 {
+		retry.Attempts = 5
+	}
+	if retry.InitialBackoff <= 0 {
+		retry.InitialBackoff = 500 * time.Millisecond
+	}
+	if retry.MaxBackoff <= 0 {
+		retry.MaxBackoff = 10 * time.Second
+	}
+	return retry
+}
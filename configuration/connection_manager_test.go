@@ -0,0 +1,82 @@
+package configuration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+func TestConnectionManager_Register(t *testing.T) {
+	manager := NewConnectionManager()
+
+	assert.Nil(t, manager.Register("orders", &Config{URI: "mongodb://localhost", Database: "orders"}))
+	assert.Nil(t, manager.Register(DefaultConnection, &Config{URI: "mongodb://localhost"}))
+	assert.ErrorContains(t, manager.Register("orders", &Config{URI: "mongodb://localhost"}), "already registered")
+	assert.ErrorIs(t, manager.Register("broken", &Config{}), ErrInvalidConfig)
+	assert.Equal(t, []string{DefaultConnection, "orders"}, manager.Names())
+
+	ctx := context.Background()
+	_, err := manager.Client(ctx, "billing")
+	assert.ErrorIs(t, err, ErrUnknownConnection)
+
+	_, err = manager.Database(ctx, DefaultConnection)
+	assert.ErrorContains(t, err, "has no database configured")
+
+	assert.Nil(t, manager.Close(ctx))
+	assert.Nil(t, manager.Close(ctx))
+	_, err = manager.Client(ctx, "orders")
+	assert.ErrorIs(t, err, ErrManagerClosed)
+	assert.ErrorIs(t, manager.Register("late", &Config{URI: "mongodb://localhost"}), ErrManagerClosed)
+}
+
+func TestConnectionManager_Retry(t *testing.T) {
+	manager := NewConnectionManager()
+	manager.Retry = RetryOptions{Attempts: 3, InitialBackoff: 5 * time.Millisecond}
+	assert.Nil(t, manager.Register(DefaultConnection, &Config{URI: "mongodb://localhost:1", ServerSelectionTimeout: 20 * time.Millisecond}))
+
+	err := manager.Ping(context.Background(), DefaultConnection)
+	assert.ErrorContains(t, err, "could not connect default after 3 attempts")
+
+	failures := manager.PingAll(context.Background())
+	assert.Len(t, failures, 1)
+
+	manager.Retry = RetryOptions{Attempts: 100, InitialBackoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = manager.Client(ctx, DefaultConnection)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestConnectionManager_ConnectReadPref(t *testing.T) {
+	manager := NewConnectionManager()
+	assert.Equal(t, readpref.PrimaryMode, manager.connectReadPref().Mode())
+
+	manager.ConnectReadPref = readpref.Nearest()
+	assert.Equal(t, readpref.NearestMode, manager.connectReadPref().Mode())
+}
+
+func TestConnectionManager(t *testing.T) {
+	server := mongotest.Start(t)
+	ctx := context.Background()
+
+	manager := NewConnectionManager()
+	assert.Nil(t, manager.Register(DefaultConnection, &Config{URI: server.URI, Database: "app", AppName: "manager-test"}))
+
+	database, err := manager.Database(ctx, DefaultConnection)
+	assert.Nil(t, err)
+	assert.Equal(t, "app", database.Name())
+
+	client, err := manager.Client(ctx, DefaultConnection)
+	assert.Nil(t, err)
+	assert.Same(t, database.Client(), client)
+
+	assert.Nil(t, manager.Ping(ctx, DefaultConnection))
+	assert.Empty(t, manager.PingAll(ctx))
+
+	assert.Nil(t, manager.Close(ctx))
+	assert.NotNil(t, client.Ping(ctx, nil))
+}
@@ -10,42 +10,85 @@ import (
 	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
 )
 
-// DBConfigService configuration interface for
+// DBConfigService connects to a database and hands out its collections
 type DBConfigService interface {
-	ConnectDB(mongoURI string) (*mongo.Client, error)
+	ConnectDB() (*mongo.Client, error)
 	GetCollection(client *mongo.Client, collectionName string) *mongo.Collection
-	CreateIndexes(db *mongo.Client) error
+	CreateIndexesForCollection(db *mongo.Client, collectionName string, indexes ...mongo.IndexModel) error
 }
 
+var _ DBConfigService = DefaultDBConfigService{}
+
 // DefaultDBConfigService a default bare-bones configuration service.
 // You can pass your own implementation
 type DefaultDBConfigService struct {
 	MongoURI     string
 	DatabaseName string
+
+	// Config the client settings; MongoURI and DatabaseName take precedence over its URI and
+	// Database when set
+	Config *Config
+}
+
+// NewDBConfigService create a configuration service connecting with config, e.g. from Load
+func NewDBConfigService(config *Config) DefaultDBConfigService {
+	return DefaultDBConfigService{MongoURI: config.URI, DatabaseName: config.Database, Config: config}
 }
 
 // ConnectDB connect to database
 // returns an error if the operation fails
 func (d DefaultDBConfigService) ConnectDB() (*mongo.Client, error) {
-	clientOptions := options.Client()
-	clientOptions.ApplyURI(d.MongoURI)
+	config := d.config()
 
+	clientOptions, err := config.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := connect(context.Background(), clientOptions, readpref.Primary())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("connected to DB: ", config.Database)
+	return client, nil
+}
+
+// connect create a client and ping the members readPref selects, disconnecting again when the
+// ping fails
+func connect(ctx context.Context, clientOptions *options.ClientOptions, readPref *readpref.ReadPref) (*mongo.Client, error) {
 	client, err := mongo.Connect(clientOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := client.Ping(context.Background(), readpref.Primary()); err != nil {
+	if err := client.Ping(ctx, readPref); err != nil {
+		_ = client.Disconnect(context.WithoutCancel(ctx))
 		return nil, err
 	}
 
-	log.Info("connected to DB: ", d.DatabaseName)
 	return client, nil
 }
 
+// config the Config with MongoURI and DatabaseName applied
+func (d DefaultDBConfigService) config() Config {
+	var config Config
+	if d.Config != nil {
+		config = *d.Config
+	}
+
+	if d.MongoURI != "" {
+		config.URI = d.MongoURI
+	}
+	if d.DatabaseName != "" {
+		config.Database = d.DatabaseName
+	}
+	return config
+}
+
 // GetCollection get a mongo collection by collection name
 func (d DefaultDBConfigService) GetCollection(client *mongo.Client, collectionName string) *mongo.Collection {
-	collection := client.Database(d.DatabaseName).Collection(collectionName)
+	collection := client.Database(d.config().Database).Collection(collectionName)
 	return collection
 }
 
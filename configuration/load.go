@@ -0,0 +1,131 @@
+package configuration
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load read the configuration from the YAML file at path, when path is not empty, then from the
+// environment variables starting with prefix, which take precedence, and validate it
+func Load(path, prefix string) (*Config, error) {
+	config := &Config{}
+	if path != "" {
+		if err := config.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.LoadEnv(prefix); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadFile set the settings a YAML file holds. Unknown keys are an error, so typos do not go unnoticed.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, path, err)
+	}
+	return nil
+}
+
+// LoadEnv set the settings given by environment variables named after the env tags, joined to
+// prefix with an underscore. Empty variables are ignored. Durations are Go durations like 10s,
+// and compressors a comma separated list.
+func (c *Config) LoadEnv(prefix string) error {
+	return loadEnv(reflect.ValueOf(c).Elem(), prefix)
+}
+
+func loadEnv(value reflect.Value, prefix string) error {
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+		tag, ok := field.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+
+		name := tag
+		if prefix != "" {
+			name = prefix + "_" + tag
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := loadEnv(value.Field(i), name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		variable := os.Getenv(name)
+		if variable == "" {
+			continue
+		}
+		if err := setEnv(value.Field(i), variable); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, name, err)
+		}
+	}
+	return nil
+}
+
+// setEnv parse the value of a variable into a field
+func setEnv(field reflect.Value, variable string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(variable)
+	case time.Duration:
+		duration, err := time.ParseDuration(variable)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+	case uint64:
+		number, err := strconv.ParseUint(variable, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(number)
+	case bool:
+		flag, err := strconv.ParseBool(variable)
+		if err != nil {
+			return err
+		}
+		field.SetBool(flag)
+	case *bool:
+		flag, err := strconv.ParseBool(variable)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(&flag))
+	case []string:
+		var items []string
+		for item := range strings.SplitSeq(variable, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Export write every document of a collection to w as a fixture file, ordered by _id.
+// Values are written as relaxed extended JSON, so ObjectIDs and dates load back unchanged, and
+// strings starting with "@" are escaped so they are not read back as symbolic ids.
+func Export(ctx context.Context, collection *mongo.Collection, w io.Writer, format Format) error {
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var buffer bytes.Buffer
+	buffer.WriteByte('[')
+	for count := 0; cursor.Next(ctx); count++ {
+		var document bson.D
+		if err := bson.Unmarshal(cursor.Current, &document); err != nil {
+			return err
+		}
+
+		encoded, err := bson.MarshalExtJSON(escapeRefs(document), false, false)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.Write(encoded)
+	}
+	buffer.WriteByte(']')
+
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	var output []byte
+	switch format {
+	case JSON:
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, buffer.Bytes(), "", "  "); err != nil {
+			return err
+		}
+		output = append(indented.Bytes(), '\n')
+	case YAML:
+		if output, err = jsonToYAML(buffer.Bytes()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported fixture format %q", format)
+	}
+
+	_, err = w.Write(output)
+	return err
+}
+
+// ExportDir write one <collection>.<format> fixture file per collection into dir, for the given
+// collections or every collection of the database when none are given
+func ExportDir(ctx context.Context, database *mongo.Database, dir string, format Format, collections ...string) error {
+	if len(collections) == 0 {
+		var err error
+		if collections, err = database.ListCollectionNames(ctx, bson.M{}); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		var buffer bytes.Buffer
+		if err := Export(ctx, database.Collection(collection), &buffer, format); err != nil {
+			return fmt.Errorf("could not export %s: %w", collection, err)
+		}
+
+		path := filepath.Join(dir, collection+"."+string(format))
+		if err := os.WriteFile(path, buffer.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// escapeRefs double the leading "@" of strings throughout a value
+func escapeRefs(value any) any {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, "@") {
+			return "@" + v
+		}
+	case bson.D:
+		result := make(bson.D, len(v))
+		for i, element := range v {
+			result[i] = bson.E{Key: element.Key, Value: escapeRefs(element.Value)}
+		}
+		return result
+	case bson.A:
+		result := make(bson.A, len(v))
+		for i, element := range v {
+			result[i] = escapeRefs(element)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,279 @@
+// Package fixtures loads repeatable datasets from JSON, extended JSON and YAML files into
+// collections, and exports collections back into fixture files.
+//
+// A fixture file holds an array of documents for the collection its name stands for, so
+// users.yaml seeds the users collection. String values starting with "@" are symbolic ids:
+// "@alice" becomes the same ObjectID wherever it appears, in every file and every run, so
+// fixtures can reference each other. Write "@@" for a literal leading "@".
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/hub1989/mongo-data/v4/repository"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Format a fixture file encoding
+type Format string
+
+const (
+	// JSON plain or extended JSON, relaxed or canonical
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+// FormatOf the format a file name's extension stands for
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".ejson":
+		return JSON, nil
+	case ".yaml", ".yml":
+		return YAML, nil
+	}
+	return "", fmt.Errorf("unsupported fixture file %s: want .json, .ejson, .yaml or .yml", path)
+}
+
+// Ref the ObjectID a symbolic id resolves to. It is derived from the name, so it is the same in
+// every file and every run.
+func Ref(name string) bson.ObjectID {
+	sum := sha256.Sum256([]byte(name))
+
+	var id bson.ObjectID
+	copy(id[:], sum[:])
+	return id
+}
+
+// Set parsed fixture documents by collection
+type Set struct {
+	collections []string
+	documents   map[string][]bson.D
+}
+
+// New create an empty fixture set
+func New() *Set {
+	return &Set{documents: make(map[string][]bson.D)}
+}
+
+// ReadDir read every fixture file in a directory, in name order
+func ReadDir(dir string) (*Set, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	set := New()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, err := FormatOf(entry.Name()); err != nil {
+			continue
+		}
+
+		if err := set.ReadFile(filepath.Join(dir, entry.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// ReadFile add the documents of a fixture file to the collection its name stands for
+func (s *Set) ReadFile(path string) error {
+	format, err := FormatOf(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	collection := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if err := s.Parse(collection, format, data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Parse add the documents encoded in data to a collection. Symbolic ids are resolved and
+// documents without an _id get a new one.
+func (s *Set) Parse(collection string, format Format, data []byte) error {
+	if format == YAML {
+		var err error
+		if data, err = yamlToJSON(data); err != nil {
+			return err
+		}
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return fmt.Errorf("a fixture file must hold an array of documents: %w", err)
+	}
+
+	for i, raw := range raws {
+		var document bson.D
+		if err := bson.UnmarshalExtJSON(raw, false, &document); err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+
+		document = resolveRefs(document).(bson.D)
+		if !slices.ContainsFunc(document, func(e bson.E) bool { return e.Key == "_id" }) {
+			document = append(bson.D{{Key: "_id", Value: bson.NewObjectID()}}, document...)
+		}
+
+		s.Add(collection, document)
+	}
+
+	return nil
+}
+
+// Add documents to a collection
+func (s *Set) Add(collection string, documents ...bson.D) {
+	if _, ok := s.documents[collection]; !ok {
+		s.collections = append(s.collections, collection)
+	}
+	s.documents[collection] = append(s.documents[collection], documents...)
+}
+
+// Collections the collection names in the order they were first added
+func (s *Set) Collections() []string {
+	return slices.Clone(s.collections)
+}
+
+// Documents the fixture documents of a collection
+func (s *Set) Documents(collection string) []bson.D {
+	return s.documents[collection]
+}
+
+// Load insert every collection's documents into the database
+func (s *Set) Load(ctx context.Context, database *mongo.Database) error {
+	for _, collection := range s.collections {
+		documents := s.documents[collection]
+		if len(documents) == 0 {
+			continue
+		}
+
+		if _, err := database.Collection(collection).InsertMany(ctx, documents); err != nil {
+			return fmt.Errorf("could not load %s fixtures: %w", collection, err)
+		}
+
+		log.WithFields(log.Fields{
+			"count": len(documents),
+		}).Info(fmt.Sprintf("loaded %s fixtures", collection))
+	}
+	return nil
+}
+
+// Truncate delete every document of the set's collections, keeping their indexes
+func (s *Set) Truncate(ctx context.Context, database *mongo.Database) error {
+	return Truncate(ctx, database, s.collections...)
+}
+
+// Reset truncate the set's collections and load the fixtures again
+func (s *Set) Reset(ctx context.Context, database *mongo.Database) error {
+	if err := s.Truncate(ctx, database); err != nil {
+		return err
+	}
+	return s.Load(ctx, database)
+}
+
+// Use reset the set's collections for a test and truncate them again when it finishes
+func (s *Set) Use(t testing.TB, database *mongo.Database) {
+	t.Helper()
+
+	if err := s.Reset(context.Background(), database); err != nil {
+		t.Fatalf("could not load fixtures: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := s.Truncate(context.Background(), database); err != nil {
+			t.Errorf("could not truncate fixtures: %v", err)
+		}
+	})
+}
+
+// Truncate delete every document of the collections, keeping their indexes
+func Truncate(ctx context.Context, database *mongo.Database, collections ...string) error {
+	for _, collection := range collections {
+		if _, err := database.Collection(collection).DeleteMany(ctx, bson.M{}); err != nil {
+			return fmt.Errorf("could not truncate %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// Load decode the fixtures of the repository's collection into T and save them through the
+// repository, so they are validated and shaped the way the application writes them
+func Load[T base_entity.Entity](ctx context.Context, set *Set, repo repository.MongoRepository[T]) ([]*T, error) {
+	documents := set.Documents(repo.Collection.Name())
+	if len(documents) == 0 {
+		return nil, nil
+	}
+
+	entities := make([]*T, 0, len(documents))
+	batch := make([]interface{}, 0, len(documents))
+	for i, document := range documents {
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+
+		var entity T
+		if err := bson.Unmarshal(raw, &entity); err != nil {
+			return nil, fmt.Errorf("%s fixture %d: %w", repo.Collection.Name(), i, err)
+		}
+
+		entities = append(entities, &entity)
+		batch = append(batch, entity)
+	}
+
+	if _, err := repo.SaveMany(ctx, batch); err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// resolveRefs replace symbolic id strings with their ObjectIDs throughout a value
+func resolveRefs(value any) any {
+	switch v := value.(type) {
+	case string:
+		switch {
+		case strings.HasPrefix(v, "@@"):
+			return v[1:]
+		case len(v) > 1 && v[0] == '@':
+			return Ref(v[1:])
+		}
+	case bson.D:
+		result := make(bson.D, len(v))
+		for i, element := range v {
+			result[i] = bson.E{Key: element.Key, Value: resolveRefs(element.Value)}
+		}
+		return result
+	case bson.A:
+		result := make(bson.A, len(v))
+		for i, element := range v {
+			result[i] = resolveRefs(element)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,136 @@
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	"github.com/hub1989/mongo-data/v4/repository"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type User struct {
+	Id      bson.ObjectID `bson:"_id"`
+	Name    string        `bson:"name"`
+	Age     int           `bson:"age"`
+	Manager bson.ObjectID `bson:"manager,omitempty"`
+}
+
+func (u User) GetId() bson.ObjectID {
+	return u.Id
+}
+
+func (u User) SetId(id bson.ObjectID) {
+	u.Id = id
+}
+
+func valueOf(document bson.D, key string) any {
+	for _, element := range document {
+		if element.Key == key {
+			return element.Value
+		}
+	}
+	return nil
+}
+
+func TestRef(t *testing.T) {
+	assert.Equal(t, Ref("alice"), Ref("alice"))
+	assert.NotEqual(t, Ref("alice"), Ref("bob"))
+}
+
+func TestReadDir(t *testing.T) {
+	set, err := ReadDir("testdata")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders", "users"}, set.Collections())
+
+	users := set.Documents("users")
+	assert.Len(t, users, 2)
+	assert.Equal(t, Ref("alice"), valueOf(users[0], "_id"))
+	assert.Equal(t, int32(30), valueOf(users[0], "age"))
+	assert.Equal(t, bson.NewDateTimeFromTime(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)), valueOf(users[0], "joined"))
+	assert.Equal(t, "@alice", valueOf(users[0], "handle"))
+	assert.Equal(t, 1.0, valueOf(users[1], "score"))
+	assert.Equal(t, Ref("alice"), valueOf(users[1], "manager"))
+
+	orders := set.Documents("orders")
+	assert.Len(t, orders, 2)
+	assert.Equal(t, Ref("order-1"), valueOf(orders[0], "_id"))
+	assert.Equal(t, int64(1200), valueOf(orders[0], "total"))
+	assert.Equal(t, Ref("alice"), valueOf(orders[0], "user"))
+	assert.IsType(t, bson.ObjectID{}, valueOf(orders[1], "_id"), "documents without an _id get one")
+	assert.Equal(t, bson.A{Ref("alice"), "sku"}, valueOf(orders[1], "items"))
+}
+
+func TestParse_Errors(t *testing.T) {
+	assert.NotNil(t, New().Parse("x", JSON, []byte(`{"a": 1}`)))
+	assert.NotNil(t, New().Parse("x", YAML, []byte("a: [")))
+	assert.Nil(t, New().Parse("x", YAML, nil))
+
+	_, err := FormatOf("users.csv")
+	assert.NotNil(t, err)
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	source := []byte(`[{"_id":{"$oid":"65a4f1c2e4b0a1b2c3d4e5f6"},"name":"123","on":true,"at":{"$date":"2024-01-15T10:00:00Z"},"n":{"$numberLong":"7"}}]`)
+
+	converted, err := jsonToYAML(source)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(converted), "{")
+
+	set := New()
+	assert.Nil(t, set.Parse("x", YAML, converted))
+
+	document := set.Documents("x")[0]
+	id, _ := bson.ObjectIDFromHex("65a4f1c2e4b0a1b2c3d4e5f6")
+	assert.Equal(t, id, valueOf(document, "_id"))
+	assert.Equal(t, "123", valueOf(document, "name"))
+	assert.Equal(t, true, valueOf(document, "on"))
+	assert.Equal(t, bson.NewDateTimeFromTime(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)), valueOf(document, "at"))
+}
+
+func TestLoadExportAndReset(t *testing.T) {
+	server := mongotest.Start(t)
+	db := server.Database(t)
+	ctx := context.Background()
+
+	set, err := ReadDir("testdata")
+	assert.Nil(t, err)
+
+	repo := repository.MongoRepository[User]{Collection: db.Collection("users")}
+	users, err := Load(ctx, set, repo)
+	assert.Nil(t, err)
+	assert.Len(t, users, 2)
+
+	bob, err := repo.FindById(ctx, Ref("bob"))
+	assert.Nil(t, err)
+	assert.Equal(t, Ref("alice"), bob.Manager)
+
+	set.Use(t, db)
+	count, _ := db.Collection("orders").CountDocuments(ctx, bson.M{})
+	assert.Equal(t, int64(2), count)
+
+	for _, format := range []Format{JSON, YAML} {
+		var exported bytes.Buffer
+		assert.Nil(t, Export(ctx, db.Collection("orders"), &exported, format))
+
+		reloaded := New()
+		assert.Nil(t, reloaded.Parse("orders", format, exported.Bytes()))
+		assert.Len(t, reloaded.Documents("orders"), 2)
+	}
+
+	dir := t.TempDir()
+	assert.Nil(t, ExportDir(ctx, db, dir, YAML, "users"))
+	exported, err := ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, exported.Documents("users"), 2)
+}
+
+func TestEscapeRefs(t *testing.T) {
+	escaped := escapeRefs(bson.D{{Key: "handle", Value: "@alice"}, {Key: "tags", Value: bson.A{"@x", "y"}}})
+
+	assert.Equal(t, bson.D{{Key: "handle", Value: "@@alice"}, {Key: "tags", Value: bson.A{"@@x", "y"}}}, escaped)
+	assert.Equal(t, "@alice", resolveRefs("@@alice"))
+}
@@ -0,0 +1,159 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlToJSON convert a YAML document into relaxed extended JSON, keeping key order. Extended JSON
+// wrappers such as {$oid: ...} pass through; YAML timestamps and binary become $date and $binary.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	if document.Kind == 0 {
+		// an empty file
+		return nil, nil
+	}
+
+	var buffer bytes.Buffer
+	if err := writeJSON(&buffer, &document); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeJSON(buffer *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			buffer.WriteString("null")
+			return nil
+		}
+		return writeJSON(buffer, node.Content[0])
+	case yaml.AliasNode:
+		return writeJSON(buffer, node.Alias)
+	case yaml.SequenceNode:
+		buffer.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := writeJSON(buffer, item); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte(']')
+		return nil
+	case yaml.MappingNode:
+		buffer.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+
+			key, _ := json.Marshal(node.Content[i].Value)
+			buffer.Write(key)
+			buffer.WriteByte(':')
+
+			if err := writeJSON(buffer, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte('}')
+		return nil
+	case yaml.ScalarNode:
+		return writeScalar(buffer, node)
+	}
+
+	return fmt.Errorf("line %d: unsupported YAML node", node.Line)
+}
+
+func writeScalar(buffer *bytes.Buffer, node *yaml.Node) error {
+	switch node.ShortTag() {
+	case "!!null":
+		buffer.WriteString("null")
+	case "!!bool":
+		var value bool
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		buffer.WriteString(strconv.FormatBool(value))
+	case "!!int":
+		var value int64
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		buffer.WriteString(strconv.FormatInt(value, 10))
+	case "!!float":
+		var value float64
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+
+		switch {
+		case math.IsNaN(value):
+			buffer.WriteString(`{"$numberDouble":"NaN"}`)
+		case math.IsInf(value, 1):
+			buffer.WriteString(`{"$numberDouble":"Infinity"}`)
+		case math.IsInf(value, -1):
+			buffer.WriteString(`{"$numberDouble":"-Infinity"}`)
+		default:
+			formatted := strconv.FormatFloat(value, 'g', -1, 64)
+			if value == math.Trunc(value) {
+				// keep the value a double rather than reading it back as an integer
+				formatted = fmt.Sprintf(`{"$numberDouble":"%s"}`, formatted)
+			}
+			buffer.WriteString(formatted)
+		}
+	case "!!timestamp":
+		var value time.Time
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		fmt.Fprintf(buffer, `{"$date":%q}`, value.UTC().Format(time.RFC3339Nano))
+	case "!!binary":
+		fmt.Fprintf(buffer, `{"$binary":{"base64":%q,"subType":"00"}}`, strings.Join(strings.Fields(node.Value), ""))
+	default:
+		value, _ := json.Marshal(node.Value)
+		buffer.Write(value)
+	}
+	return nil
+}
+
+// jsonToYAML re-encode a JSON value as block style YAML
+func jsonToYAML(data []byte) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	blockStyle(&document)
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// blockStyle drop the flow style and needless quotes JSON input leaves on every node
+func blockStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle | yaml.DoubleQuotedStyle
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
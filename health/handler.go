@@ -0,0 +1,38 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LivenessHandler serve the Liveness report as JSON, with status 503 when a connection is down
+func (c *Checker) LivenessHandler() http.Handler {
+	return c.handler(c.Liveness)
+}
+
+// ReadinessHandler serve the Readiness report as JSON, with status 503 when a connection is down.
+// Degraded connections answer 200, as they still serve requests.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return c.handler(c.Readiness)
+}
+
+func (c *Checker) handler(check func(ctx context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := check(r.Context())
+
+		status := http.StatusOK
+		if report.Status == Down {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.WithError(err).Warn("could not write health report")
+		}
+	})
+}
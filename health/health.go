@@ -0,0 +1,200 @@
+// Package health reports whether the connections of a configuration.ConnectionManager can serve
+// requests: ping latency, the replica set topology with replication lag, and connection pool
+// stats. Checker is the Go API and its handlers serve the reports as JSON for liveness and
+// readiness probes:
+//
+//	manager := configuration.NewConnectionManager()
+//	checker := health.New(manager) // before the connections are used
+//	http.Handle("/livez", checker.LivenessHandler())
+//	http.Handle("/readyz", checker.ReadinessHandler())
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/configuration"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+// Status the health of a connection or of all of them
+type Status string
+
+const (
+	// Up everything works
+	Up Status = "up"
+	// Degraded requests are served, but secondaries are unhealthy or lag behind
+	Degraded Status = "degraded"
+	// Down the deployment is unreachable or a replica set has no primary
+	Down Status = "down"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Duration a time.Duration written as text, e.g. 1.5ms, in JSON
+type Duration time.Duration
+
+// MarshalJSON write the duration as text
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Report the health of every connection
+type Report struct {
+	// Status the worst status of the connections
+	Status      Status                      `json:"status"`
+	Connections map[string]ConnectionReport `json:"connections"`
+}
+
+// ConnectionReport the health of one connection. Topology and Pool are only set by readiness checks.
+type ConnectionReport struct {
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Latency of a ping to the nearest member
+	Latency  Duration   `json:"latency"`
+	Topology *Topology  `json:"topology,omitempty"`
+	Pool     *PoolStats `json:"pool,omitempty"`
+}
+
+// Checker checks the connections of a ConnectionManager
+type Checker struct {
+	Connections *configuration.ConnectionManager
+	// Pools provides the pool stats of readiness reports, when set
+	Pools *PoolMonitor
+	// Timeout bounds the checks of each connection. Defaults to 5 seconds.
+	Timeout time.Duration
+	// MaxLag the replication lag above which a secondary degrades readiness. 0 ignores lag.
+	MaxLag time.Duration
+}
+
+// New create a checker of the manager's connections and install a PoolMonitor on them.
+// Call it before the connections are used, so their pools are monitored from the start. Unless
+// the manager's ConnectReadPref is set, connections count as connected once the nearest member
+// answers, so that liveness can connect them while a replica set elects a primary.
+func New(connections *configuration.ConnectionManager) *Checker {
+	pools := NewPoolMonitor()
+
+	if connections.ConnectReadPref == nil {
+		connections.ConnectReadPref = readpref.Nearest()
+	}
+
+	configure := connections.Configure
+	connections.Configure = func(name string, clientOptions *options.ClientOptions) {
+		if configure != nil {
+			configure(name, clientOptions)
+		}
+		pools.Configure(name, clientOptions)
+	}
+
+	return &Checker{Connections: connections, Pools: pools}
+}
+
+// Liveness ping the nearest member of every connection, so that a replica set electing a primary
+// stays live. Connections that are not connected yet connect first.
+func (c *Checker) Liveness(ctx context.Context) Report {
+	return c.check(ctx, false)
+}
+
+// Readiness ping every connection and describe its topology and pool. A replica set without a
+// primary is down; unhealthy secondaries or secondaries lagging more than MaxLag degrade it.
+func (c *Checker) Readiness(ctx context.Context) Report {
+	return c.check(ctx, true)
+}
+
+func (c *Checker) check(ctx context.Context, ready bool) Report {
+	names := c.Connections.Names()
+	reports := make([]ConnectionReport, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Go(func() {
+			reports[i] = c.checkConnection(ctx, name, ready)
+		})
+	}
+	wg.Wait()
+
+	report := Report{Status: Up, Connections: make(map[string]ConnectionReport, len(names))}
+	for i, name := range names {
+		report.Connections[name] = reports[i]
+		report.Status = worse(report.Status, reports[i].Status)
+	}
+	return report
+}
+
+func (c *Checker) checkConnection(ctx context.Context, name string, ready bool) ConnectionReport {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	down := func(err error) ConnectionReport {
+		return ConnectionReport{Status: Down, Error: err.Error()}
+	}
+
+	client, err := c.Connections.Client(ctx, name)
+	if err != nil {
+		return down(err)
+	}
+
+	start := time.Now()
+	if err := client.Ping(ctx, readpref.Nearest()); err != nil {
+		return down(err)
+	}
+	report := ConnectionReport{Status: Up, Latency: Duration(time.Since(start))}
+
+	if !ready {
+		return report
+	}
+
+	if c.Pools != nil {
+		if stats, ok := c.Pools.Stats(name); ok {
+			report.Pool = &stats
+		}
+	}
+
+	topology, err := describe(ctx, client)
+	if err != nil {
+		report.Status, report.Error = Down, err.Error()
+		return report
+	}
+	report.Topology = topology
+	report.Status, report.Error = c.assess(topology)
+	return report
+}
+
+// assess the readiness of a topology: a replica set needs a primary, and is degraded by unhealthy
+// or lagging secondaries
+func (c *Checker) assess(topology *Topology) (Status, string) {
+	if topology.Kind != ReplicaSet {
+		return Up, ""
+	}
+	if topology.Primary == "" {
+		return Down, fmt.Sprintf("replica set %s has no primary", topology.SetName)
+	}
+
+	status := Up
+	for _, member := range topology.Secondaries {
+		if member.State != "" && !member.Healthy {
+			status = Degraded
+		}
+		if c.MaxLag > 0 && time.Duration(member.Lag) > c.MaxLag {
+			status = Degraded
+		}
+	}
+	return status, ""
+}
+
+// worse the less healthy of two statuses
+func worse(a, b Status) Status {
+	rank := map[Status]int{Up: 0, Degraded: 1, Down: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,150 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/configuration"
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+func TestPoolMonitor(t *testing.T) {
+	pools := NewPoolMonitor()
+
+	var chained []string
+	clientOptions := options.Client().SetPoolMonitor(&event.PoolMonitor{Event: func(e *event.PoolEvent) {
+		chained = append(chained, e.Type)
+	}})
+	pools.Configure("orders", clientOptions)
+
+	_, ok := pools.Stats("orders")
+	assert.False(t, ok)
+
+	for _, eventType := range []string{
+		event.ConnectionCreated, event.ConnectionCreated, event.ConnectionCheckedOut, event.ConnectionCheckedOut,
+		event.ConnectionCheckedIn, event.ConnectionClosed, event.ConnectionCheckOutFailed, event.ConnectionPoolCleared,
+	} {
+		clientOptions.PoolMonitor.Event(&event.PoolEvent{Type: eventType})
+	}
+
+	stats, ok := pools.Stats("orders")
+	assert.True(t, ok)
+	assert.Equal(t, PoolStats{Open: 1, InUse: 1, Created: 2, Closed: 1, CheckOutFailures: 1, Cleared: 1}, stats)
+	assert.Len(t, chained, 8)
+}
+
+func TestDuration_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Member{Host: "db-2:27017", Lag: Duration(1500 * time.Millisecond)})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"host":"db-2:27017","healthy":false,"lag":"1.5s"}`, string(data))
+}
+
+func TestWorse(t *testing.T) {
+	assert.Equal(t, Degraded, worse(Up, Degraded))
+	assert.Equal(t, Down, worse(Down, Degraded))
+	assert.Equal(t, Up, worse(Up, Up))
+}
+
+func TestChecker_Assess(t *testing.T) {
+	checker := &Checker{MaxLag: time.Second}
+
+	status, message := checker.assess(&Topology{Kind: Standalone})
+	assert.Equal(t, Up, status)
+	assert.Empty(t, message)
+
+	// during an election the nearest member answers, but there is no primary to write to
+	status, message = checker.assess(&Topology{Kind: ReplicaSet, SetName: "rs0", Secondaries: []Member{{Host: "db-2:27017", State: "SECONDARY", Healthy: true}}})
+	assert.Equal(t, Down, status)
+	assert.Equal(t, "replica set rs0 has no primary", message)
+
+	status, _ = checker.assess(&Topology{Kind: ReplicaSet, Primary: "db-1:27017", Secondaries: []Member{{Host: "db-2:27017", State: "SECONDARY", Healthy: true, Lag: Duration(2 * time.Second)}}})
+	assert.Equal(t, Degraded, status)
+
+	status, _ = checker.assess(&Topology{Kind: ReplicaSet, Primary: "db-1:27017", Secondaries: []Member{{Host: "db-2:27017"}}})
+	assert.Equal(t, Up, status)
+}
+
+func TestNew_ConnectsToNearest(t *testing.T) {
+	manager := configuration.NewConnectionManager()
+	New(manager)
+	assert.Equal(t, readpref.NearestMode, manager.ConnectReadPref.Mode())
+
+	// an explicit choice is kept
+	manager = configuration.NewConnectionManager()
+	manager.ConnectReadPref = readpref.PrimaryPreferred()
+	New(manager)
+	assert.Equal(t, readpref.PrimaryPreferredMode, manager.ConnectReadPref.Mode())
+}
+
+func TestChecker_Unreachable(t *testing.T) {
+	manager := configuration.NewConnectionManager()
+	manager.Retry = configuration.RetryOptions{Attempts: 1}
+	assert.Nil(t, manager.Register("orders", &configuration.Config{URI: "mongodb://localhost:1", ServerSelectionTimeout: 20 * time.Millisecond}))
+
+	checker := New(manager)
+	checker.Timeout = time.Second
+
+	recorder := httptest.NewRecorder()
+	checker.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var report struct {
+		Status      Status `json:"status"`
+		Connections map[string]struct {
+			Status Status `json:"status"`
+			Error  string `json:"error"`
+		} `json:"connections"`
+	}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(t, Down, report.Status)
+	assert.Equal(t, Down, report.Connections["orders"].Status)
+	assert.Contains(t, report.Connections["orders"].Error, "could not connect orders")
+
+	empty := New(configuration.NewConnectionManager())
+	assert.Equal(t, Report{Status: Up, Connections: map[string]ConnectionReport{}}, empty.Liveness(context.Background()))
+}
+
+func TestChecker(t *testing.T) {
+	server := mongotest.Start(t, &mongotest.Options{ReplicaSet: true})
+	ctx := context.Background()
+
+	manager := configuration.NewConnectionManager()
+	assert.Nil(t, manager.Register(configuration.DefaultConnection, &configuration.Config{URI: server.URI, Database: "app"}))
+	defer manager.Close(ctx)
+
+	checker := New(manager)
+
+	database, err := manager.Database(ctx, configuration.DefaultConnection)
+	assert.Nil(t, err)
+	_, err = database.Collection("things").InsertOne(ctx, bson.M{"name": "thing"})
+	assert.Nil(t, err)
+
+	live := checker.Liveness(ctx)
+	assert.Equal(t, Up, live.Status)
+	assert.Nil(t, live.Connections[configuration.DefaultConnection].Topology)
+
+	ready := checker.Readiness(ctx)
+	assert.Equal(t, Up, ready.Status)
+
+	report := ready.Connections[configuration.DefaultConnection]
+	assert.Positive(t, time.Duration(report.Latency))
+	assert.Equal(t, ReplicaSet, report.Topology.Kind)
+	assert.NotEmpty(t, report.Topology.Primary)
+	assert.NotNil(t, report.Pool)
+	assert.Positive(t, report.Pool.Created)
+
+	recorder := httptest.NewRecorder()
+	checker.LivenessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"status":"up"`)
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// PoolStats the connection pool counters of one connection, over all its servers
+type PoolStats struct {
+	// Open connections, idle or in use
+	Open int64 `json:"open"`
+	// InUse connections checked out by operations
+	InUse int64 `json:"inUse"`
+	// Created and Closed connections since the client connected
+	Created int64 `json:"created"`
+	Closed  int64 `json:"closed"`
+	// CheckOutFailures operations that could not get a connection, e.g. because the pool was
+	// exhausted until their timeout
+	CheckOutFailures int64 `json:"checkOutFailures"`
+	// Cleared how often a pool was cleared after a network error or a server becoming unusable
+	Cleared int64 `json:"cleared"`
+}
+
+// PoolMonitor counts the connection pool events of the clients it is installed on
+type PoolMonitor struct {
+	mu    sync.Mutex
+	stats map[string]*PoolStats
+}
+
+// NewPoolMonitor create a monitor without stats
+func NewPoolMonitor() *PoolMonitor {
+	return &PoolMonitor{stats: make(map[string]*PoolStats)}
+}
+
+// Configure install the monitor on the options of the named connection, chaining a pool monitor
+// that is set already. Its signature fits configuration.ConnectionManager.Configure.
+func (p *PoolMonitor) Configure(name string, clientOptions *options.ClientOptions) {
+	clientOptions.SetPoolMonitor(p.Monitor(name, clientOptions.PoolMonitor))
+}
+
+// Monitor a driver pool monitor recording into the stats of name, then calling next when it is not nil
+func (p *PoolMonitor) Monitor(name string, next *event.PoolMonitor) *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			p.record(name, e)
+			if next != nil && next.Event != nil {
+				next.Event(e)
+			}
+		},
+	}
+}
+
+// Stats the counters of the named connection, and false when it has no events yet
+func (p *PoolMonitor) Stats(name string) (PoolStats, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats, ok := p.stats[name]
+	if !ok {
+		return PoolStats{}, false
+	}
+	return *stats, true
+}
+
+func (p *PoolMonitor) record(name string, e *event.PoolEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats, ok := p.stats[name]
+	if !ok {
+		stats = &PoolStats{}
+		p.stats[name] = stats
+	}
+
+	switch e.Type {
+	case event.ConnectionCreated:
+		stats.Created++
+		stats.Open++
+	case event.ConnectionClosed:
+		stats.Closed++
+		stats.Open--
+	case event.ConnectionCheckedOut:
+		stats.InUse++
+	case event.ConnectionCheckedIn:
+		stats.InUse--
+	case event.ConnectionCheckOutFailed:
+		stats.CheckOutFailures++
+	case event.ConnectionPoolCleared:
+		stats.Cleared++
+	}
+}
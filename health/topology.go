@@ -0,0 +1,114 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+// Topology kinds
+const (
+	Standalone = "standalone"
+	ReplicaSet = "replicaSet"
+	Sharded    = "sharded"
+)
+
+// Topology the deployment a connection talks to
+type Topology struct {
+	// Kind Standalone, ReplicaSet or Sharded
+	Kind    string `json:"kind"`
+	SetName string `json:"setName,omitempty"`
+	// Primary the address of the replica set primary, empty when there is none
+	Primary     string   `json:"primary,omitempty"`
+	Secondaries []Member `json:"secondaries,omitempty"`
+	// Warning why the members are known from hello only, e.g. a user not allowed to run
+	// replSetGetStatus. Their state and lag are unknown then.
+	Warning string `json:"warning,omitempty"`
+}
+
+// Member a secondary of a replica set
+type Member struct {
+	Host    string `json:"host"`
+	State   string `json:"state,omitempty"`
+	Healthy bool   `json:"healthy"`
+	// Lag how far the member's last applied operation is behind the primary's
+	Lag Duration `json:"lag"`
+}
+
+// hello the fields of the hello command a topology is described from
+type hello struct {
+	SetName string   `bson:"setName"`
+	Primary string   `bson:"primary"`
+	Hosts   []string `bson:"hosts"`
+	Msg     string   `bson:"msg"`
+}
+
+// replSetStatus the fields of replSetGetStatus a topology is described from
+type replSetStatus struct {
+	Members []struct {
+		Name       string    `bson:"name"`
+		StateStr   string    `bson:"stateStr"`
+		Health     float64   `bson:"health"`
+		OptimeDate time.Time `bson:"optimeDate"`
+	} `bson:"members"`
+}
+
+// describe the topology of the deployment client is connected to. The commands run on the nearest
+// member, so a replica set without a primary is described rather than failing server selection.
+func describe(ctx context.Context, client *mongo.Client) (*Topology, error) {
+	admin := client.Database("admin")
+	nearest := options.RunCmd().SetReadPreference(readpref.Nearest())
+
+	var h hello
+	if err := admin.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}, nearest).Decode(&h); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case h.Msg == "isdbgrid":
+		return &Topology{Kind: Sharded}, nil
+	case h.SetName == "":
+		return &Topology{Kind: Standalone}, nil
+	}
+
+	topology := &Topology{Kind: ReplicaSet, SetName: h.SetName, Primary: h.Primary}
+
+	var status replSetStatus
+	if err := admin.RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}, nearest).Decode(&status); err != nil {
+		log.WithError(err).Debug("could not read the replica set status of ", h.SetName)
+		topology.Warning = "replSetGetStatus failed: " + err.Error()
+		for _, host := range h.Hosts {
+			if host != h.Primary {
+				topology.Secondaries = append(topology.Secondaries, Member{Host: host})
+			}
+		}
+		return topology, nil
+	}
+
+	topology.Primary = ""
+	var primaryOptime time.Time
+	for _, member := range status.Members {
+		if member.StateStr == "PRIMARY" {
+			topology.Primary, primaryOptime = member.Name, member.OptimeDate
+		}
+	}
+
+	for _, member := range status.Members {
+		// arbiters hold no data to lag behind
+		if member.StateStr == "PRIMARY" || member.StateStr == "ARBITER" {
+			continue
+		}
+
+		secondary := Member{Host: member.Name, State: member.StateStr, Healthy: member.Health == 1}
+		if !primaryOptime.IsZero() && !member.OptimeDate.IsZero() {
+			secondary.Lag = Duration(max(primaryOptime.Sub(member.OptimeDate), 0))
+		}
+		topology.Secondaries = append(topology.Secondaries, secondary)
+	}
+	return topology, nil
+}
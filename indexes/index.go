@@ -0,0 +1,118 @@
+// Package indexes declares collection indexes on entity types and synchronizes them with the
+// indexes that exist on the server.
+//
+// Indexes are declared with `index` struct tags, through the Indexed interface, or both:
+//
+//	type User struct {
+//		Id        bson.ObjectID `bson:"_id"`
+//		Email     string        `bson:"email" index:"unique,collation=en:2"`
+//		TenantId  string        `bson:"tenantId" index:"name=tenant_created,order=1"`
+//		CreatedAt time.Time     `bson:"createdAt" index:"name=tenant_created,order=2,desc;ttl=720h"`
+//		Bio       string        `bson:"bio" index:"text"`
+//		Location  bson.M        `bson:"location" index:"2dsphere,sparse"`
+//	}
+//
+// A tag holds one or more index declarations separated by ";". Each is a comma separated list of:
+//
+//   - asc (the default), desc, text, 2dsphere or hashed: the key type of the field
+//   - unique, sparse
+//   - name=<name>: the index name. Fields sharing a name form one compound index.
+//   - order=<n>: the position of the field in its compound index, defaulting to field order
+//   - ttl=<duration>: expire documents this long after the date in the field
+//   - collation=<locale>[:<strength>]
+//
+// Partial filter expressions are declared through the Indexed interface.
+package indexes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Key types besides the ascending 1 and descending -1
+const (
+	Text     = "text"
+	Sphere2D = "2dsphere"
+	Hashed   = "hashed"
+)
+
+// Indexed implemented by entities declaring indexes in code, on top of their struct tags
+type Indexed interface {
+	Indexes() []Index
+}
+
+// Index a declared index
+type Index struct {
+	// Name defaults to the server's naming, e.g. email_1_createdAt_-1
+	Name string
+	// Keys field paths mapped to 1, -1, Text, Sphere2D or Hashed
+	Keys   bson.D
+	Unique bool
+	Sparse bool
+	// ExpireAfter makes a TTL index when set
+	ExpireAfter *time.Duration
+	// PartialFilter only index documents matching this filter
+	PartialFilter bson.D
+	Collation     *options.Collation
+}
+
+// IndexName the declared name, or the name the server gives the keys
+func (i Index) IndexName() string {
+	if i.Name != "" {
+		return i.Name
+	}
+
+	parts := make([]string, 0, len(i.Keys)*2)
+	for _, key := range i.Keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// Model the index as the driver creates it
+func (i Index) Model() mongo.IndexModel {
+	opts := options.Index().SetName(i.IndexName())
+	if i.Unique {
+		opts.SetUnique(true)
+	}
+	if i.Sparse {
+		opts.SetSparse(true)
+	}
+	if i.ExpireAfter != nil {
+		opts.SetExpireAfterSeconds(int32(i.ExpireAfter.Seconds()))
+	}
+	if i.PartialFilter != nil {
+		opts.SetPartialFilterExpression(i.PartialFilter)
+	}
+	if i.Collation != nil {
+		opts.SetCollation(i.Collation)
+	}
+
+	return mongo.IndexModel{Keys: i.Keys, Options: opts}
+}
+
+func (i Index) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %v", i.IndexName(), i.Keys)
+	if i.Unique {
+		b.WriteString(" unique")
+	}
+	if i.Sparse {
+		b.WriteString(" sparse")
+	}
+	if i.ExpireAfter != nil {
+		fmt.Fprintf(&b, " ttl=%s", i.ExpireAfter)
+	}
+	if i.PartialFilter != nil {
+		fmt.Fprintf(&b, " partial=%v", i.PartialFilter)
+	}
+	if i.Collation != nil {
+		fmt.Fprintf(&b, " collation=%s:%d", i.Collation.Locale, i.Collation.Strength)
+	}
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package indexes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type Address struct {
+	City string `bson:"city" index:""`
+}
+
+type User struct {
+	Id        bson.ObjectID `bson:"_id"`
+	Email     string        `bson:"email" index:"unique,collation=en:2"`
+	TenantId  string        `bson:"tenantId" index:"name=tenant_created,order=1"`
+	CreatedAt time.Time     `bson:"createdAt" index:"name=tenant_created,order=2,desc;ttl=720h"`
+	Bio       string        `bson:"bio" index:"text"`
+	Addresses []Address     `bson:"addresses"`
+	Deleted   bool          `bson:"deleted"`
+}
+
+func (User) Indexes() []Index {
+	return []Index{{
+		Name:          "active_email",
+		Keys:          bson.D{{Key: "email", Value: 1}, {Key: "deleted", Value: 1}},
+		PartialFilter: bson.D{{Key: "deleted", Value: false}},
+	}}
+}
+
+func TestFor(t *testing.T) {
+	declared, err := For[User]()
+	assert.Nil(t, err)
+
+	month := 720 * time.Hour
+	assert.Equal(t, []Index{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Unique: true, Collation: &options.Collation{Locale: "en", Strength: 2}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, ExpireAfter: &month},
+		{Keys: bson.D{{Key: "bio", Value: Text}}},
+		{Keys: bson.D{{Key: "addresses.city", Value: 1}}},
+		{Name: "tenant_created", Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		User{}.Indexes()[0],
+	}, declared)
+
+	assert.Equal(t, "email_1", declared[0].IndexName())
+	assert.Equal(t, "bio_text", declared[2].IndexName())
+}
+
+func TestFor_InvalidTags(t *testing.T) {
+	type unknown struct {
+		Name string `bson:"name" index:"uniq"`
+	}
+	_, err := For[unknown]()
+	assert.ErrorContains(t, err, `unknown option "uniq"`)
+
+	type compoundTTL struct {
+		A time.Time `bson:"a" index:"name=ab,ttl=1h"`
+		B string    `bson:"b" index:"name=ab"`
+	}
+	_, err = For[compoundTTL]()
+	assert.ErrorContains(t, err, "single field")
+}
+
+func TestSameKeysAndCompare(t *testing.T) {
+	text := Index{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "bio", Value: Text}, {Key: "title", Value: Text}}}
+	assert.True(t, sameKeys(text, existing{
+		Key:     bson.D{{Key: "tenant", Value: 1.0}, {Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}},
+		Weights: bson.D{{Key: "title", Value: int32(1)}, {Key: "bio", Value: int32(1)}},
+	}))
+	assert.False(t, sameKeys(text, existing{
+		Key:     bson.D{{Key: "tenant", Value: 1.0}, {Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}},
+		Weights: bson.D{{Key: "bio", Value: int32(1)}},
+	}))
+
+	hour := time.Hour
+	declared := Index{
+		Keys:          bson.D{{Key: "at", Value: -1}},
+		ExpireAfter:   &hour,
+		PartialFilter: bson.D{{Key: "deleted", Value: false}},
+	}
+	seconds := 3600.0
+	assert.Empty(t, compare(declared, existing{
+		Name:                    "at_-1",
+		Key:                     bson.D{{Key: "at", Value: int32(-1)}},
+		ExpireAfterSeconds:      &seconds,
+		PartialFilterExpression: bson.D{{Key: "deleted", Value: false}},
+	}))
+	assert.Equal(t, []string{"unique", "ttl", "partial filter"}, compare(declared, existing{
+		Name:   "at_-1",
+		Key:    bson.D{{Key: "at", Value: int32(-1)}},
+		Unique: true,
+	}))
+}
+
+func TestSync(t *testing.T) {
+	server := mongotest.Start(t)
+	collection := server.Database(t).Collection("users")
+	ctx := context.Background()
+
+	_, err := collection.Indexes().CreateOne(ctx, Index{Keys: bson.D{{Key: "legacy", Value: 1}}}.Model())
+	assert.Nil(t, err)
+	_, err = collection.Indexes().CreateOne(ctx, Index{Keys: bson.D{{Key: "bio", Value: Text}}}.Model())
+	assert.Nil(t, err)
+	_, err = collection.Indexes().CreateOne(ctx, Index{Keys: bson.D{{Key: "email", Value: 1}}}.Model())
+	assert.Nil(t, err)
+
+	declared, err := For[User]()
+	assert.Nil(t, err)
+
+	report, err := Sync(ctx, collection, declared, &SyncOptions{DryRun: true})
+	assert.Nil(t, err)
+	assert.Len(t, report.Created, 4)
+	assert.Equal(t, []string{"bio_text"}, report.Unchanged)
+	assert.Len(t, report.Drifted, 1)
+	assert.Equal(t, "email_1", report.Drifted[0].Existing)
+	assert.Equal(t, []string{"unique", "collation"}, report.Drifted[0].Differences)
+	assert.Equal(t, []string{"legacy_1"}, report.Undeclared)
+
+	report, err = Sync(ctx, collection, declared, &SyncOptions{DropUndeclared: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"legacy_1"}, report.Dropped)
+
+	report, err = Sync(ctx, collection, declared)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Created)
+	assert.Len(t, report.Unchanged, 5)
+	assert.Len(t, report.Drifted, 1)
+}
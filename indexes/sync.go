@@ -0,0 +1,295 @@
+package indexes
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// SyncOptions configures Sync
+type SyncOptions struct {
+	// DropUndeclared drop existing indexes nothing declares. The _id index is always kept.
+	DropUndeclared bool
+	// DryRun report what would change without changing anything
+	DryRun bool
+}
+
+// Report the outcome of a Sync. In a dry run Created and Dropped hold what would be.
+type Report struct {
+	Created   []Index
+	Dropped   []string
+	Unchanged []string
+	// Drifted declared indexes that exist with other options, or whose name is taken by an index
+	// on other keys. Sync leaves them alone: changing them means dropping and rebuilding them.
+	Drifted []Drift
+	// Undeclared existing indexes nothing declares, kept unless DropUndeclared is set
+	Undeclared []string
+}
+
+// Drift a declared index that differs from the existing one
+type Drift struct {
+	Declared    Index
+	Existing    string
+	Differences []string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s differs from existing %s: %s", d.Declared.IndexName(), d.Existing, strings.Join(d.Differences, ", "))
+}
+
+// InSync whether the collection already had exactly the declared indexes
+func (r Report) InSync() bool {
+	return len(r.Created) == 0 && len(r.Dropped) == 0 && len(r.Drifted) == 0 && len(r.Undeclared) == 0
+}
+
+// existing an index as listed by the server
+type existing struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.D   `bson:"key"`
+	Unique                  bool     `bson:"unique"`
+	Sparse                  bool     `bson:"sparse"`
+	ExpireAfterSeconds      *float64 `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.D   `bson:"partialFilterExpression"`
+	Weights                 bson.D   `bson:"weights"`
+	Collation               *struct {
+		Locale   string `bson:"locale"`
+		Strength int    `bson:"strength"`
+	} `bson:"collation"`
+}
+
+// Sync bring the collection's indexes in line with the declared ones: create the missing ones,
+// report drifted and undeclared ones and, when asked to, drop the undeclared ones
+func Sync(ctx context.Context, collection *mongo.Collection, declared []Index, opts ...*SyncOptions) (*Report, error) {
+	opt := mergeSyncOptions(opts)
+
+	current, err := list(ctx, collection)
+	if err != nil {
+		return nil, fmt.Errorf("could not list indexes of %s: %w", collection.Name(), err)
+	}
+
+	report := &Report{}
+	matched := make(map[string]bool)
+
+	var missing []Index
+	for _, index := range declared {
+		found := slices.IndexFunc(current, func(e existing) bool {
+			return !matched[e.Name] && sameKeys(index, e)
+		})
+
+		switch {
+		case found >= 0:
+			e := current[found]
+			matched[e.Name] = true
+			if differences := compare(index, e); len(differences) > 0 {
+				report.Drifted = append(report.Drifted, Drift{Declared: index, Existing: e.Name, Differences: differences})
+			} else {
+				report.Unchanged = append(report.Unchanged, e.Name)
+			}
+		case slices.ContainsFunc(current, func(e existing) bool { return e.Name == index.IndexName() }):
+			matched[index.IndexName()] = true
+			report.Drifted = append(report.Drifted, Drift{Declared: index, Existing: index.IndexName(), Differences: []string{"keys"}})
+		default:
+			missing = append(missing, index)
+		}
+	}
+
+	for _, e := range current {
+		if !matched[e.Name] && e.Name != "_id_" {
+			report.Undeclared = append(report.Undeclared, e.Name)
+		}
+	}
+
+	for _, drift := range report.Drifted {
+		log.Warn(fmt.Sprintf("index drift on %s: %s", collection.Name(), drift))
+	}
+
+	report.Created = missing
+	if opt.DropUndeclared {
+		report.Dropped = report.Undeclared
+		report.Undeclared = nil
+	}
+
+	if opt.DryRun {
+		return report, nil
+	}
+
+	for _, name := range report.Dropped {
+		if err := collection.Indexes().DropOne(ctx, name); err != nil {
+			return report, fmt.Errorf("could not drop index %s of %s: %w", name, collection.Name(), err)
+		}
+		log.Info(fmt.Sprintf("dropped index %s of %s", name, collection.Name()))
+	}
+
+	if len(missing) > 0 {
+		models := make([]mongo.IndexModel, len(missing))
+		for i, index := range missing {
+			models[i] = index.Model()
+		}
+
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			log.WithError(err).Error(fmt.Sprintf("Failed to create indices for %s collection", collection.Name()))
+			return report, err
+		}
+
+		log.WithFields(log.Fields{
+			"count": len(missing),
+		}).Info(fmt.Sprintf("created %s indexes", collection.Name()))
+	}
+
+	return report, nil
+}
+
+func list(ctx context.Context, collection *mongo.Collection) ([]existing, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var current []existing
+	if err := cursor.All(ctx, &current); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// sameKeys whether the existing index is on the declared keys. The server stores the text fields
+// of an index as _fts and _ftsx keys and lists them in its weights.
+func sameKeys(index Index, e existing) bool {
+	var keys bson.D
+	var textFields []string
+	for _, key := range index.Keys {
+		if key.Value != Text {
+			keys = append(keys, key)
+			continue
+		}
+
+		if textFields == nil {
+			keys = append(keys, bson.E{Key: "_fts", Value: Text}, bson.E{Key: "_ftsx", Value: 1})
+		}
+		textFields = append(textFields, key.Key)
+	}
+
+	if len(keys) != len(e.Key) {
+		return false
+	}
+	for i, key := range keys {
+		if key.Key != e.Key[i].Key || !sameValue(key.Value, e.Key[i].Value) {
+			return false
+		}
+	}
+
+	if textFields == nil {
+		return true
+	}
+
+	weighted := make([]string, len(e.Weights))
+	for i, weight := range e.Weights {
+		weighted[i] = weight.Key
+	}
+	slices.Sort(textFields)
+	slices.Sort(weighted)
+	return slices.Equal(textFields, weighted)
+}
+
+// compare list the options in which the existing index differs from the declared one
+func compare(index Index, e existing) []string {
+	var differences []string
+	if index.Name != "" && index.Name != e.Name {
+		differences = append(differences, "name")
+	}
+	if index.Unique != e.Unique {
+		differences = append(differences, "unique")
+	}
+	if index.Sparse != e.Sparse {
+		differences = append(differences, "sparse")
+	}
+
+	switch {
+	case (index.ExpireAfter == nil) != (e.ExpireAfterSeconds == nil):
+		differences = append(differences, "ttl")
+	case index.ExpireAfter != nil && index.ExpireAfter.Seconds() != *e.ExpireAfterSeconds:
+		differences = append(differences, "ttl")
+	}
+
+	if !reflect.DeepEqual(normalize(index.PartialFilter), normalize(e.PartialFilterExpression)) {
+		differences = append(differences, "partial filter")
+	}
+
+	switch {
+	case (index.Collation == nil) != (e.Collation == nil):
+		differences = append(differences, "collation")
+	case index.Collation != nil:
+		strength := index.Collation.Strength
+		if strength == 0 {
+			strength = 3
+		}
+		if index.Collation.Locale != e.Collation.Locale || strength != e.Collation.Strength {
+			differences = append(differences, "collation")
+		}
+	}
+
+	return differences
+}
+
+// normalize a document as the server returns it, so declared and listed documents compare equal
+func normalize(document bson.D) any {
+	if document == nil {
+		return nil
+	}
+
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return document
+	}
+
+	var normalized bson.D
+	if err := bson.Unmarshal(raw, &normalized); err != nil {
+		return document
+	}
+	return normalized
+}
+
+// sameValue compare key values, which the server may return as any numeric type
+func sameValue(declared, actual any) bool {
+	if a, ok := number(declared); ok {
+		b, ok := number(actual)
+		return ok && a == b
+	}
+	return declared == actual
+}
+
+func number(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func mergeSyncOptions(opts []*SyncOptions) SyncOptions {
+	var merged SyncOptions
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.DropUndeclared {
+			merged.DropUndeclared = true
+		}
+		if opt.DryRun {
+			merged.DryRun = true
+		}
+	}
+	return merged
+}
@@ -0,0 +1,192 @@
+package indexes
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/internal/bsonfield"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// For the indexes T declares in its struct tags, including those of embedded documents, followed
+// by those its Indexes method returns when it implements Indexed
+func For[T any]() ([]Index, error) {
+	var declarations []declaration
+	if err := collect(reflect.TypeFor[T](), "", nil, &declarations); err != nil {
+		return nil, err
+	}
+
+	declared, err := build(declarations)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", reflect.TypeFor[T](), err)
+	}
+
+	var zero T
+	if indexed, ok := any(zero).(Indexed); ok {
+		declared = append(declared, indexed.Indexes()...)
+	} else if indexed, ok := any(&zero).(Indexed); ok {
+		declared = append(declared, indexed.Indexes()...)
+	}
+
+	return declared, nil
+}
+
+// declaration one field's part of an index tag
+type declaration struct {
+	path  string
+	key   any
+	order int
+	// position the field's position in the struct, ordering compound keys without an order
+	position int
+	index    Index
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// collect the index declarations of a struct type's fields, descending into embedded documents
+func collect(t reflect.Type, prefix string, visiting []reflect.Type, declarations *[]declaration) error {
+	t = bsonfield.Indirect(t)
+	if t.Kind() != reflect.Struct || slices.Contains(visiting, t) {
+		return nil
+	}
+	visiting = append(visiting, t)
+
+	for _, field := range bsonfield.Fields(t) {
+		path := prefix + field.Name
+
+		if tag, ok := field.StructField.Tag.Lookup("index"); ok {
+			for _, spec := range strings.Split(tag, ";") {
+				d, err := parse(strings.TrimSpace(spec))
+				if err != nil {
+					return fmt.Errorf("field %s: index tag %q: %w", path, tag, err)
+				}
+				d.path = path
+				d.position = len(*declarations)
+				*declarations = append(*declarations, d)
+			}
+		}
+
+		element := bsonfield.Indirect(field.Type)
+		if element.Kind() == reflect.Slice || element.Kind() == reflect.Array {
+			element = bsonfield.Indirect(element.Elem())
+		}
+		if element.Kind() == reflect.Struct && element != timeType {
+			if err := collect(element, path+".", visiting, declarations); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parse one index declaration of a tag
+func parse(spec string) (declaration, error) {
+	d := declaration{key: 1}
+	if spec == "" {
+		return d, nil
+	}
+
+	for _, token := range strings.Split(spec, ",") {
+		token = strings.TrimSpace(token)
+		option, value, _ := strings.Cut(token, "=")
+
+		switch option {
+		case "", "asc":
+			d.key = 1
+		case "desc":
+			d.key = -1
+		case Text, Sphere2D, Hashed:
+			d.key = option
+		case "unique":
+			d.index.Unique = true
+		case "sparse":
+			d.index.Sparse = true
+		case "name":
+			d.index.Name = value
+		case "order":
+			order, err := strconv.Atoi(value)
+			if err != nil {
+				return d, fmt.Errorf("invalid order %q", value)
+			}
+			d.order = order
+		case "ttl":
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < 0 {
+				return d, fmt.Errorf("invalid ttl %q", value)
+			}
+			d.index.ExpireAfter = &ttl
+		case "collation":
+			locale, strength, _ := strings.Cut(value, ":")
+			collation := &options.Collation{Locale: locale}
+			if strength != "" {
+				level, err := strconv.Atoi(strength)
+				if err != nil || level < 1 || level > 5 {
+					return d, fmt.Errorf("invalid collation strength %q", strength)
+				}
+				collation.Strength = level
+			}
+			d.index.Collation = collation
+		default:
+			return d, fmt.Errorf("unknown option %q", token)
+		}
+	}
+
+	return d, nil
+}
+
+// build group the declarations into indexes: named ones by name, the others one per field
+func build(declarations []declaration) ([]Index, error) {
+	var declared []Index
+	groups := make(map[string][]declaration)
+	var names []string
+
+	for _, d := range declarations {
+		if d.index.Name == "" {
+			d.index.Keys = bson.D{{Key: d.path, Value: d.key}}
+			declared = append(declared, d.index)
+			continue
+		}
+
+		if _, ok := groups[d.index.Name]; !ok {
+			names = append(names, d.index.Name)
+		}
+		groups[d.index.Name] = append(groups[d.index.Name], d)
+	}
+
+	for _, name := range names {
+		group := groups[name]
+		slices.SortStableFunc(group, func(a, b declaration) int {
+			if a.order != b.order {
+				return cmp.Compare(a.order, b.order)
+			}
+			return cmp.Compare(a.position, b.position)
+		})
+
+		index := Index{Name: name}
+		for _, d := range group {
+			index.Keys = append(index.Keys, bson.E{Key: d.path, Value: d.key})
+			index.Unique = index.Unique || d.index.Unique
+			index.Sparse = index.Sparse || d.index.Sparse
+			if d.index.ExpireAfter != nil {
+				index.ExpireAfter = d.index.ExpireAfter
+			}
+			if d.index.Collation != nil {
+				index.Collation = d.index.Collation
+			}
+		}
+
+		if index.ExpireAfter != nil && len(index.Keys) > 1 {
+			return nil, fmt.Errorf("index %s: a ttl index must have a single field", name)
+		}
+		declared = append(declared, index)
+	}
+
+	return declared, nil
+}
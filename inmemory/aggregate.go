@@ -0,0 +1,315 @@
+package inmemory
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// aggregate run a pipeline over normalized documents. Supported stages are $match, $sort, $skip,
+// $limit, $project, $count, $group and $unwind.
+func aggregate(documents []bson.D, pipeline []bson.D) ([]bson.D, error) {
+	for _, stage := range pipeline {
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("a pipeline stage must have exactly one field, got %d", len(stage))
+		}
+
+		var err error
+		if documents, err = runStage(documents, stage[0].Key, stage[0].Value); err != nil {
+			return nil, fmt.Errorf("%s: %w", stage[0].Key, err)
+		}
+	}
+	return documents, nil
+}
+
+func runStage(documents []bson.D, name string, argument any) ([]bson.D, error) {
+	switch name {
+	case "$match":
+		filter, ok := argument.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("the filter must be an object")
+		}
+		return filterDocuments(documents, filter)
+	case "$sort":
+		sort, ok := argument.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("the sort specification must be an object")
+		}
+		return documents, sortDocuments(documents, sort)
+	case "$skip", "$limit":
+		n, ok := asInt(argument)
+		if !ok || n < 0 || (name == "$limit" && n == 0) {
+			return nil, fmt.Errorf("needs a positive integer, got %v", argument)
+		}
+		if name == "$skip" {
+			return documents[min(int(n), len(documents)):], nil
+		}
+		return documents[:min(int(n), len(documents))], nil
+	case "$project":
+		spec, ok := argument.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("the specification must be an object")
+		}
+
+		projection, err := parseProjection(spec, true)
+		if err != nil {
+			return nil, err
+		}
+
+		result := make([]bson.D, 0, len(documents))
+		for _, document := range documents {
+			projected, err := projection.apply(document)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, projected)
+		}
+		return result, nil
+	case "$count":
+		field, ok := argument.(string)
+		if !ok || field == "" || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+			return nil, fmt.Errorf("the field must be a non-empty string without '$' or '.'")
+		}
+		if len(documents) == 0 {
+			return nil, nil
+		}
+		return []bson.D{{{Key: field, Value: int32(len(documents))}}}, nil
+	case "$group":
+		spec, ok := argument.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("the specification must be an object")
+		}
+		return group(documents, spec)
+	case "$unwind":
+		return unwind(documents, argument)
+	}
+
+	return nil, fmt.Errorf("unsupported aggregation stage")
+}
+
+func filterDocuments(documents []bson.D, filter bson.D) ([]bson.D, error) {
+	var result []bson.D
+	for _, document := range documents {
+		ok, err := matches(document, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, document)
+		}
+	}
+	return result, nil
+}
+
+func unwind(documents []bson.D, argument any) ([]bson.D, error) {
+	var path, indexField string
+	var preserve bool
+
+	switch spec := argument.(type) {
+	case string:
+		path = spec
+	case bson.D:
+		value, _ := get(spec, "path")
+		path, _ = value.(string)
+		value, _ = get(spec, "includeArrayIndex")
+		indexField, _ = value.(string)
+		value, _ = get(spec, "preserveNullAndEmptyArrays")
+		preserve = truthy(value)
+	}
+
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("the path must be a field path prefixed with '$'")
+	}
+	segments := splitPath(path[1:])
+
+	var result []bson.D
+	for _, document := range documents {
+		value, exists := getPath(document, segments)
+
+		array, isArray := value.(bson.A)
+		if !isArray {
+			if exists && !isNull(value) {
+				array = bson.A{value}
+			} else if preserve {
+				result = append(result, withIndex(document, indexField, nil))
+				continue
+			}
+		}
+
+		if len(array) == 0 && preserve {
+			result = append(result, withIndex(unsetPath(document, segments).(bson.D), indexField, nil))
+			continue
+		}
+
+		for i, element := range array {
+			unwound, err := setPath(document, segments, element)
+			if err != nil {
+				return nil, err
+			}
+
+			var index any
+			if isArray {
+				index = int64(i)
+			}
+			result = append(result, withIndex(unwound.(bson.D), indexField, index))
+		}
+	}
+	return result, nil
+}
+
+func withIndex(document bson.D, field string, index any) bson.D {
+	if field == "" {
+		return document
+	}
+
+	result, _ := setPath(document, splitPath(field), index)
+	return result.(bson.D)
+}
+
+type groupAccumulator struct {
+	field    string
+	operator string
+	argument any
+}
+
+type groupState struct {
+	id     any
+	values []any
+	counts []int64
+	seen   []bool
+}
+
+// group a $group stage with the $sum, $avg, $min, $max, $first, $last, $push, $addToSet and
+// $count accumulators
+func group(documents []bson.D, spec bson.D) ([]bson.D, error) {
+	idExpression, ok := get(spec, "_id")
+	if !ok {
+		return nil, fmt.Errorf("a group specification must include an _id")
+	}
+
+	var accumulators []groupAccumulator
+	for _, element := range spec {
+		if element.Key == "_id" {
+			continue
+		}
+
+		definition, ok := element.Value.(bson.D)
+		if !ok || len(definition) != 1 {
+			return nil, fmt.Errorf("the field %s must be an accumulator object", element.Key)
+		}
+		accumulators = append(accumulators, groupAccumulator{
+			field:    element.Key,
+			operator: definition[0].Key,
+			argument: definition[0].Value,
+		})
+	}
+
+	var groups []*groupState
+	for _, document := range documents {
+		id, err := evaluate(document, idExpression)
+		if err != nil {
+			return nil, err
+		}
+
+		var state *groupState
+		for _, candidate := range groups {
+			if equalValues(candidate.id, id) {
+				state = candidate
+				break
+			}
+		}
+		if state == nil {
+			state = &groupState{
+				id:     id,
+				values: make([]any, len(accumulators)),
+				counts: make([]int64, len(accumulators)),
+				seen:   make([]bool, len(accumulators)),
+			}
+			groups = append(groups, state)
+		}
+
+		for i, accumulator := range accumulators {
+			if err := accumulate(state, i, accumulator, document); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := make([]bson.D, 0, len(groups))
+	for _, state := range groups {
+		document := bson.D{{Key: "_id", Value: state.id}}
+		for i, accumulator := range accumulators {
+			value := state.values[i]
+			if accumulator.operator == "$avg" {
+				if state.counts[i] == 0 {
+					value = nil
+				} else {
+					sum, _ := asFloat(value)
+					value = sum / float64(state.counts[i])
+				}
+			}
+			document = append(document, bson.E{Key: accumulator.field, Value: value})
+		}
+		result = append(result, document)
+	}
+	return result, nil
+}
+
+func accumulate(state *groupState, i int, accumulator groupAccumulator, document bson.D) error {
+	if accumulator.operator == "$count" {
+		state.values[i] = add(orZero(state.values[i]), int32(1))
+		return nil
+	}
+
+	value, err := evaluate(document, accumulator.argument)
+	if err != nil {
+		return err
+	}
+
+	switch accumulator.operator {
+	case "$sum", "$avg":
+		if _, ok := asFloat(value); !ok {
+			state.values[i] = orZero(state.values[i])
+			return nil
+		}
+		state.values[i] = add(orZero(state.values[i]), value)
+		state.counts[i]++
+	case "$min", "$max":
+		if isNull(value) {
+			return nil
+		}
+		c := compareValues(value, state.values[i])
+		if !state.seen[i] || (accumulator.operator == "$min" && c < 0) || (accumulator.operator == "$max" && c > 0) {
+			state.values[i] = value
+		}
+		state.seen[i] = true
+	case "$first":
+		if !state.seen[i] {
+			state.values[i] = value
+			state.seen[i] = true
+		}
+	case "$last":
+		state.values[i] = value
+	case "$push", "$addToSet":
+		array, _ := state.values[i].(bson.A)
+		if array == nil {
+			array = bson.A{}
+		}
+		if accumulator.operator == "$push" || !containsValue(array, value) {
+			array = append(array, value)
+		}
+		state.values[i] = array
+	default:
+		return fmt.Errorf("unsupported accumulator %s", accumulator.operator)
+	}
+
+	return nil
+}
+
+func orZero(value any) any {
+	if value == nil {
+		return int32(0)
+	}
+	return value
+}
@@ -0,0 +1,15 @@
+package inmemory_test
+
+import (
+	"testing"
+
+	"github.com/hub1989/mongo-data/v4/inmemory"
+	"github.com/hub1989/mongo-data/v4/repository"
+	"github.com/hub1989/mongo-data/v4/repositorytest"
+)
+
+func TestConformance(t *testing.T) {
+	repositorytest.RunConformance(t, func(t *testing.T) repository.Repository[repositorytest.Entity] {
+		return inmemory.New[repositorytest.Entity]()
+	})
+}
@@ -0,0 +1,296 @@
+package inmemory
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// matches report whether a normalized document satisfies a normalized query filter
+func matches(document bson.D, filter bson.D) (bool, error) {
+	for _, element := range filter {
+		ok, err := matchElement(document, element.Key, element.Value)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchElement(document bson.D, key string, condition any) (bool, error) {
+	switch key {
+	case "$and", "$or", "$nor":
+		clauses, ok := condition.(bson.A)
+		if !ok || len(clauses) == 0 {
+			return false, fmt.Errorf("%s must be a nonempty array", key)
+		}
+
+		for _, clause := range clauses {
+			filter, ok := clause.(bson.D)
+			if !ok {
+				return false, fmt.Errorf("%s entries must be documents", key)
+			}
+
+			ok, err := matches(document, filter)
+			if err != nil {
+				return false, err
+			}
+
+			switch {
+			case key == "$and" && !ok:
+				return false, nil
+			case key == "$or" && ok:
+				return true, nil
+			case key == "$nor" && ok:
+				return false, nil
+			}
+		}
+		return key != "$or", nil
+	}
+
+	if strings.HasPrefix(key, "$") {
+		return false, fmt.Errorf("unsupported query operator %s", key)
+	}
+
+	return matchField(resolve(document, splitPath(key)), condition)
+}
+
+// matchField match the values a path resolved to against either an operator expression such as
+// {"$gt": 1} or a plain value compared for equality
+func matchField(values []any, condition any) (bool, error) {
+	if expression, ok := operatorExpression(condition); ok {
+		for _, element := range expression {
+			if element.Key == "$options" {
+				continue
+			}
+
+			ok, err := matchOperator(values, element.Key, element.Value, expression)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	if regex, ok := condition.(bson.Regex); ok {
+		return matchRegex(values, regex.Pattern, regex.Options)
+	}
+
+	return matchEquals(values, condition), nil
+}
+
+// operatorExpression the condition as a document of operators, when it is one
+func operatorExpression(condition any) (bson.D, bool) {
+	expression, ok := condition.(bson.D)
+	if !ok || len(expression) == 0 || !strings.HasPrefix(expression[0].Key, "$") {
+		return nil, false
+	}
+	return expression, true
+}
+
+func matchOperator(values []any, operator string, argument any, expression bson.D) (bool, error) {
+	switch operator {
+	case "$eq":
+		return matchEquals(values, argument), nil
+	case "$ne":
+		return !matchEquals(values, argument), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return matchCompare(values, operator, argument), nil
+	case "$in", "$nin":
+		list, ok := argument.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("%s needs an array", operator)
+		}
+
+		found := false
+		for _, candidate := range list {
+			var ok bool
+			if regex, isRegex := candidate.(bson.Regex); isRegex {
+				var err error
+				if ok, err = matchRegex(values, regex.Pattern, regex.Options); err != nil {
+					return false, err
+				}
+			} else {
+				ok = matchEquals(values, candidate)
+			}
+			if ok {
+				found = true
+				break
+			}
+		}
+		return found == (operator == "$in"), nil
+	case "$exists":
+		return (len(values) > 0) == truthy(argument), nil
+	case "$regex":
+		switch pattern := argument.(type) {
+		case string:
+			options, _ := get(expression, "$options")
+			optionString, _ := options.(string)
+			return matchRegex(values, pattern, optionString)
+		case bson.Regex:
+			return matchRegex(values, pattern.Pattern, pattern.Options)
+		}
+		return false, fmt.Errorf("$regex needs a string")
+	case "$not":
+		ok, err := matchField(values, argument)
+		return !ok, err
+	case "$size":
+		size, ok := asInt(argument)
+		if !ok {
+			if f, isFloat := argument.(float64); isFloat && f == float64(int64(f)) {
+				size, ok = int64(f), true
+			}
+		}
+		if !ok {
+			return false, fmt.Errorf("$size needs a whole number")
+		}
+
+		for _, value := range values {
+			if array, ok := value.(bson.A); ok && int64(len(array)) == size {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$elemMatch":
+		condition, ok := argument.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("$elemMatch needs an object")
+		}
+
+		for _, value := range values {
+			array, ok := value.(bson.A)
+			if !ok {
+				continue
+			}
+
+			for _, element := range array {
+				ok, err := matchElementCondition(element, condition)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case "$all":
+		list, ok := argument.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("$all needs an array")
+		}
+		if len(list) == 0 {
+			return false, nil
+		}
+
+		for _, candidate := range list {
+			var ok bool
+			var err error
+			if expression, isExpression := operatorExpression(candidate); isExpression && expression[0].Key == "$elemMatch" {
+				ok, err = matchField(values, expression)
+			} else {
+				ok, err = matchField(values, candidate)
+			}
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	return false, fmt.Errorf("unsupported query operator %s", operator)
+}
+
+// matchElementCondition match one array element for $elemMatch and $pull: operators apply to the
+// element itself, anything else is a query on an embedded document
+func matchElementCondition(element any, condition bson.D) (bool, error) {
+	if _, ok := operatorExpression(condition); ok {
+		return matchField([]any{element}, condition)
+	}
+
+	document, ok := element.(bson.D)
+	if !ok {
+		return false, nil
+	}
+	return matches(document, condition)
+}
+
+// candidates the values a condition is tested against: each resolved value and, for arrays,
+// each of their elements
+func candidates(values []any) []any {
+	var result []any
+	for _, value := range values {
+		result = append(result, value)
+		if array, ok := value.(bson.A); ok {
+			result = append(result, array...)
+		}
+	}
+	return result
+}
+
+func matchEquals(values []any, expected any) bool {
+	if isNull(expected) && len(values) == 0 {
+		return true
+	}
+
+	for _, value := range candidates(values) {
+		if equalValues(value, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCompare apply a range operator. As in MongoDB, values only compare with values of the
+// same type bracket, so {"$gt": 1} never matches a string.
+func matchCompare(values []any, operator string, bound any) bool {
+	for _, value := range candidates(values) {
+		if typeOrder(value) != typeOrder(bound) {
+			continue
+		}
+
+		c := compareValues(value, bound)
+		switch {
+		case operator == "$gt" && c > 0,
+			operator == "$gte" && c >= 0,
+			operator == "$lt" && c < 0,
+			operator == "$lte" && c <= 0:
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegex(values []any, pattern string, options string) (bool, error) {
+	expression, err := compileRegex(pattern, options)
+	if err != nil {
+		return false, err
+	}
+
+	for _, value := range candidates(values) {
+		if s, ok := value.(string); ok && expression.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func compileRegex(pattern string, options string) (*regexp.Regexp, error) {
+	var flags string
+	for _, option := range options {
+		switch option {
+		case 'i', 'm', 's':
+			flags += string(option)
+		case 'x', 'u':
+		default:
+			return nil, fmt.Errorf("unsupported regex option %q", option)
+		}
+	}
+
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
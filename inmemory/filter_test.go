@@ -0,0 +1,244 @@
+package inmemory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func mustDocument(t *testing.T, value any) bson.D {
+	document, err := toDocument(value)
+	assert.Nil(t, err)
+	return document
+}
+
+func TestMatches(t *testing.T) {
+	document := mustDocument(t, bson.M{
+		"name":  "alice",
+		"age":   30,
+		"score": 4.5,
+		"tags":  bson.A{"admin", "staff"},
+		"address": bson.M{
+			"city": "Lagos",
+			"zip":  "100001",
+		},
+		"items": bson.A{
+			bson.M{"sku": "a", "qty": 2},
+			bson.M{"sku": "b", "qty": 5},
+		},
+		"nothing": nil,
+	})
+
+	tests := []struct {
+		name   string
+		filter bson.M
+		want   bool
+	}{
+		{"empty", bson.M{}, true},
+		{"equality", bson.M{"name": "alice"}, true},
+		{"equality miss", bson.M{"name": "bob"}, false},
+		{"numbers compare across types", bson.M{"age": 30.0}, true},
+		{"$eq", bson.M{"age": bson.M{"$eq": int64(30)}}, true},
+		{"$ne", bson.M{"name": bson.M{"$ne": "alice"}}, false},
+		{"$gt", bson.M{"age": bson.M{"$gt": 29}}, true},
+		{"$gte $lte", bson.M{"age": bson.M{"$gte": 30, "$lte": 30}}, true},
+		{"$lt", bson.M{"score": bson.M{"$lt": 4}}, false},
+		{"range ignores other types", bson.M{"name": bson.M{"$gt": 1}}, false},
+		{"$in", bson.M{"name": bson.M{"$in": bson.A{"bob", "alice"}}}, true},
+		{"$in regex", bson.M{"name": bson.M{"$in": bson.A{bson.Regex{Pattern: "^al"}}}}, true},
+		{"$nin", bson.M{"name": bson.M{"$nin": bson.A{"alice"}}}, false},
+		{"array contains", bson.M{"tags": "staff"}, true},
+		{"array equals", bson.M{"tags": bson.A{"admin", "staff"}}, true},
+		{"dotted path", bson.M{"address.city": "Lagos"}, true},
+		{"array fan out", bson.M{"items.sku": "b"}, true},
+		{"array index", bson.M{"items.0.sku": "b"}, false},
+		{"$exists", bson.M{"address.zip": bson.M{"$exists": true}}, true},
+		{"$exists false", bson.M{"missing": bson.M{"$exists": false}}, true},
+		{"null matches missing", bson.M{"missing": nil}, true},
+		{"null matches null", bson.M{"nothing": nil}, true},
+		{"$regex", bson.M{"name": bson.M{"$regex": "^AL", "$options": "i"}}, true},
+		{"regex value", bson.M{"address.city": bson.Regex{Pattern: "os$"}}, true},
+		{"$not", bson.M{"age": bson.M{"$not": bson.M{"$gt": 40}}}, true},
+		{"$size", bson.M{"tags": bson.M{"$size": 2}}, true},
+		{"$all", bson.M{"tags": bson.M{"$all": bson.A{"staff", "admin"}}}, true},
+		{"$all miss", bson.M{"tags": bson.M{"$all": bson.A{"staff", "root"}}}, false},
+		{"$elemMatch", bson.M{"items": bson.M{"$elemMatch": bson.M{"sku": "a", "qty": bson.M{"$gt": 1}}}}, true},
+		{"$elemMatch miss", bson.M{"items": bson.M{"$elemMatch": bson.M{"sku": "a", "qty": bson.M{"$gt": 2}}}}, false},
+		{"$and", bson.M{"$and": bson.A{bson.M{"name": "alice"}, bson.M{"age": 30}}}, true},
+		{"$or", bson.M{"$or": bson.A{bson.M{"name": "bob"}, bson.M{"age": 30}}}, true},
+		{"$nor", bson.M{"$nor": bson.A{bson.M{"name": "bob"}, bson.M{"age": 30}}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, err := matches(document, mustDocument(t, test.filter))
+			assert.Nil(t, err)
+			assert.Equal(t, test.want, ok)
+		})
+	}
+}
+
+func TestMatches_UnsupportedOperator(t *testing.T) {
+	_, err := matches(bson.D{{Key: "a", Value: int32(1)}}, mustDocument(t, bson.M{"a": bson.M{"$where": "x"}}))
+	assert.ErrorContains(t, err, "$where")
+}
+
+func TestApplyUpdate(t *testing.T) {
+	document := mustDocument(t, bson.M{
+		"_id":     1,
+		"count":   2,
+		"tags":    bson.A{"a", "b"},
+		"address": bson.M{"city": "Lagos"},
+	})
+
+	update := mustDocument(t, bson.D{
+		{Key: "$set", Value: bson.M{"address.zip": "100001", "name": "x"}},
+		{Key: "$inc", Value: bson.M{"count": 3}},
+		{Key: "$push", Value: bson.M{"tags": bson.M{"$each": bson.A{"c", "a"}}}},
+		{Key: "$addToSet", Value: bson.M{"roles": "admin"}},
+		{Key: "$max", Value: bson.M{"high": 7}},
+		{Key: "$setOnInsert", Value: bson.M{"created": true}},
+	})
+
+	updated, err := applyUpdate(document, update, false)
+	assert.Nil(t, err)
+
+	var result bson.M
+	raw, _ := bson.Marshal(updated)
+	assert.Nil(t, bson.Unmarshal(raw, &result))
+
+	assert.Equal(t, int32(5), result["count"])
+	assert.Equal(t, "x", result["name"])
+	assert.Equal(t, bson.A{"a", "b", "c", "a"}, result["tags"])
+	assert.Equal(t, bson.A{"admin"}, result["roles"])
+	assert.Equal(t, int32(7), result["high"])
+	assert.Equal(t, bson.D{{Key: "city", Value: "Lagos"}, {Key: "zip", Value: "100001"}}, result["address"])
+	assert.NotContains(t, result, "created")
+
+	pulled, err := applyUpdate(updated, mustDocument(t, bson.D{
+		{Key: "$pull", Value: bson.M{"tags": "a"}},
+		{Key: "$unset", Value: bson.M{"address.zip": ""}},
+		{Key: "$mul", Value: bson.M{"count": 2}},
+	}), false)
+	assert.Nil(t, err)
+
+	tags, _ := getPath(pulled, []string{"tags"})
+	assert.Equal(t, bson.A{"b", "c"}, tags)
+	_, exists := getPath(pulled, []string{"address", "zip"})
+	assert.False(t, exists)
+	count, _ := getPath(pulled, []string{"count"})
+	assert.Equal(t, int32(10), count)
+}
+
+func TestApplyUpdate_Errors(t *testing.T) {
+	document := mustDocument(t, bson.M{"_id": 1, "name": "x"})
+
+	_, err := applyUpdate(document, mustDocument(t, bson.M{"name": "y"}), false)
+	assert.ErrorContains(t, err, "'$'")
+
+	_, err = applyUpdate(document, mustDocument(t, bson.M{"$set": bson.M{"_id": 2}}), false)
+	assert.ErrorContains(t, err, "immutable")
+
+	_, err = applyUpdate(document, mustDocument(t, bson.M{"$inc": bson.M{"name": 1}}), false)
+	assert.ErrorContains(t, err, "non-numeric")
+
+	_, err = applyUpdate(document, mustDocument(t, bson.M{"$rename": bson.M{"name": "n"}}), false)
+	assert.ErrorContains(t, err, "unsupported")
+}
+
+func TestProjection(t *testing.T) {
+	document := mustDocument(t, bson.D{
+		{Key: "_id", Value: 1},
+		{Key: "name", Value: "x"},
+		{Key: "address", Value: bson.D{{Key: "city", Value: "Lagos"}, {Key: "zip", Value: "1"}}},
+	})
+
+	project := func(spec bson.D) bson.D {
+		p, err := parseProjection(mustDocument(t, spec), false)
+		assert.Nil(t, err)
+		result, err := p.apply(document)
+		assert.Nil(t, err)
+		return result
+	}
+
+	assert.Equal(t, bson.D{{Key: "_id", Value: int32(1)}, {Key: "address", Value: bson.D{{Key: "city", Value: "Lagos"}}}},
+		project(bson.D{{Key: "address.city", Value: 1}}))
+	assert.Equal(t, bson.D{{Key: "name", Value: "x"}},
+		project(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 0}}))
+	assert.Equal(t, bson.D{{Key: "name", Value: "x"}, {Key: "address", Value: bson.D{{Key: "zip", Value: "1"}}}},
+		project(bson.D{{Key: "_id", Value: 0}, {Key: "address.city", Value: 0}}))
+
+	_, err := parseProjection(mustDocument(t, bson.D{{Key: "name", Value: 1}, {Key: "address", Value: 0}}), false)
+	assert.NotNil(t, err)
+}
+
+func TestSortDocuments(t *testing.T) {
+	documents := []bson.D{
+		mustDocument(t, bson.M{"_id": 1, "age": 30, "name": "b"}),
+		mustDocument(t, bson.M{"_id": 2, "name": "a"}),
+		mustDocument(t, bson.M{"_id": 3, "age": 20, "name": "c"}),
+		mustDocument(t, bson.M{"_id": 4, "age": 30, "name": "a"}),
+	}
+
+	assert.Nil(t, sortDocuments(documents, mustDocument(t, bson.D{{Key: "age", Value: -1}, {Key: "name", Value: 1}})))
+
+	var ids []any
+	for _, document := range documents {
+		id, _ := get(document, "_id")
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []any{int32(4), int32(1), int32(3), int32(2)}, ids)
+}
+
+func TestAggregate(t *testing.T) {
+	documents := []bson.D{
+		mustDocument(t, bson.M{"_id": 1, "team": "red", "points": 3, "tags": bson.A{"a", "b"}}),
+		mustDocument(t, bson.M{"_id": 2, "team": "blue", "points": 5, "tags": bson.A{"b"}}),
+		mustDocument(t, bson.M{"_id": 3, "team": "red", "points": 4}),
+	}
+
+	pipeline := func(stages ...bson.D) []bson.D {
+		var normalized []bson.D
+		for _, stage := range stages {
+			normalized = append(normalized, mustDocument(t, stage))
+		}
+		return normalized
+	}
+
+	grouped, err := aggregate(documents, pipeline(
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$team"},
+			{Key: "total", Value: bson.M{"$sum": "$points"}},
+			{Key: "count", Value: bson.M{"$sum": 1}},
+			{Key: "average", Value: bson.M{"$avg": "$points"}},
+			{Key: "best", Value: bson.M{"$max": "$points"}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	))
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.D{
+		{{Key: "_id", Value: "blue"}, {Key: "total", Value: int32(5)}, {Key: "count", Value: int32(1)}, {Key: "average", Value: 5.0}, {Key: "best", Value: int32(5)}},
+		{{Key: "_id", Value: "red"}, {Key: "total", Value: int32(7)}, {Key: "count", Value: int32(2)}, {Key: "average", Value: 3.5}, {Key: "best", Value: int32(4)}},
+	}, grouped)
+
+	unwound, err := aggregate(documents, pipeline(
+		bson.D{{Key: "$unwind", Value: "$tags"}},
+		bson.D{{Key: "$match", Value: bson.M{"tags": "b"}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "team": 1, "tag": "$tags"}}},
+		bson.D{{Key: "$skip", Value: 1}},
+		bson.D{{Key: "$limit", Value: 1}},
+	))
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.D{{{Key: "team", Value: "blue"}, {Key: "tag", Value: "b"}}}, unwound)
+
+	counted, err := aggregate(documents, pipeline(
+		bson.D{{Key: "$match", Value: bson.M{"team": "red"}}},
+		bson.D{{Key: "$count", Value: "n"}},
+	))
+	assert.Nil(t, err)
+	assert.Equal(t, []bson.D{{{Key: "n", Value: int32(2)}}}, counted)
+
+	_, err = aggregate(documents, pipeline(bson.D{{Key: "$lookup", Value: bson.M{}}}))
+	assert.ErrorContains(t, err, "$lookup")
+}
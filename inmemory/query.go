@@ -0,0 +1,315 @@
+package inmemory
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// sortDocuments stable sort documents by a sort specification such as {"age": -1}
+func sortDocuments(documents []bson.D, sort bson.D) error {
+	directions := make([]int, len(sort))
+	for i, element := range sort {
+		direction, ok := asInt(element.Value)
+		if !ok {
+			if f, isFloat := element.Value.(float64); isFloat {
+				direction, ok = int64(f), true
+			}
+		}
+		if !ok || (direction != 1 && direction != -1) {
+			return fmt.Errorf("invalid sort direction for %s: %v", element.Key, element.Value)
+		}
+		directions[i] = int(direction)
+	}
+
+	slices.SortStableFunc(documents, func(a, b bson.D) int {
+		for i, element := range sort {
+			path := splitPath(element.Key)
+			ascending := directions[i] == 1
+
+			c := compareValues(sortKey(a, path, ascending), sortKey(b, path, ascending))
+			if c != 0 {
+				return c * directions[i]
+			}
+		}
+		return 0
+	})
+
+	return nil
+}
+
+// sortKey the value a document sorts by: arrays sort by their lowest element ascending and their
+// highest descending, and missing fields sort as null
+func sortKey(document bson.D, path []string, ascending bool) any {
+	values := resolve(document, path)
+	if len(values) == 0 {
+		return nil
+	}
+
+	var key any
+	found := false
+	for _, value := range candidates(values) {
+		if _, ok := value.(bson.A); ok {
+			continue
+		}
+		if !found {
+			key, found = value, true
+			continue
+		}
+
+		c := compareValues(value, key)
+		if (ascending && c < 0) || (!ascending && c > 0) {
+			key = value
+		}
+	}
+	return key
+}
+
+// projection a parsed projection specification
+type projection struct {
+	include bool
+	// excludeId drops the _id from an inclusion projection
+	excludeId bool
+	fields    *projectionNode
+	computed  bson.D
+}
+
+type projectionNode struct {
+	children map[string]*projectionNode
+}
+
+func (n *projectionNode) add(path []string) {
+	node := n
+	for _, segment := range path {
+		if node.children == nil {
+			node.children = make(map[string]*projectionNode)
+		}
+
+		child, ok := node.children[segment]
+		if !ok {
+			child = &projectionNode{}
+			node.children[segment] = child
+		}
+		node = child
+	}
+}
+
+// parseProjection parse a find projection, or a $project stage when computed fields are allowed
+func parseProjection(spec bson.D, allowComputed bool) (*projection, error) {
+	p := &projection{fields: &projectionNode{}}
+
+	modeSet := false
+	for _, element := range spec {
+		if element.Key == "_id" {
+			if isFlag(element.Value) {
+				p.excludeId = !truthy(element.Value)
+				continue
+			}
+		}
+
+		if !isFlag(element.Value) {
+			if !allowComputed {
+				return nil, fmt.Errorf("unsupported projection for %s: %v", element.Key, element.Value)
+			}
+			p.computed = append(p.computed, element)
+			if modeSet && !p.include {
+				return nil, fmt.Errorf("cannot add computed field %s to an exclusion projection", element.Key)
+			}
+			p.include, modeSet = true, true
+			continue
+		}
+
+		include := truthy(element.Value)
+		if modeSet && include != p.include {
+			return nil, fmt.Errorf("cannot mix inclusion and exclusion of %s in a projection", element.Key)
+		}
+		p.include, modeSet = include, true
+		p.fields.add(splitPath(element.Key))
+	}
+
+	if !modeSet {
+		// only the _id was given: {"_id": 0} excludes it, {"_id": 1} keeps only it
+		p.include = !p.excludeId
+	}
+	if !p.include && p.excludeId {
+		p.fields.add([]string{"_id"})
+	}
+
+	return p, nil
+}
+
+func isFlag(value any) bool {
+	switch value.(type) {
+	case bool, int32, int64, float64:
+		return true
+	}
+	return false
+}
+
+func (p *projection) apply(document bson.D) (bson.D, error) {
+	if !p.include {
+		return excludeFields(document, p.fields), nil
+	}
+
+	result := includeFields(document, p.fields)
+	if !p.excludeId {
+		if id, ok := get(document, "_id"); ok {
+			if _, projected := get(result, "_id"); !projected {
+				result = append(bson.D{{Key: "_id", Value: id}}, result...)
+			}
+		}
+	} else {
+		result = slices.DeleteFunc(result, func(element bson.E) bool { return element.Key == "_id" })
+	}
+
+	for _, element := range p.computed {
+		value, err := evaluate(document, element.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		var set any
+		if set, err = setPath(result, splitPath(element.Key), value); err != nil {
+			return nil, err
+		}
+		result = set.(bson.D)
+	}
+
+	return result, nil
+}
+
+func includeFields(document bson.D, node *projectionNode) bson.D {
+	result := bson.D{}
+	for _, element := range document {
+		child, ok := node.children[element.Key]
+		if !ok {
+			continue
+		}
+
+		if len(child.children) == 0 {
+			result = append(result, element)
+			continue
+		}
+
+		switch value := element.Value.(type) {
+		case bson.D:
+			result = append(result, bson.E{Key: element.Key, Value: includeFields(value, child)})
+		case bson.A:
+			projected := bson.A{}
+			for _, item := range value {
+				if embedded, ok := item.(bson.D); ok {
+					projected = append(projected, includeFields(embedded, child))
+				}
+			}
+			result = append(result, bson.E{Key: element.Key, Value: projected})
+		}
+	}
+	return result
+}
+
+func excludeFields(document bson.D, node *projectionNode) bson.D {
+	result := bson.D{}
+	for _, element := range document {
+		child, ok := node.children[element.Key]
+		if !ok {
+			result = append(result, element)
+			continue
+		}
+
+		if len(child.children) == 0 {
+			continue
+		}
+
+		switch value := element.Value.(type) {
+		case bson.D:
+			result = append(result, bson.E{Key: element.Key, Value: excludeFields(value, child)})
+		case bson.A:
+			projected := bson.A{}
+			for _, item := range value {
+				if embedded, ok := item.(bson.D); ok {
+					item = excludeFields(embedded, child)
+				}
+				projected = append(projected, item)
+			}
+			result = append(result, bson.E{Key: element.Key, Value: projected})
+		default:
+			result = append(result, element)
+		}
+	}
+	return result
+}
+
+// evaluate an aggregation expression: "$path" field references, documents and arrays of
+// expressions, {"$literal": v}, and literal values
+func evaluate(document bson.D, expression any) (any, error) {
+	switch value := expression.(type) {
+	case string:
+		if strings.HasPrefix(value, "$") {
+			result, _ := fieldPath(document, splitPath(value[1:]))
+			return result, nil
+		}
+		return value, nil
+	case bson.A:
+		result := bson.A{}
+		for _, item := range value {
+			evaluated, err := evaluate(document, item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, evaluated)
+		}
+		return result, nil
+	case bson.D:
+		if len(value) == 1 && value[0].Key == "$literal" {
+			return value[0].Value, nil
+		}
+
+		result := bson.D{}
+		for _, element := range value {
+			if strings.HasPrefix(element.Key, "$") {
+				return nil, fmt.Errorf("unsupported expression operator %s", element.Key)
+			}
+
+			evaluated, err := evaluate(document, element.Value)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, bson.E{Key: element.Key, Value: evaluated})
+		}
+		return result, nil
+	}
+
+	return expression, nil
+}
+
+// fieldPath the value of a field path expression. Paths through arrays collect one value per
+// element, as "$items.sku" does in an aggregation.
+func fieldPath(value any, path []string) (any, bool) {
+	if len(path) == 0 {
+		return value, true
+	}
+
+	switch current := value.(type) {
+	case bson.D:
+		child, ok := get(current, path[0])
+		if !ok {
+			return nil, false
+		}
+		return fieldPath(child, path[1:])
+	case bson.A:
+		result := bson.A{}
+		for _, element := range current {
+			if _, ok := element.(bson.D); !ok {
+				continue
+			}
+			if item, ok := fieldPath(element, path); ok {
+				result = append(result, item)
+			}
+		}
+		return result, true
+	}
+
+	return nil, false
+}
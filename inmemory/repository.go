@@ -0,0 +1,865 @@
+package inmemory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/hub1989/mongo-data/v4/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Repository an in-memory implementation of repository.Repository for unit tests that should not
+// need a database. Entities are stored as BSON documents, so they round trip through the same
+// encoding as with MongoDB, and filters, updates, sorts, projections and aggregations are evaluated
+// against those documents with MongoDB semantics for the common operators. Unsupported operators
+// and stages return an error rather than being ignored.
+// Only the _id is unique; there are no secondary indexes.
+type Repository[T base_entity.Entity] struct {
+	mu sync.RWMutex
+	// documents in insertion order, which is the natural order of unsorted finds
+	documents []bson.D
+}
+
+var _ repository.Repository[base_entity.Entity] = (*Repository[base_entity.Entity])(nil)
+
+// New create an empty in-memory repository
+func New[T base_entity.Entity]() *Repository[T] {
+	return &Repository[T]{}
+}
+
+// Save create a new document. It is the same as Insert.
+func (r *Repository[T]) Save(ctx context.Context, entity T) (*T, error) {
+	return r.Insert(ctx, entity)
+}
+
+// Insert create a new document.
+// Returns repository.ErrDuplicateKey when a document with the same _id exists.
+func (r *Repository[T]) Insert(_ context.Context, entity T) (*T, error) {
+	document, err := toDocument(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.insert(document, 0); err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
+// SaveMany create many documents in order, stopping at the first failure as an ordered
+// InsertMany does
+func (r *Repository[T]) SaveMany(_ context.Context, entities []interface{}) ([]string, error) {
+	documents := make([]bson.D, 0, len(entities))
+	for _, entity := range entities {
+		document, err := toDocument(entity)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []string
+	for i, document := range documents {
+		id, err := r.insert(document, i)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id.(bson.ObjectID).Hex())
+	}
+
+	return ids, nil
+}
+
+// insert store a document, generating its _id when it has none. The caller holds the lock.
+func (r *Repository[T]) insert(document bson.D, index int) (any, error) {
+	id, ok := get(document, "_id")
+	if !ok {
+		id = bson.NewObjectID()
+		document = append(bson.D{{Key: "_id", Value: id}}, document...)
+	}
+
+	if r.indexOfId(id) >= 0 {
+		return nil, duplicateKeyError(index, id)
+	}
+
+	r.documents = append(r.documents, document)
+	return id, nil
+}
+
+// Replace an existing document as a whole, removing fields the entity no longer has.
+// Returns repository.ErrNotFound when no document has the entity's _id.
+func (r *Repository[T]) Replace(_ context.Context, entity T) (*T, error) {
+	document, err := toDocument(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.indexOfId(entity.GetId())
+	if i < 0 {
+		return nil, fmt.Errorf("could not replace entity with ID %s: %w", entity.GetId().Hex(), repository.ErrNotFound)
+	}
+
+	r.documents[i] = document
+	return &entity, nil
+}
+
+// Upsert replace the document with the entity's _id, inserting it when absent
+func (r *Repository[T]) Upsert(_ context.Context, entity T) (*base_entity.UpsertResult[T], error) {
+	document, err := toDocument(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i := r.indexOfId(entity.GetId()); i >= 0 {
+		r.documents[i] = document
+		return &base_entity.UpsertResult[T]{Entity: &entity}, nil
+	}
+
+	if _, err := r.insert(document, 0); err != nil {
+		return nil, err
+	}
+	return &base_entity.UpsertResult[T]{Entity: &entity, Inserted: true}, nil
+}
+
+// UpsertByFilter replace the document matching a natural key filter, inserting it when absent.
+// As with MongoDB, the entity's own _id is not written: a matched document keeps its _id and an
+// inserted one gets a new _id, or the one the filter pins with an equality.
+func (r *Repository[T]) UpsertByFilter(_ context.Context, filter bson.M, entity T) (*base_entity.UpsertResult[T], error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := toDocument(entity)
+	if err != nil {
+		return nil, err
+	}
+	fields = withoutKey(fields, "_id")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.indexOfMatch(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored bson.D
+	inserted := i < 0
+	if inserted {
+		seed, err := upsertSeed(query)
+		if err != nil {
+			return nil, err
+		}
+
+		id, ok := get(seed, "_id")
+		if !ok {
+			id = bson.NewObjectID()
+		}
+
+		stored = append(bson.D{{Key: "_id", Value: id}}, fields...)
+		if _, err := r.insert(stored, 0); err != nil {
+			return nil, err
+		}
+	} else {
+		id, _ := get(r.documents[i], "_id")
+		stored = append(bson.D{{Key: "_id", Value: id}}, fields...)
+		r.documents[i] = stored
+	}
+
+	result, err := decode[T](stored)
+	if err != nil {
+		return nil, err
+	}
+	return &base_entity.UpsertResult[T]{Entity: result, Inserted: inserted}, nil
+}
+
+// Update an existing document by setting every field of the entity.
+// Returns repository.ErrNotFound when no document has the entity's _id.
+func (r *Repository[T]) Update(_ context.Context, entity T) (*T, error) {
+	fields, err := toDocument(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.indexOfId(entity.GetId())
+	if i < 0 {
+		return nil, fmt.Errorf("could not update entity with ID %s: %w", entity.GetId().Hex(), repository.ErrNotFound)
+	}
+
+	updated, err := applyUpdate(r.documents[i], bson.D{{Key: "$set", Value: fields}}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	r.documents[i] = updated
+	return &entity, nil
+}
+
+// UpdateOne apply an update to the first document matching filter.
+// Fails when nothing matched or the update changed nothing, as MongoRepository.UpdateOne does.
+func (r *Repository[T]) UpdateOne(_ context.Context, filter bson.M, update bson.M) error {
+	query, err := toDocument(filter)
+	if err != nil {
+		return err
+	}
+
+	modifiers, err := toDocument(update)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.indexOfMatch(query)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return errors.New(fmt.Sprintf("could not update for filter: %v", update))
+	}
+
+	updated, err := applyUpdate(r.documents[i], modifiers, false)
+	if err != nil {
+		return err
+	}
+
+	if equalValues(updated, r.documents[i]) {
+		return errors.New(fmt.Sprintf("could not update for filter: %v", update))
+	}
+
+	r.documents[i] = updated
+	return nil
+}
+
+// UpdateMany many existing documents
+func (r *Repository[T]) UpdateMany(ctx context.Context, entities []T) ([]*T, error) {
+	var result []*T
+	for _, entity := range entities {
+		res, err := r.Update(ctx, entity)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, res)
+	}
+
+	return result, nil
+}
+
+// FindById find by _id
+func (r *Repository[T]) FindById(ctx context.Context, id bson.ObjectID, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	return r.FindEntityDocumentByFilter(ctx, bson.M{"_id": id}, opts...)
+}
+
+// Delete an existing document
+func (r *Repository[T]) Delete(ctx context.Context, id bson.ObjectID) error {
+	return r.DeleteMany(ctx, []bson.ObjectID{id})
+}
+
+// DeleteMany delete many existing documents; ids without a document are ignored
+func (r *Repository[T]) DeleteMany(_ context.Context, ids []bson.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.documents[:0]
+	for _, document := range r.documents {
+		id, _ := get(document, "_id")
+		if objectId, ok := id.(bson.ObjectID); ok && containsId(ids, objectId) {
+			continue
+		}
+		kept = append(kept, document)
+	}
+
+	clear(r.documents[len(kept):])
+	r.documents = kept
+	return nil
+}
+
+// FindByIds find a list of documents by ids
+func (r *Repository[T]) FindByIds(ctx context.Context, ids []bson.ObjectID, opts ...options.Lister[options.FindOptions]) ([]*T, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	filter := bson.M{
+		"_id": bson.M{
+			"$in": ids,
+		},
+	}
+
+	return r.FindEntityDocumentsByFilter(ctx, filter, opts...)
+}
+
+// FindEntityDocumentsByFilter find a list of documents by filter, applying the sort, skip, limit
+// and projection options
+func (r *Repository[T]) FindEntityDocumentsByFilter(_ context.Context, filter bson.M, opts ...options.Lister[options.FindOptions]) ([]*T, error) {
+	documents, err := r.find(filter, resolveOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []*T
+	for _, document := range documents {
+		entity, err := decode[T](document)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	}
+
+	return entities, nil
+}
+
+// FindEntityDocumentByFilter find 1 document by filter.
+// Returns repository.ErrNotFound when no document matches.
+func (r *Repository[T]) FindEntityDocumentByFilter(_ context.Context, filter bson.M, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	opt := resolveOptions(opts)
+
+	limit := int64(1)
+	documents, err := r.find(filter, &options.FindOptions{
+		Projection: opt.Projection,
+		Skip:       opt.Skip,
+		Sort:       opt.Sort,
+		Limit:      &limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(documents) == 0 {
+		return nil, notFound()
+	}
+	return decode[T](documents[0])
+}
+
+// CountDocumentsInCollected count every document
+func (r *Repository[T]) CountDocumentsInCollected(_ context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return int64(len(r.documents)), nil
+}
+
+// CountByFilter count the documents matching filter
+func (r *Repository[T]) CountByFilter(_ context.Context, filter bson.M) (int64, error) {
+	documents, err := r.find(filter, &options.FindOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(documents)), nil
+}
+
+// FindAllPageable page through every document newest _id first, exactly as
+// MongoRepository.FindAllPageable does
+func (r *Repository[T]) FindAllPageable(request base_entity.PageableDBRequest, ctx context.Context) (*base_entity.PageableDBResponse[T], error) {
+	noOfDocuments, err := r.CountDocumentsInCollected(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{}
+	if request.LastItemId != "" {
+		obj, err := bson.ObjectIDFromHex(request.LastItemId)
+		if err != nil {
+			return nil, err
+		}
+
+		filter = bson.M{
+			"_id": bson.M{
+				"$lt": obj,
+			},
+		}
+	}
+
+	findOptions := options.Find().
+		SetLimit(request.NumberPerPage).
+		SetSort(bson.M{"_id": -1})
+
+	if request.Projection != nil {
+		// the _id is needed for the next page's cursor
+		projection := bson.M{}
+		for key, value := range request.Projection {
+			if key != "_id" {
+				projection[key] = value
+			}
+		}
+		findOptions.SetProjection(projection)
+	}
+
+	documents, err := r.find(filter, resolveOptions([]options.Lister[options.FindOptions]{findOptions}))
+	if err != nil {
+		return nil, err
+	}
+
+	var response []T
+	for _, document := range documents {
+		entity, err := decode[T](document)
+		if err != nil {
+			return nil, err
+		}
+		response = append(response, *entity)
+	}
+
+	var lastItemId string
+	if len(response) > 0 {
+		lastItemId = response[len(response)-1].GetId().Hex()
+	}
+
+	return &base_entity.PageableDBResponse[T]{
+		Data:             response,
+		NumberPerPage:    request.NumberPerPage,
+		LastItemId:       lastItemId,
+		Total:            noOfDocuments,
+		NoOfItemsInBatch: int64(len(response)),
+	}, nil
+}
+
+// HandleResultCursorForObject decode every document of a cursor, such as one returned by Aggregate
+func (r *Repository[T]) HandleResultCursorForObject(records *mongo.Cursor, ctx context.Context, entities []T) ([]T, error) {
+	defer records.Close(ctx)
+	for records.Next(ctx) {
+		var entity T
+		if err := records.Decode(&entity); err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	}
+
+	return entities, records.Err()
+}
+
+// Aggregate run a pipeline of $match, $sort, $skip, $limit, $project, $count, $group and $unwind
+// stages and return the results as a cursor
+func (r *Repository[T]) Aggregate(_ context.Context, pipeline mongo.Pipeline) (*mongo.Cursor, error) {
+	documents, err := r.aggregate(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]any, len(documents))
+	for i, document := range documents {
+		results[i] = document
+	}
+
+	return mongo.NewCursorFromDocuments(results, nil, nil)
+}
+
+// AggregateForEntity run a pipeline and decode the results into entities
+func (r *Repository[T]) AggregateForEntity(_ context.Context, pipeline mongo.Pipeline) ([]*T, error) {
+	documents, err := r.aggregate(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []*T
+	for _, document := range documents {
+		entity, err := decode[T](document)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	}
+
+	return entities, nil
+}
+
+func (r *Repository[T]) aggregate(pipeline mongo.Pipeline) ([]bson.D, error) {
+	stages := make([]bson.D, 0, len(pipeline))
+	for _, stage := range pipeline {
+		normalized, err := toDocument(stage)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, normalized)
+	}
+
+	r.mu.RLock()
+	documents := append([]bson.D(nil), r.documents...)
+	r.mu.RUnlock()
+
+	return aggregate(documents, stages)
+}
+
+// FindOneAndUpdate atomically update one document and return it.
+// The document before the update is returned unless options.After is requested. Returns
+// repository.ErrNotFound when nothing matched, which includes an upsert that inserted while the
+// document before the update was requested.
+func (r *Repository[T]) FindOneAndUpdate(_ context.Context, filter bson.M, update bson.M, opts ...options.Lister[options.FindOneAndUpdateOptions]) (*T, error) {
+	opt := resolveOptions(opts)
+
+	modifiers, err := toDocument(update)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.findAndModify(filter, opt.Sort, opt.Projection, opt.Upsert, opt.ReturnDocument, func(document bson.D, inserting bool) (bson.D, error) {
+		return applyUpdate(document, modifiers, inserting)
+	})
+}
+
+// FindOneAndReplace atomically replace one document and return it.
+// Options work as for FindOneAndUpdate.
+func (r *Repository[T]) FindOneAndReplace(_ context.Context, filter bson.M, replacement T, opts ...options.Lister[options.FindOneAndReplaceOptions]) (*T, error) {
+	opt := resolveOptions(opts)
+
+	fields, err := toDocument(replacement)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.findAndModify(filter, opt.Sort, opt.Projection, opt.Upsert, opt.ReturnDocument, func(document bson.D, _ bool) (bson.D, error) {
+		current, hasId := get(document, "_id")
+		if id, ok := get(fields, "_id"); ok && hasId && !equalValues(id, current) {
+			return nil, fmt.Errorf("the (immutable) field '_id' was found to have been altered")
+		}
+		if !hasId {
+			return fields, nil
+		}
+		return append(bson.D{{Key: "_id", Value: current}}, withoutKey(fields, "_id")...), nil
+	})
+}
+
+// FindOneAndDelete atomically delete one document and return it.
+// Returns repository.ErrNotFound when nothing matched.
+func (r *Repository[T]) FindOneAndDelete(_ context.Context, filter bson.M, opts ...options.Lister[options.FindOneAndDeleteOptions]) (*T, error) {
+	opt := resolveOptions(opts)
+
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.indexOfFirst(query, opt.Sort)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 {
+		return nil, notFound()
+	}
+
+	deleted := r.documents[i]
+	r.documents = append(r.documents[:i:i], r.documents[i+1:]...)
+
+	return r.project(deleted, opt.Projection)
+}
+
+// findAndModify the shared core of FindOneAndUpdate and FindOneAndReplace
+func (r *Repository[T]) findAndModify(filter bson.M, sort any, projection any, upsert *bool, returnDocument *options.ReturnDocument, modify func(document bson.D, inserting bool) (bson.D, error)) (*T, error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	returnAfter := returnDocument != nil && *returnDocument == options.After
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.indexOfFirst(query, sort)
+	if err != nil {
+		return nil, err
+	}
+
+	if i < 0 {
+		if upsert == nil || !*upsert {
+			return nil, notFound()
+		}
+
+		seed, err := upsertSeed(query)
+		if err != nil {
+			return nil, err
+		}
+
+		document, err := modify(seed, true)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := r.insert(document, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if !returnAfter {
+			return nil, notFound()
+		}
+		return r.project(r.documents[r.indexOfId(id)], projection)
+	}
+
+	before := r.documents[i]
+	after, err := modify(before, false)
+	if err != nil {
+		return nil, err
+	}
+	r.documents[i] = after
+
+	if returnAfter {
+		return r.project(after, projection)
+	}
+	return r.project(before, projection)
+}
+
+func (r *Repository[T]) project(document bson.D, spec any) (*T, error) {
+	if spec != nil {
+		specification, err := toDocument(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := parseProjection(specification, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if document, err = p.apply(document); err != nil {
+			return nil, err
+		}
+	}
+
+	return decode[T](document)
+}
+
+// find the matching documents with the find options applied
+func (r *Repository[T]) find(filter bson.M, opt *options.FindOptions) ([]bson.D, error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	documents, err := filterDocuments(r.documents, query)
+	r.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Sort != nil {
+		sort, err := toDocument(opt.Sort)
+		if err != nil {
+			return nil, err
+		}
+		if err := sortDocuments(documents, sort); err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.Skip != nil && *opt.Skip > 0 {
+		documents = documents[min(int(*opt.Skip), len(documents)):]
+	}
+
+	if opt.Limit != nil && *opt.Limit != 0 {
+		// a negative limit asks for a single batch of that size
+		limit := *opt.Limit
+		if limit < 0 {
+			limit = -limit
+		}
+		documents = documents[:min(int(limit), len(documents))]
+	}
+
+	if opt.Projection != nil {
+		spec, err := toDocument(opt.Projection)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := parseProjection(spec, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, document := range documents {
+			if documents[i], err = p.apply(document); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return documents, nil
+}
+
+// indexOfId the position of the document with the _id, or -1. The caller holds the lock.
+func (r *Repository[T]) indexOfId(id any) int {
+	for i, document := range r.documents {
+		if current, ok := get(document, "_id"); ok && equalValues(current, id) {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexOfMatch the position of the first document in natural order matching query, or -1
+func (r *Repository[T]) indexOfMatch(query bson.D) (int, error) {
+	for i, document := range r.documents {
+		ok, err := matches(document, query)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// indexOfFirst the position of the first document matching query in sort order, or -1
+func (r *Repository[T]) indexOfFirst(query bson.D, sort any) (int, error) {
+	if sort == nil {
+		return r.indexOfMatch(query)
+	}
+
+	specification, err := toDocument(sort)
+	if err != nil {
+		return -1, err
+	}
+
+	documents, err := filterDocuments(r.documents, query)
+	if err != nil || len(documents) == 0 {
+		return -1, err
+	}
+
+	if err := sortDocuments(documents, specification); err != nil {
+		return -1, err
+	}
+
+	id, _ := get(documents[0], "_id")
+	return r.indexOfId(id), nil
+}
+
+// upsertSeed the document an upsert starts from: the equality conditions of the filter
+func upsertSeed(query bson.D) (bson.D, error) {
+	var seed any = bson.D{}
+	for _, element := range query {
+		switch {
+		case element.Key == "$and":
+			clauses, _ := element.Value.(bson.A)
+			for _, clause := range clauses {
+				filter, ok := clause.(bson.D)
+				if !ok {
+					continue
+				}
+
+				nested, err := upsertSeed(filter)
+				if err != nil {
+					return nil, err
+				}
+				for _, field := range nested {
+					var err error
+					if seed, err = setPath(seed, splitPath(field.Key), field.Value); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		case len(element.Key) > 0 && element.Key[0] == '$':
+			continue
+		}
+
+		value := element.Value
+		if expression, ok := operatorExpression(value); ok {
+			eq, ok := get(expression, "$eq")
+			if !ok {
+				continue
+			}
+			value = eq
+		}
+
+		var err error
+		if seed, err = setPath(seed, splitPath(element.Key), value); err != nil {
+			return nil, err
+		}
+	}
+
+	return seed.(bson.D), nil
+}
+
+func decode[T any](document bson.D) (*T, error) {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var entity T
+	if err := bson.Unmarshal(raw, &entity); err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
+func resolveOptions[O any](opts []options.Lister[O]) *O {
+	resolved := new(O)
+	for _, lister := range opts {
+		if lister == nil {
+			continue
+		}
+		for _, set := range lister.List() {
+			_ = set(resolved)
+		}
+	}
+	return resolved
+}
+
+func withoutKey(document bson.D, key string) bson.D {
+	result := bson.D{}
+	for _, element := range document {
+		if element.Key != key {
+			result = append(result, element)
+		}
+	}
+	return result
+}
+
+func containsId(ids []bson.ObjectID, id bson.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// notFound the error MongoRepository returns when nothing matched
+func notFound() error {
+	return fmt.Errorf("%w: %w", repository.ErrNotFound, mongo.ErrNoDocuments)
+}
+
+// duplicateKeyError the error MongoRepository returns for a duplicate _id, wrapping the same kind
+// of server error so mongo.IsDuplicateKeyError recognizes it
+func duplicateKeyError(index int, id any) error {
+	err := mongo.WriteException{
+		WriteErrors: mongo.WriteErrors{{
+			Index:   index,
+			Code:    11000,
+			Message: fmt.Sprintf("E11000 duplicate key error index: _id_ dup key: { _id: %v }", id),
+		}},
+	}
+	return fmt.Errorf("%w: %w", repository.ErrDuplicateKey, err)
+}
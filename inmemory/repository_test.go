@@ -0,0 +1,260 @@
+package inmemory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/hub1989/mongo-data/v4/inmemory"
+	"github.com/hub1989/mongo-data/v4/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type Person struct {
+	Id      bson.ObjectID `bson:"_id"`
+	Name    string        `bson:"name"`
+	Age     int           `bson:"age"`
+	Email   string        `bson:"email,omitempty"`
+	Tags    []string      `bson:"tags,omitempty"`
+	Address *Address      `bson:"address,omitempty"`
+}
+
+type Address struct {
+	City string `bson:"city"`
+}
+
+func (p Person) GetId() bson.ObjectID {
+	return p.Id
+}
+
+func (p Person) SetId(id bson.ObjectID) {
+	p.Id = id
+}
+
+var _ repository.Repository[Person] = (*inmemory.Repository[Person])(nil)
+
+type RepositoryTestSuite struct {
+	suite.Suite
+	*inmemory.Repository[Person]
+}
+
+func (s *RepositoryTestSuite) SetupTest() {
+	s.Repository = inmemory.New[Person]()
+}
+
+func (s *RepositoryTestSuite) seed(people ...Person) []Person {
+	for i := range people {
+		if people[i].Id.IsZero() {
+			people[i].Id = bson.NewObjectID()
+		}
+		_, err := s.Insert(context.Background(), people[i])
+		assert.Nil(s.T(), err)
+	}
+	return people
+}
+
+func (s *RepositoryTestSuite) TestInsertAndFindById() {
+	people := s.seed(Person{Name: "alice", Age: 30, Address: &Address{City: "Lagos"}})
+
+	found, err := s.FindById(context.Background(), people[0].Id)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), people[0], *found)
+}
+
+func (s *RepositoryTestSuite) TestInsertDuplicateKey() {
+	people := s.seed(Person{Name: "alice"})
+
+	_, err := s.Insert(context.Background(), people[0])
+	assert.True(s.T(), errors.Is(err, repository.ErrDuplicateKey))
+	assert.True(s.T(), mongo.IsDuplicateKeyError(err))
+}
+
+func (s *RepositoryTestSuite) TestFindByIdNotFound() {
+	_, err := s.FindById(context.Background(), bson.NewObjectID())
+	assert.True(s.T(), errors.Is(err, repository.ErrNotFound))
+	assert.True(s.T(), errors.Is(err, mongo.ErrNoDocuments))
+}
+
+func (s *RepositoryTestSuite) TestSaveMany() {
+	ids, err := s.SaveMany(context.Background(), []interface{}{
+		Person{Id: bson.NewObjectID(), Name: "a"},
+		Person{Id: bson.NewObjectID(), Name: "b"},
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), ids, 2)
+
+	count, _ := s.CountDocumentsInCollected(context.Background())
+	assert.Equal(s.T(), int64(2), count)
+}
+
+func (s *RepositoryTestSuite) TestFindWithOptions() {
+	s.seed(
+		Person{Name: "alice", Age: 30, Tags: []string{"admin"}},
+		Person{Name: "bob", Age: 25},
+		Person{Name: "carol", Age: 35, Tags: []string{"staff", "admin"}},
+		Person{Name: "dave", Age: 40},
+	)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "age", Value: -1}}).
+		SetSkip(1).
+		SetLimit(2).
+		SetProjection(bson.M{"name": 1})
+
+	found, err := s.FindEntityDocumentsByFilter(context.Background(), bson.M{"age": bson.M{"$gte": 25}}, opts)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), found, 2)
+	assert.Equal(s.T(), "carol", found[0].Name)
+	assert.Equal(s.T(), 0, found[0].Age)
+	assert.Equal(s.T(), "alice", found[1].Name)
+
+	admins, err := s.FindEntityDocumentsByFilter(context.Background(), bson.M{
+		"tags": "admin",
+		"$or":  bson.A{bson.M{"name": bson.M{"$regex": "^C", "$options": "i"}}, bson.M{"age": bson.M{"$lt": 31}}},
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), admins, 2)
+}
+
+func (s *RepositoryTestSuite) TestUpdate() {
+	people := s.seed(Person{Name: "alice", Age: 30})
+
+	people[0].Age = 31
+	_, err := s.Update(context.Background(), people[0])
+	assert.Nil(s.T(), err)
+
+	found, _ := s.FindById(context.Background(), people[0].Id)
+	assert.Equal(s.T(), 31, found.Age)
+
+	_, err = s.Update(context.Background(), Person{Id: bson.NewObjectID()})
+	assert.True(s.T(), errors.Is(err, repository.ErrNotFound))
+}
+
+func (s *RepositoryTestSuite) TestUpdateOne() {
+	people := s.seed(Person{Name: "alice", Age: 30})
+
+	err := s.UpdateOne(context.Background(), bson.M{"name": "alice"}, bson.M{"$inc": bson.M{"age": 1}})
+	assert.Nil(s.T(), err)
+
+	found, _ := s.FindById(context.Background(), people[0].Id)
+	assert.Equal(s.T(), 31, found.Age)
+
+	err = s.UpdateOne(context.Background(), bson.M{"name": "alice"}, bson.M{"$set": bson.M{"age": 31}})
+	assert.NotNil(s.T(), err)
+
+	err = s.UpdateOne(context.Background(), bson.M{"name": "bob"}, bson.M{"$set": bson.M{"age": 1}})
+	assert.NotNil(s.T(), err)
+}
+
+func (s *RepositoryTestSuite) TestReplaceAndUpsert() {
+	people := s.seed(Person{Name: "alice", Age: 30, Email: "a@example.com"})
+
+	_, err := s.Replace(context.Background(), Person{Id: people[0].Id, Name: "alice"})
+	assert.Nil(s.T(), err)
+
+	found, _ := s.FindById(context.Background(), people[0].Id)
+	assert.Empty(s.T(), found.Email)
+
+	result, err := s.Upsert(context.Background(), Person{Id: bson.NewObjectID(), Name: "bob"})
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), result.Inserted)
+
+	byFilter, err := s.UpsertByFilter(context.Background(), bson.M{"name": "carol"}, Person{Name: "carol", Age: 20})
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), byFilter.Inserted)
+	assert.False(s.T(), byFilter.Entity.Id.IsZero())
+
+	again, err := s.UpsertByFilter(context.Background(), bson.M{"name": "carol"}, Person{Name: "carol", Age: 21})
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), again.Inserted)
+	assert.Equal(s.T(), byFilter.Entity.Id, again.Entity.Id)
+	assert.Equal(s.T(), 21, again.Entity.Age)
+}
+
+func (s *RepositoryTestSuite) TestFindOneAndModify() {
+	s.seed(Person{Name: "alice", Age: 30}, Person{Name: "bob", Age: 40})
+
+	before, err := s.FindOneAndUpdate(context.Background(), bson.M{}, bson.M{"$inc": bson.M{"age": 1}},
+		options.FindOneAndUpdate().SetSort(bson.M{"age": -1}))
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "bob", before.Name)
+	assert.Equal(s.T(), 40, before.Age)
+
+	after, err := s.FindOneAndUpdate(context.Background(), bson.M{"name": "carol"}, bson.M{"$set": bson.M{"age": 5}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After))
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "carol", after.Name)
+	assert.Equal(s.T(), 5, after.Age)
+
+	deleted, err := s.FindOneAndDelete(context.Background(), bson.M{"name": "alice"})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "alice", deleted.Name)
+
+	_, err = s.FindOneAndDelete(context.Background(), bson.M{"name": "alice"})
+	assert.True(s.T(), errors.Is(err, repository.ErrNotFound))
+}
+
+func (s *RepositoryTestSuite) TestDeleteMany() {
+	people := s.seed(Person{Name: "a"}, Person{Name: "b"}, Person{Name: "c"})
+
+	assert.Nil(s.T(), s.DeleteMany(context.Background(), []bson.ObjectID{people[0].Id, people[2].Id}))
+	assert.Nil(s.T(), s.DeleteMany(context.Background(), nil))
+
+	found, err := s.FindByIds(context.Background(), []bson.ObjectID{people[0].Id, people[1].Id, people[2].Id})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), found, 1)
+	assert.Equal(s.T(), "b", found[0].Name)
+}
+
+func (s *RepositoryTestSuite) TestFindAllPageable() {
+	s.seed(Person{Name: "a"}, Person{Name: "b"}, Person{Name: "c"})
+
+	first, err := s.FindAllPageable(base_entity.PageableDBRequest{NumberPerPage: 2}, context.Background())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(3), first.Total)
+	assert.Equal(s.T(), int64(2), first.NoOfItemsInBatch)
+	assert.Equal(s.T(), "c", first.Data[0].Name)
+
+	second, err := s.FindAllPageable(base_entity.PageableDBRequest{NumberPerPage: 2, LastItemId: first.LastItemId}, context.Background())
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), second.Data, 1)
+	assert.Equal(s.T(), "a", second.Data[0].Name)
+
+	_, err = s.FindAllPageable(base_entity.PageableDBRequest{NumberPerPage: 2, LastItemId: "invalid"}, context.Background())
+	assert.NotNil(s.T(), err)
+}
+
+func (s *RepositoryTestSuite) TestAggregate() {
+	s.seed(Person{Name: "a", Age: 30}, Person{Name: "b", Age: 30}, Person{Name: "c", Age: 40})
+
+	cursor, err := s.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$age"}, {Key: "count", Value: bson.M{"$sum": 1}}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	assert.Nil(s.T(), err)
+
+	var results []struct {
+		Age   int `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	assert.Nil(s.T(), cursor.All(context.Background(), &results))
+	assert.Len(s.T(), results, 2)
+	assert.Equal(s.T(), 2, results[0].Count)
+
+	entities, err := s.AggregateForEntity(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"age": 30}}},
+		{{Key: "$sort", Value: bson.M{"name": -1}}},
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), entities, 2)
+	assert.Equal(s.T(), "b", entities[0].Name)
+}
+
+func TestRepositoryTestSuite(t *testing.T) {
+	suite.Run(t, new(RepositoryTestSuite))
+}
@@ -0,0 +1,318 @@
+package inmemory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// applyUpdate apply an update document of operators to a copy of document. inserting enables
+// $setOnInsert, for documents created by an upsert.
+func applyUpdate(document bson.D, update bson.D, inserting bool) (bson.D, error) {
+	if len(update) == 0 {
+		return nil, fmt.Errorf("update document must not be empty")
+	}
+
+	var result any = cloneDocument(document)
+	for _, operation := range update {
+		if !strings.HasPrefix(operation.Key, "$") {
+			return nil, fmt.Errorf("update document must contain key beginning with '$'")
+		}
+
+		fields, ok := operation.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("modifiers for %s must be an object", operation.Key)
+		}
+
+		for _, field := range fields {
+			if field.Key == "_id" && operation.Key != "$setOnInsert" {
+				if current, _ := get(document, "_id"); operation.Key != "$set" || !equalValues(current, field.Value) {
+					return nil, fmt.Errorf("performing an update on the path '_id' would modify the immutable field '_id'")
+				}
+			}
+			if strings.Contains(field.Key, "$") {
+				return nil, fmt.Errorf("positional path %q is not supported", field.Key)
+			}
+
+			var err error
+			if result, err = applyOperator(result, operation.Key, splitPath(field.Key), field.Value, inserting); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", operation.Key, field.Key, err)
+			}
+		}
+	}
+
+	return result.(bson.D), nil
+}
+
+func applyOperator(document any, operator string, path []string, argument any, inserting bool) (any, error) {
+	current, exists := getPath(document, path)
+
+	switch operator {
+	case "$set":
+		return setPath(document, path, argument)
+	case "$setOnInsert":
+		if !inserting {
+			return document, nil
+		}
+		return setPath(document, path, argument)
+	case "$unset":
+		return unsetPath(document, path), nil
+	case "$currentDate":
+		return setPath(document, path, bson.NewDateTimeFromTime(time.Now()))
+	case "$inc", "$mul":
+		if _, ok := asFloat(argument); !ok {
+			return nil, fmt.Errorf("cannot %s with non-numeric argument", operator)
+		}
+		if !exists {
+			if operator == "$mul" {
+				argument = multiply(zeroOf(argument), argument)
+			}
+			return setPath(document, path, argument)
+		}
+		if _, ok := asFloat(current); !ok {
+			return nil, fmt.Errorf("cannot apply %s to a value of non-numeric type", operator)
+		}
+		if operator == "$inc" {
+			return setPath(document, path, add(current, argument))
+		}
+		return setPath(document, path, multiply(current, argument))
+	case "$min", "$max":
+		c := compareValues(argument, current)
+		if !exists || (operator == "$min" && c < 0) || (operator == "$max" && c > 0) {
+			return setPath(document, path, argument)
+		}
+		return document, nil
+	case "$push", "$addToSet":
+		array, err := arrayAt(current, exists, operator)
+		if err != nil {
+			return nil, err
+		}
+
+		values := bson.A{argument}
+		if modifiers, ok := argument.(bson.D); ok && len(modifiers) > 0 && modifiers[0].Key == "$each" {
+			if values, ok = modifiers[0].Value.(bson.A); !ok {
+				return nil, fmt.Errorf("$each needs an array")
+			}
+		}
+
+		for _, value := range values {
+			if operator == "$addToSet" && containsValue(array, value) {
+				continue
+			}
+			array = append(array, value)
+		}
+		return setPath(document, path, array)
+	case "$pull":
+		if !exists {
+			return document, nil
+		}
+		array, err := arrayAt(current, exists, operator)
+		if err != nil {
+			return nil, err
+		}
+
+		kept := bson.A{}
+		for _, element := range array {
+			var remove bool
+			if condition, ok := argument.(bson.D); ok {
+				if remove, err = matchElementCondition(element, condition); err != nil {
+					return nil, err
+				}
+			} else {
+				remove = equalValues(element, argument)
+			}
+			if !remove {
+				kept = append(kept, element)
+			}
+		}
+		return setPath(document, path, kept)
+	}
+
+	return nil, fmt.Errorf("unsupported update operator %s", operator)
+}
+
+func arrayAt(value any, exists bool, operator string) (bson.A, error) {
+	if !exists {
+		return bson.A{}, nil
+	}
+
+	array, ok := value.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("cannot apply %s to a non-array field", operator)
+	}
+	return append(bson.A{}, array...), nil
+}
+
+func containsValue(array bson.A, value any) bool {
+	for _, element := range array {
+		if equalValues(element, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// add sum two numbers keeping the widest of their types, as the server does
+func add(a, b any) any {
+	if x, ok := asInt(a); ok {
+		if y, ok := asInt(b); ok {
+			return narrowInt(x+y, a, b)
+		}
+	}
+	x, _ := asFloat(a)
+	y, _ := asFloat(b)
+	return x + y
+}
+
+func multiply(a, b any) any {
+	if x, ok := asInt(a); ok {
+		if y, ok := asInt(b); ok {
+			return narrowInt(x*y, a, b)
+		}
+	}
+	x, _ := asFloat(a)
+	y, _ := asFloat(b)
+	return x * y
+}
+
+func narrowInt(result int64, a, b any) any {
+	_, wideA := a.(int64)
+	_, wideB := b.(int64)
+	if !wideA && !wideB && result == int64(int32(result)) {
+		return int32(result)
+	}
+	return result
+}
+
+func zeroOf(value any) any {
+	switch value.(type) {
+	case int32:
+		return int32(0)
+	case int64:
+		return int64(0)
+	}
+	return float64(0)
+}
+
+// getPath the value at an exact path, with numeric segments indexing arrays
+func getPath(value any, path []string) (any, bool) {
+	for _, segment := range path {
+		switch current := value.(type) {
+		case bson.D:
+			child, ok := get(current, segment)
+			if !ok {
+				return nil, false
+			}
+			value = child
+		case bson.A:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil, false
+			}
+			value = current[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setPath set the value at path in a copy of container, creating embedded documents on the way
+func setPath(container any, path []string, value any) (any, error) {
+	segment := path[0]
+
+	switch current := container.(type) {
+	case bson.D:
+		result := append(bson.D{}, current...)
+		for i, element := range result {
+			if element.Key != segment {
+				continue
+			}
+
+			if len(path) == 1 {
+				result[i].Value = value
+				return result, nil
+			}
+
+			child, err := setPath(element.Value, path[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			result[i].Value = child
+			return result, nil
+		}
+
+		if len(path) == 1 {
+			return append(result, bson.E{Key: segment, Value: value}), nil
+		}
+
+		child, err := setPath(bson.D{}, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return append(result, bson.E{Key: segment, Value: child}), nil
+	case bson.A:
+		index, err := strconv.Atoi(segment)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("cannot create field %q in an array", segment)
+		}
+
+		result := append(bson.A{}, current...)
+		for len(result) <= index {
+			result = append(result, nil)
+		}
+
+		if len(path) == 1 {
+			result[index] = value
+			return result, nil
+		}
+
+		child := result[index]
+		if child == nil {
+			child = bson.D{}
+		}
+		if result[index], err = setPath(child, path[1:], value); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("cannot create field %q in element %v", segment, container)
+}
+
+// unsetPath remove the value at path from a copy of container. Array elements become null.
+func unsetPath(container any, path []string) any {
+	segment := path[0]
+
+	switch current := container.(type) {
+	case bson.D:
+		result := bson.D{}
+		for _, element := range current {
+			switch {
+			case element.Key != segment:
+				result = append(result, element)
+			case len(path) > 1:
+				result = append(result, bson.E{Key: element.Key, Value: unsetPath(element.Value, path[1:])})
+			}
+		}
+		return result
+	case bson.A:
+		index, err := strconv.Atoi(segment)
+		if err != nil || index < 0 || index >= len(current) {
+			return current
+		}
+
+		result := append(bson.A{}, current...)
+		if len(path) == 1 {
+			result[index] = nil
+		} else {
+			result[index] = unsetPath(result[index], path[1:])
+		}
+		return result
+	}
+
+	return container
+}
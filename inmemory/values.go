@@ -0,0 +1,302 @@
+package inmemory
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// toDocument convert a document-like value (struct, map, bson.M, bson.D, bson.Raw) into a bson.D.
+// The round trip through BSON normalizes every nested value to the types the driver decodes into:
+// bson.D for documents, bson.A for arrays, bson.DateTime for times and so on.
+func toDocument(value any) (bson.D, error) {
+	if value == nil {
+		return bson.D{}, nil
+	}
+
+	raw, ok := value.(bson.Raw)
+	if !ok {
+		var err error
+		if raw, err = bson.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	var document bson.D
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	if document == nil {
+		document = bson.D{}
+	}
+	return document, nil
+}
+
+// normalizeValue normalize a single value by wrapping it in a document
+func normalizeValue(value any) (any, error) {
+	document, err := toDocument(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return nil, err
+	}
+	return document[0].Value, nil
+}
+
+func cloneDocument(document bson.D) bson.D {
+	clone, err := toDocument(document)
+	if err != nil {
+		// a document that was normalized once always marshals again
+		panic(err)
+	}
+	return clone
+}
+
+func get(document bson.D, key string) (any, bool) {
+	for _, element := range document {
+		if element.Key == key {
+			return element.Value, true
+		}
+	}
+	return nil, false
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// resolve every value a dotted path reaches. Arrays fan out: "items.sku" yields the sku of every
+// element, and numeric segments index into them, as in MongoDB queries.
+func resolve(value any, segments []string) []any {
+	if len(segments) == 0 {
+		return []any{value}
+	}
+
+	switch current := value.(type) {
+	case bson.D:
+		child, ok := get(current, segments[0])
+		if !ok {
+			return nil
+		}
+		return resolve(child, segments[1:])
+	case bson.A:
+		var values []any
+		if index, err := strconv.Atoi(segments[0]); err == nil && index >= 0 && index < len(current) {
+			values = append(values, resolve(current[index], segments[1:])...)
+		}
+		for _, element := range current {
+			if document, ok := element.(bson.D); ok {
+				values = append(values, resolve(document, segments)...)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// typeOrder the BSON comparison order of a value's type
+func typeOrder(value any) int {
+	switch value.(type) {
+	case bson.MinKey:
+		return 0
+	case nil, bson.Null, bson.Undefined:
+		return 1
+	case int32, int64, float64, bson.Decimal128:
+		return 2
+	case string, bson.Symbol:
+		return 3
+	case bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case bson.Binary:
+		return 6
+	case bson.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case bson.DateTime:
+		return 9
+	case bson.Timestamp:
+		return 10
+	case bson.Regex:
+		return 11
+	case bson.MaxKey:
+		return 13
+	default:
+		return 12
+	}
+}
+
+func asFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bson.Decimal128:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func asInt(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// compareValues order two values the way MongoDB sorts them
+func compareValues(a, b any) int {
+	if orderA, orderB := typeOrder(a), typeOrder(b); orderA != orderB {
+		return compareInts(int64(orderA), int64(orderB))
+	}
+
+	switch x := a.(type) {
+	case int32, int64, float64, bson.Decimal128:
+		if ia, ok := asInt(a); ok {
+			if ib, ok := asInt(b); ok {
+				return compareInts(ia, ib)
+			}
+		}
+		fa, _ := asFloat(a)
+		fb, _ := asFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, fmt.Sprint(b))
+	case bson.Symbol:
+		return strings.Compare(string(x), fmt.Sprint(b))
+	case bson.ObjectID:
+		y := b.(bson.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case bson.DateTime:
+		return compareInts(int64(x), int64(b.(bson.DateTime)))
+	case bson.Timestamp:
+		y := b.(bson.Timestamp)
+		if x.T != y.T {
+			return compareInts(int64(x.T), int64(y.T))
+		}
+		return compareInts(int64(x.I), int64(y.I))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+			if c := compareValues(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(x)), int64(len(y)))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(x)), int64(len(y)))
+	case bson.Binary:
+		return bytes.Compare(x.Data, b.(bson.Binary).Data)
+	}
+
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// equalValues report whether two normalized values are equal, treating all numbers alike
+func equalValues(a, b any) bool {
+	if typeOrder(a) != typeOrder(b) {
+		return false
+	}
+
+	switch x := a.(type) {
+	case nil, bson.Null, bson.Undefined:
+		return true
+	case int32, int64, float64, bson.Decimal128:
+		return compareValues(a, b) == 0
+	case bson.D:
+		y := b.(bson.D)
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if x[i].Key != y[i].Key || !equalValues(x[i].Value, y[i].Value) {
+				return false
+			}
+		}
+		return true
+	case bson.A:
+		y := b.(bson.A)
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equalValues(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func isNull(value any) bool {
+	switch value.(type) {
+	case nil, bson.Null, bson.Undefined:
+		return true
+	}
+	return false
+}
+
+func truthy(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case nil, bson.Null, bson.Undefined:
+		return false
+	}
+
+	if f, ok := asFloat(value); ok {
+		return f != 0
+	}
+	return true
+}
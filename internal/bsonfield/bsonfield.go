@@ -0,0 +1,141 @@
+// Package bsonfield resolves the BSON field names of Go struct types the same way the
+// driver's default struct codec does.
+package bsonfield
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Field a struct field as seen by the BSON encoder
+type Field struct {
+	// Name the BSON key of the field
+	Name string
+	// Index the index sequence for reflect.Value.FieldByIndex
+	Index []int
+	// Type the Go type of the field
+	Type reflect.Type
+	// OmitEmpty whether the field carries the omitempty option
+	OmitEmpty bool
+	// StructField the reflected struct field, for reading other tags
+	StructField reflect.StructField
+}
+
+// Fields list the BSON-encoded fields of a struct type, flattening inline structs.
+// Pointers are dereferenced; non-struct types have no fields.
+func Fields(t reflect.Type) []Field {
+	t = Indirect(t)
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		tag, ok := lookupTag(sf)
+		if ok && tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+
+		inline := hasOption(options, "inline")
+		if inline && Indirect(sf.Type).Kind() == reflect.Struct {
+			for _, nested := range Fields(sf.Type) {
+				nested.Index = append([]int{i}, nested.Index...)
+				fields = append(fields, nested)
+			}
+			continue
+		}
+
+		fields = append(fields, Field{
+			Name:        name,
+			Index:       []int{i},
+			Type:        sf.Type,
+			OmitEmpty:   hasOption(options, "omitempty"),
+			StructField: sf,
+		})
+	}
+
+	return fields
+}
+
+// Lookup find the field with the given BSON key
+func Lookup(t reflect.Type, name string) (Field, bool) {
+	for _, field := range Fields(t) {
+		if field.Name == name {
+			return field, true
+		}
+	}
+
+	return Field{}, false
+}
+
+// Resolve walk a dotted BSON path through t and return the type found at the end.
+// Array elements are addressed with numeric segments; maps and interfaces accept any remainder.
+func Resolve(t reflect.Type, path string) (reflect.Type, bool) {
+	current := t
+	for _, segment := range strings.Split(path, ".") {
+		current = Indirect(current)
+
+		switch current.Kind() {
+		case reflect.Struct:
+			field, ok := Lookup(current, segment)
+			if !ok {
+				return nil, false
+			}
+			current = field.Type
+		case reflect.Slice, reflect.Array:
+			if _, err := strconv.Atoi(segment); err != nil {
+				return nil, false
+			}
+			current = current.Elem()
+		case reflect.Map:
+			current = current.Elem()
+		case reflect.Interface:
+			return current, true
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// Indirect dereference pointer types
+func Indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+func lookupTag(sf reflect.StructField) (string, bool) {
+	if tag, ok := sf.Tag.Lookup("bson"); ok {
+		return tag, true
+	}
+
+	// mirror the driver: a tag without any key is treated as the bson tag
+	if raw := string(sf.Tag); raw != "" && !strings.Contains(raw, ":") {
+		return raw, true
+	}
+
+	return "", false
+}
+
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+// Package validatetag parses `validate` struct tags, the shared source of the schema package's
+// $jsonSchema validators and the repository's client-side validation.
+//
+// A tag is a comma separated list of rules, in the style of go-playground/validator:
+//
+//	required        the field must be set: non-zero, or non-nil for pointers, slices and maps
+//	omitempty       skip the other rules when the field is empty
+//	min=n, max=n    bounds of a number, of the length of a string or of the items of a slice
+//	len=n           exact length of a string or item count of a slice
+//	gt, gte, lt, lte=n  exclusive or inclusive bounds, like min and max
+//	oneof=a b c     the value is one of the space separated options
+//	email, url, uuid    the string has this format
+//	pattern=re      the string matches the regular expression, which cannot contain a comma
+//	dive            apply the rules after dive to every item of a slice
+package validatetag
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Tag the struct tag key holding the rules
+const Tag = "validate"
+
+// Rule one rule of a tag
+type Rule struct {
+	Name  string
+	Param string
+}
+
+// Rules the parsed rules of a field
+type Rules struct {
+	Required  bool
+	OmitEmpty bool
+	// Constraints the rules besides required, omitempty and dive
+	Constraints []Rule
+	// Items the rules after dive, applying to slice items
+	Items *Rules
+}
+
+// Patterns the regular expressions of the format rules
+var Patterns = map[string]string{
+	"email": `^[^@\s]+@[^@\s]+\.[^@\s]+$`,
+	"url":   `^[A-Za-z][A-Za-z0-9+.-]*://[^\s]+$`,
+	"uuid":  `^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`,
+}
+
+// Parse read the rules of a tag, rejecting unknown rules and malformed parameters
+func Parse(tag string) (Rules, error) {
+	var rules Rules
+	if tag == "" || tag == "-" {
+		return rules, nil
+	}
+
+	tokens := strings.Split(tag, ",")
+	for i, token := range tokens {
+		name, param, _ := strings.Cut(strings.TrimSpace(token), "=")
+
+		switch name {
+		case "required":
+			rules.Required = true
+		case "omitempty":
+			rules.OmitEmpty = true
+		case "dive":
+			items, err := Parse(strings.Join(tokens[i+1:], ","))
+			if err != nil {
+				return rules, err
+			}
+			rules.Items = &items
+			return rules, nil
+		case "min", "max", "len", "gt", "gte", "lt", "lte":
+			if _, err := strconv.ParseFloat(param, 64); err != nil {
+				return rules, fmt.Errorf("rule %s: %q is not a number", name, param)
+			}
+			rules.Constraints = append(rules.Constraints, Rule{Name: name, Param: param})
+		case "oneof":
+			if param == "" {
+				return rules, fmt.Errorf("rule oneof: no options")
+			}
+			rules.Constraints = append(rules.Constraints, Rule{Name: name, Param: param})
+		case "email", "url", "uuid":
+			rules.Constraints = append(rules.Constraints, Rule{Name: name})
+		case "pattern":
+			if _, err := regexp.Compile(param); err != nil {
+				return rules, fmt.Errorf("rule pattern: %w", err)
+			}
+			rules.Constraints = append(rules.Constraints, Rule{Name: name, Param: param})
+		default:
+			return rules, fmt.Errorf("unknown rule %q", name)
+		}
+	}
+
+	return rules, nil
+}
+
+// Number the numeric parameter of a rule
+func (r Rule) Number() float64 {
+	n, _ := strconv.ParseFloat(r.Param, 64)
+	return n
+}
+
+// Options the options of a oneof rule
+func (r Rule) Options() []string {
+	return strings.Fields(r.Param)
+}
+
+// Pattern the regular expression a format or pattern rule requires
+func (r Rule) Pattern() string {
+	if r.Name == "pattern" {
+		return r.Param
+	}
+	return Patterns[r.Name]
+}
@@ -0,0 +1,141 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ErrLocked returned when another migrator holds the lock for longer than LockOptions.Wait
+var ErrLocked = errors.New("migrations are locked by another migrator")
+
+// lockId the _id of the lock document in the migrations collection
+const lockId = "lock"
+
+// LockOptions configures the lock held while migrating
+type LockOptions struct {
+	// Owner identifies this migrator in the lock document. Defaults to host name and process id.
+	Owner string
+	// TTL after which a lock whose owner died is taken over. Each applied or reverted migration
+	// extends it. Defaults to 15 minutes.
+	TTL time.Duration
+	// Wait how long to wait for another migrator to finish. Defaults to 1 minute.
+	Wait time.Duration
+}
+
+// lockDocument the lock as stored in the migrations collection
+type lockDocument struct {
+	Id        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	LockedAt  time.Time `bson:"locked_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// locked run fn while holding the lock
+func (m *Migrator) locked(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := m.acquire(ctx); err != nil {
+		return err
+	}
+
+	defer func() {
+		filter := bson.M{"_id": lockId, "owner": m.owner()}
+		if _, err := m.collection().DeleteOne(context.WithoutCancel(ctx), filter); err != nil {
+			log.WithError(err).Warn("could not release the migration lock")
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// acquire take the lock, waiting for another owner to release it or for it to expire
+func (m *Migrator) acquire(ctx context.Context) error {
+	deadline := time.Now().Add(m.lockWait())
+	for {
+		err := m.take(ctx)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		var holder lockDocument
+		_ = m.collection().FindOne(ctx, bson.M{"_id": lockId}).Decode(&holder)
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: held by %s since %s", ErrLocked, holder.Owner, holder.LockedAt.Format(time.RFC3339))
+		}
+
+		log.WithFields(log.Fields{
+			"owner": holder.Owner,
+		}).Info("waiting for the migration lock")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// take the lock when it is free, expired or already ours. A lock held by another owner makes the
+// upsert insert a second lock document, which fails with a duplicate key error.
+func (m *Migrator) take(ctx context.Context) error {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": lockId,
+		"$or": bson.A{
+			bson.M{"owner": m.owner()},
+			bson.M{"expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"owner":      m.owner(),
+		"locked_at":  now,
+		"expires_at": now.Add(m.lockTTL()),
+	}}
+
+	_, err := m.collection().UpdateOne(ctx, filter, update, options.UpdateOne().SetUpsert(true))
+	return err
+}
+
+// refreshLock extend the lock after a migration, so a long run does not lose it
+func (m *Migrator) refreshLock(ctx context.Context) error {
+	update := bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(m.lockTTL())}}
+
+	res, err := m.collection().UpdateOne(ctx, bson.M{"_id": lockId, "owner": m.owner()}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("%w: the lock expired and was taken over", ErrLocked)
+	}
+	return nil
+}
+
+func (m *Migrator) owner() string {
+	if m.Lock.Owner != "" {
+		return m.Lock.Owner
+	}
+
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+func (m *Migrator) lockTTL() time.Duration {
+	if m.Lock.TTL > 0 {
+		return m.Lock.TTL
+	}
+	return 15 * time.Minute
+}
+
+func (m *Migrator) lockWait() time.Duration {
+	if m.Lock.Wait > 0 {
+		return m.Lock.Wait
+	}
+	return time.Minute
+}
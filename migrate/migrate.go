@@ -0,0 +1,357 @@
+// Package migrate applies versioned migrations written as Go functions to a database, records
+// them in the schema_migrations collection and holds a lock while it works, so that deployments
+// starting at the same time apply each migration once.
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// DefaultCollection the collection recording applied migrations
+const DefaultCollection = "schema_migrations"
+
+// ErrNoDown returned when reverting a migration that has no Down function
+var ErrNoDown = errors.New("migration cannot be reverted")
+
+// Migration a versioned change to the database
+type Migration struct {
+	// Version orders the migrations; it must be positive and unique, e.g. 20240115093000
+	Version     int64
+	Description string
+	Up          func(ctx context.Context, database *mongo.Database) error
+	// Down reverts Up; leave it nil for a migration that cannot be reverted
+	Down func(ctx context.Context, database *mongo.Database) error
+	// Transactional run the function and its record in one transaction, which needs a replica set.
+	// Collections and indexes cannot always be created inside a transaction.
+	Transactional bool
+}
+
+var (
+	registeredMu sync.Mutex
+	registered   []Migration
+)
+
+// Register add a migration to those a Migrator created without migrations applies,
+// typically from an init function next to the migration
+func Register(migration Migration) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	registered = append(registered, migration)
+}
+
+// Registered the migrations added with Register
+func Registered() []Migration {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	return slices.Clone(registered)
+}
+
+// Migrator applies and reverts migrations on a database
+type Migrator struct {
+	Database *mongo.Database
+	// Collection records the applied migrations and holds the lock. Defaults to schema_migrations.
+	Collection string
+	// Lock configures the lock held while migrating
+	Lock LockOptions
+
+	migrations []Migration
+}
+
+// Record an applied migration as stored in the migrations collection
+type Record struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+	// Duration how long Up took
+	Duration time.Duration `bson:"duration"`
+}
+
+// MigrationStatus whether a migration is applied
+type MigrationStatus struct {
+	Version     int64
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+	// Registered false for an applied migration the Migrator does not know, e.g. one a newer
+	// release applied
+	Registered bool
+}
+
+// New create a migrator for the migrations, or for the registered ones when none are given
+func New(database *mongo.Database, migrations ...Migration) *Migrator {
+	if len(migrations) == 0 {
+		migrations = Registered()
+	}
+
+	sorted := slices.Clone(migrations)
+	slices.SortStableFunc(sorted, func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+
+	return &Migrator{Database: database, migrations: sorted}
+}
+
+// Migrations the migrations in version order
+func (m *Migrator) Migrations() []Migration {
+	return slices.Clone(m.migrations)
+}
+
+// Status every registered or applied migration in version order
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+
+	records, err := m.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Description: migration.Description, Registered: true}
+		if record, ok := records[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, record := range records {
+		if !slices.ContainsFunc(m.migrations, func(migration Migration) bool { return migration.Version == version }) {
+			statuses = append(statuses, MigrationStatus{
+				Version:     version,
+				Description: record.Description,
+				Applied:     true,
+				AppliedAt:   record.AppliedAt,
+			})
+		}
+	}
+
+	slices.SortFunc(statuses, func(a, b MigrationStatus) int { return cmp.Compare(a.Version, b.Version) })
+	return statuses, nil
+}
+
+// Version the highest applied version, 0 when none is applied
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	records, err := m.records(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int64
+	for applied := range records {
+		version = max(version, applied)
+	}
+	return version, nil
+}
+
+// Up apply every pending migration in version order and return the applied versions
+func (m *Migrator) Up(ctx context.Context) ([]int64, error) {
+	return m.To(ctx, m.latest())
+}
+
+// Down revert the n most recently versioned applied migrations and return the reverted versions
+func (m *Migrator) Down(ctx context.Context, n int) ([]int64, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+
+	var reverted []int64
+	err := m.locked(ctx, func(ctx context.Context) error {
+		records, err := m.records(ctx)
+		if err != nil {
+			return err
+		}
+
+		applied := m.applied(records)
+		for i := len(applied) - 1; i >= 0 && len(reverted) < n; i-- {
+			if err := m.revert(ctx, applied[i]); err != nil {
+				return err
+			}
+			reverted = append(reverted, applied[i].Version)
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// To migrate to version: apply the pending migrations up to and including it, and revert the
+// applied ones above it. It returns the versions applied or reverted.
+func (m *Migrator) To(ctx context.Context, version int64) ([]int64, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+
+	var changed []int64
+	err := m.locked(ctx, func(ctx context.Context) error {
+		records, err := m.records(ctx)
+		if err != nil {
+			return err
+		}
+
+		applied := m.applied(records)
+		for i := len(applied) - 1; i >= 0 && applied[i].Version > version; i-- {
+			if err := m.revert(ctx, applied[i]); err != nil {
+				return err
+			}
+			changed = append(changed, applied[i].Version)
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := records[migration.Version]; ok || migration.Version > version {
+				continue
+			}
+
+			if err := m.apply(ctx, migration); err != nil {
+				return err
+			}
+			changed = append(changed, migration.Version)
+		}
+		return nil
+	})
+
+	return changed, err
+}
+
+// apply run a migration's Up and record it
+func (m *Migrator) apply(ctx context.Context, migration Migration) error {
+	err := m.run(ctx, migration.Transactional, func(ctx context.Context) error {
+		started := time.Now()
+		if err := migration.Up(ctx, m.Database); err != nil {
+			return err
+		}
+
+		_, err := m.collection().InsertOne(ctx, Record{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now().UTC().Truncate(time.Millisecond),
+			Duration:    time.Since(started),
+		})
+		return err
+	})
+
+	if err != nil {
+		log.WithError(err).Error(fmt.Sprintf("could not apply migration %d", migration.Version))
+		return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Description, err)
+	}
+
+	log.WithFields(log.Fields{
+		"version": migration.Version,
+	}).Info(fmt.Sprintf("applied migration: %s", migration.Description))
+	return m.refreshLock(ctx)
+}
+
+// revert run a migration's Down and delete its record
+func (m *Migrator) revert(ctx context.Context, migration Migration) error {
+	if migration.Down == nil {
+		return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Description, ErrNoDown)
+	}
+
+	err := m.run(ctx, migration.Transactional, func(ctx context.Context) error {
+		if err := migration.Down(ctx, m.Database); err != nil {
+			return err
+		}
+
+		_, err := m.collection().DeleteOne(ctx, bson.M{"_id": migration.Version})
+		return err
+	})
+
+	if err != nil {
+		log.WithError(err).Error(fmt.Sprintf("could not revert migration %d", migration.Version))
+		return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Description, err)
+	}
+
+	log.WithFields(log.Fields{
+		"version": migration.Version,
+	}).Info(fmt.Sprintf("reverted migration: %s", migration.Description))
+	return m.refreshLock(ctx)
+}
+
+// run fn, inside a transaction when asked to
+func (m *Migrator) run(ctx context.Context, transactional bool, fn func(ctx context.Context) error) error {
+	if !transactional {
+		return fn(ctx)
+	}
+
+	session, err := m.Database.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// applied the registered migrations that are applied, in version order. Applied migrations the
+// Migrator does not know cannot be reverted and are left out.
+func (m *Migrator) applied(records map[int64]Record) []Migration {
+	var applied []Migration
+	for _, migration := range m.migrations {
+		if _, ok := records[migration.Version]; ok {
+			applied = append(applied, migration)
+		}
+	}
+	return applied
+}
+
+func (m *Migrator) records(ctx context.Context) (map[int64]Record, error) {
+	cursor, err := m.collection().Find(ctx, bson.M{"_id": bson.M{"$type": "long"}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("could not read applied migrations: %w", err)
+	}
+
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]Record, len(records))
+	for _, record := range records {
+		byVersion[record.Version] = record
+	}
+	return byVersion, nil
+}
+
+// check the migrations are well-formed
+func (m *Migrator) check() error {
+	for i, migration := range m.migrations {
+		switch {
+		case migration.Version <= 0:
+			return fmt.Errorf("migration %q: version must be positive", migration.Description)
+		case migration.Up == nil:
+			return fmt.Errorf("migration %d: Up is required", migration.Version)
+		case i > 0 && m.migrations[i-1].Version == migration.Version:
+			return fmt.Errorf("migration %d: duplicate version", migration.Version)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) collection() *mongo.Collection {
+	name := m.Collection
+	if name == "" {
+		name = DefaultCollection
+	}
+	return m.Database.Collection(name)
+}
@@ -0,0 +1,185 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// createCollection a migration creating a collection and dropping it again
+func createCollection(version int64, name string) Migration {
+	return Migration{
+		Version:     version,
+		Description: "create " + name,
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			return database.CreateCollection(ctx, name)
+		},
+		Down: func(ctx context.Context, database *mongo.Database) error {
+			return database.Collection(name).Drop(ctx)
+		},
+	}
+}
+
+func TestNew_SortsAndChecks(t *testing.T) {
+	m := New(nil, createCollection(3, "c"), createCollection(1, "a"), createCollection(2, "b"))
+
+	var versions []int64
+	for _, migration := range m.Migrations() {
+		versions = append(versions, migration.Version)
+	}
+	assert.Equal(t, []int64{1, 2, 3}, versions)
+	assert.Nil(t, m.check())
+
+	assert.ErrorContains(t, New(nil, createCollection(1, "a"), createCollection(1, "b")).check(), "duplicate version")
+	assert.ErrorContains(t, New(nil, createCollection(0, "a")).check(), "must be positive")
+	assert.ErrorContains(t, New(nil, Migration{Version: 1}).check(), "Up is required")
+}
+
+func TestRegister(t *testing.T) {
+	defer func() { registered = nil }()
+
+	Register(createCollection(2, "b"))
+	Register(createCollection(1, "a"))
+
+	assert.Len(t, Registered(), 2)
+	assert.Equal(t, int64(1), New(nil).Migrations()[0].Version)
+}
+
+func TestMigrator(t *testing.T) {
+	server := mongotest.Start(t)
+	database := server.Database(t)
+	ctx := context.Background()
+
+	irreversible := Migration{
+		Version:     4,
+		Description: "seed settings",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection("settings").InsertOne(ctx, bson.M{"theme": "dark"})
+			return err
+		},
+	}
+	m := New(database, createCollection(1, "users"), createCollection(2, "orders"), createCollection(3, "carts"), irreversible)
+
+	applied, err := m.To(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 2}, applied)
+
+	applied, err = m.Up(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{3, 4}, applied)
+
+	applied, err = m.Up(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, applied)
+
+	version, err := m.Version(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), version)
+
+	_, err = m.Down(ctx, 1)
+	assert.ErrorIs(t, err, ErrNoDown)
+
+	reverted, err := m.To(ctx, 1)
+	assert.ErrorIs(t, err, ErrNoDown)
+	assert.Empty(t, reverted)
+
+	m = New(database, createCollection(1, "users"), createCollection(2, "orders"), createCollection(3, "carts"))
+	reverted, err = m.Down(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{3, 2}, reverted)
+
+	names, err := database.ListCollectionNames(ctx, bson.M{"name": bson.M{"$in": bson.A{"users", "orders", "carts"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users"}, names)
+
+	statuses, err := m.Status(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 4)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[1].Applied)
+	assert.False(t, statuses[2].Applied)
+	assert.Equal(t, MigrationStatus{Version: 4, Description: "seed settings", Applied: true, AppliedAt: statuses[3].AppliedAt}, statuses[3])
+}
+
+func TestMigrator_FailedMigrationIsNotRecorded(t *testing.T) {
+	server := mongotest.Start(t)
+	database := server.Database(t)
+	ctx := context.Background()
+
+	failing := Migration{
+		Version: 2,
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			return errors.New("boom")
+		},
+	}
+	m := New(database, createCollection(1, "users"), failing)
+
+	applied, err := m.Up(ctx)
+	assert.ErrorContains(t, err, "boom")
+	assert.Equal(t, []int64{1}, applied)
+
+	version, err := m.Version(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), version)
+}
+
+func TestMigrator_Lock(t *testing.T) {
+	server := mongotest.Start(t)
+	database := server.Database(t)
+	ctx := context.Background()
+
+	held := New(database)
+	held.Lock = LockOptions{Owner: "deployer-1", TTL: time.Minute}
+	assert.Nil(t, held.take(ctx))
+
+	waiting := New(database, createCollection(1, "users"))
+	waiting.Lock = LockOptions{Owner: "deployer-2", Wait: time.Second}
+	_, err := waiting.Up(ctx)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.ErrorContains(t, err, "deployer-1")
+
+	// an expired lock is taken over
+	held.Lock.TTL = time.Millisecond
+	assert.Nil(t, held.take(ctx))
+	time.Sleep(10 * time.Millisecond)
+
+	applied, err := waiting.Up(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1}, applied)
+
+	count, err := database.Collection(DefaultCollection).CountDocuments(ctx, bson.M{"_id": lockId})
+	assert.Nil(t, err)
+	assert.Zero(t, count, "the lock is released")
+}
+
+func TestMigrator_Transactional(t *testing.T) {
+	server := mongotest.Start(t, &mongotest.Options{ReplicaSet: true})
+	database := server.Database(t)
+	ctx := context.Background()
+
+	assert.Nil(t, database.CreateCollection(ctx, "settings"))
+	_, err := database.Collection(DefaultCollection).InsertOne(ctx, bson.M{"_id": int64(2), "description": "taken"})
+	assert.Nil(t, err)
+
+	m := New(database, Migration{
+		Version:       2,
+		Transactional: true,
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			_, err := database.Collection("settings").InsertOne(ctx, bson.M{"theme": "dark"})
+			return err
+		},
+	})
+
+	// the record is already there, so To skips it; force the write to conflict instead
+	assert.Error(t, m.apply(ctx, m.Migrations()[0]))
+
+	count, err := database.Collection("settings").CountDocuments(ctx, bson.M{})
+	assert.Nil(t, err)
+	assert.Zero(t, count, "the aborted transaction rolled the migration back")
+}
@@ -0,0 +1,285 @@
+// Package mongotest starts MongoDB for tests: a standalone server or a single node replica set in
+// a container, or the server MONGO_URI points to when Docker is not available.
+package mongotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// URIEnv the environment variable holding the server to use when Docker is not available
+const URIEnv = "MONGO_URI"
+
+// Options configures the server Start provides
+type Options struct {
+	// Version the mongo image tag. Defaults to "6".
+	Version string
+	// ReplicaSet start a single node replica set, which transactions and change streams need
+	ReplicaSet bool
+	// Reuse share one named container per version and topology between every Start call and
+	// every test package of a go test run, instead of one container per call. Reused containers
+	// are removed by the testcontainers reaper when the run ends.
+	Reuse bool
+	// StartupTimeout bounds how long the server may take to accept writes. Defaults to 60s.
+	StartupTimeout time.Duration
+}
+
+// Server a running MongoDB server and a client connected to it
+type Server struct {
+	URI    string
+	Client *mongo.Client
+}
+
+var (
+	reusedMu  sync.Mutex
+	reusedURI = make(map[string]string)
+)
+
+// Start provide a MongoDB server for the test, skipping it when neither Docker nor MONGO_URI is
+// available. The client is disconnected, and a container that is not reused terminated, when the
+// test and its subtests finish.
+func Start(t testing.TB, opts ...*Options) *Server {
+	t.Helper()
+
+	opt := mergeOptions(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), opt.StartupTimeout)
+	defer cancel()
+
+	var uri string
+	if providerHealthy() {
+		var err error
+		if uri, err = startContainer(ctx, t, opt); err != nil {
+			t.Fatalf("could not start mongo container: %v", err)
+		}
+	} else if uri = os.Getenv(URIEnv); uri == "" {
+		t.Skipf("Docker is not available and %s is not set", URIEnv)
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("could not connect to %s: %v", uri, err)
+	}
+	t.Cleanup(func() {
+		_ = client.Disconnect(context.Background())
+	})
+
+	if err := waitWritable(ctx, client); err != nil {
+		t.Fatalf("mongo at %s did not become writable: %v", uri, err)
+	}
+
+	return &Server{URI: uri, Client: client}
+}
+
+// Database create a database only the calling test uses, dropped when the test finishes.
+// Fixtures are loaded into it before it is returned.
+func (s *Server) Database(t testing.TB, fixtures ...Fixtures) *mongo.Database {
+	t.Helper()
+
+	database := s.Client.Database(databaseName(t.Name()))
+	t.Cleanup(func() {
+		_ = database.Drop(context.Background())
+	})
+
+	for _, f := range fixtures {
+		if err := f.Load(context.Background(), database); err != nil {
+			t.Fatalf("could not load fixtures: %v", err)
+		}
+	}
+
+	return database
+}
+
+// Fixtures documents to insert, keyed by collection name
+type Fixtures map[string][]any
+
+// Load insert every fixture document into its collection
+func (f Fixtures) Load(ctx context.Context, database *mongo.Database) error {
+	for collection, documents := range f {
+		if len(documents) == 0 {
+			continue
+		}
+
+		if _, err := database.Collection(collection).InsertMany(ctx, documents); err != nil {
+			return fmt.Errorf("could not load %s fixtures: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+func startContainer(ctx context.Context, t testing.TB, opt Options) (string, error) {
+	name := containerName(opt)
+	if opt.Reuse {
+		reusedMu.Lock()
+		defer reusedMu.Unlock()
+
+		if uri, ok := reusedURI[name]; ok {
+			return uri, nil
+		}
+	}
+
+	req := testcontainers.ContainerRequest{
+		Image:        "mongo:" + opt.Version,
+		ExposedPorts: []string{"27017/tcp"},
+		WaitingFor:   wait.ForLog("Waiting for connections"),
+	}
+	if opt.ReplicaSet {
+		req.Cmd = []string{"--replSet", "rs0", "--bind_ip_all"}
+	}
+	if opt.Reuse {
+		req.Name = name
+	}
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+		Reuse:            opt.Reuse,
+	})
+	if !opt.Reuse {
+		testcontainers.CleanupContainer(t, container)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	endpoint, err := container.Endpoint(ctx, "")
+	if err != nil {
+		return "", err
+	}
+
+	// a direct connection lets the driver talk to the replica set member under its mapped port
+	uri := fmt.Sprintf("mongodb://%s/?directConnection=true", endpoint)
+	if opt.ReplicaSet {
+		if err := initiateReplicaSet(ctx, uri); err != nil {
+			return "", err
+		}
+	}
+
+	if opt.Reuse {
+		reusedURI[name] = uri
+	}
+	return uri, nil
+}
+
+// initiateReplicaSet configure the single member set, tolerating one initiated earlier
+func initiateReplicaSet(ctx context.Context, uri string) error {
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	initiate := bson.D{{Key: "replSetInitiate", Value: bson.M{
+		"_id":     "rs0",
+		"members": bson.A{bson.M{"_id": 0, "host": "localhost:27017"}},
+	}}}
+
+	err = client.Database("admin").RunCommand(ctx, initiate).Err()
+
+	var serverError mongo.ServerError
+	if errors.As(err, &serverError) && serverError.HasErrorCode(23) {
+		// AlreadyInitialized: a reused container
+		return nil
+	}
+	return err
+}
+
+// waitWritable wait until the server accepts writes, which a new replica set only does once
+// it has elected its primary
+func waitWritable(ctx context.Context, client *mongo.Client) error {
+	for {
+		var hello struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+		}
+
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err == nil && hello.IsWritablePrimary {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = errors.New("no writable primary")
+			}
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// providerHealthy report whether Docker can run containers. The testcontainers lookup panics
+// without a Docker host, so that counts as unhealthy as well.
+func providerHealthy() (healthy bool) {
+	defer func() {
+		if recover() != nil {
+			healthy = false
+		}
+	}()
+
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err != nil {
+		return false
+	}
+	return provider.Health(context.Background()) == nil
+}
+
+func containerName(opt Options) string {
+	topology := "standalone"
+	if opt.ReplicaSet {
+		topology = "rs"
+	}
+	return fmt.Sprintf("mongotest-%s-%s", strings.ReplaceAll(opt.Version, ".", "-"), topology)
+}
+
+var invalidDatabaseChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// databaseName a unique, valid database name derived from the test name. Names are limited to
+// 63 bytes, so the test name part is truncated.
+func databaseName(testName string) string {
+	suffix := bson.NewObjectID().Hex()
+	name := invalidDatabaseChars.ReplaceAllString(testName, "_")
+	if limit := 63 - len(suffix) - 1; len(name) > limit {
+		name = name[:limit]
+	}
+	return name + "_" + suffix
+}
+
+func mergeOptions(opts []*Options) Options {
+	merged := Options{
+		Version:        "6",
+		StartupTimeout: 60 * time.Second,
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Version != "" {
+			merged.Version = opt.Version
+		}
+		if opt.ReplicaSet {
+			merged.ReplicaSet = true
+		}
+		if opt.Reuse {
+			merged.Reuse = true
+		}
+		if opt.StartupTimeout > 0 {
+			merged.StartupTimeout = opt.StartupTimeout
+		}
+	}
+
+	return merged
+}
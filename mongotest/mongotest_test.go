@@ -0,0 +1,44 @@
+package mongotest
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestDatabaseName(t *testing.T) {
+	name := databaseName("TestSuite/TestCase with.dots")
+
+	assert.Regexp(t, regexp.MustCompile(`^TestSuite_TestCase_with_dots_[0-9a-f]{24}$`), name)
+	assert.NotEqual(t, name, databaseName("TestSuite/TestCase with.dots"))
+
+	long := databaseName(strings.Repeat("x", 100))
+	assert.Len(t, long, 63)
+}
+
+func TestMergeOptions(t *testing.T) {
+	merged := mergeOptions([]*Options{nil, {ReplicaSet: true}, {Version: "7.0"}})
+
+	assert.Equal(t, "7.0", merged.Version)
+	assert.True(t, merged.ReplicaSet)
+	assert.False(t, merged.Reuse)
+	assert.Equal(t, 60*time.Second, merged.StartupTimeout)
+	assert.Equal(t, "mongotest-7-0-rs", containerName(merged))
+}
+
+func TestStart_DatabaseWithFixtures(t *testing.T) {
+	server := Start(t)
+
+	database := server.Database(t, Fixtures{
+		"people": {bson.M{"name": "alice"}, bson.M{"name": "bob"}},
+	})
+
+	count, err := database.Collection("people").CountDocuments(context.Background(), bson.M{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+}
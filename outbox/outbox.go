@@ -0,0 +1,130 @@
+// Package outbox implements the transactional outbox pattern on top of MongoRepository:
+// events are written to an outbox collection in the same transaction as the entity, and a
+// Relay publishes them afterwards.
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/hub1989/mongo-data/v4/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Status the delivery state of an outbox event
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusSent    Status = "sent"
+	// StatusFailed events ran out of attempts and are no longer retried
+	StatusFailed Status = "failed"
+)
+
+// Event an outbox record
+type Event struct {
+	Id          bson.ObjectID `bson:"_id"`
+	Type        string        `bson:"type"`
+	AggregateId bson.ObjectID `bson:"aggregate_id,omitempty"`
+	Payload     bson.Raw      `bson:"payload"`
+	Status      Status        `bson:"status"`
+	Attempts    int           `bson:"attempts"`
+	LastError   string        `bson:"last_error,omitempty"`
+	CreatedAt   time.Time     `bson:"created_at"`
+	AvailableAt time.Time     `bson:"available_at"`
+	LeaseOwner  string        `bson:"lease_owner,omitempty"`
+	LeaseUntil  *time.Time    `bson:"lease_until,omitempty"`
+	SentAt      *time.Time    `bson:"sent_at,omitempty"`
+}
+
+// NewEvent create a pending event. payload must marshal to a BSON document, e.g. a struct or map.
+func NewEvent(eventType string, aggregateId bson.ObjectID, payload any) (Event, error) {
+	raw, err := bson.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+
+	now := time.Now().UTC()
+	return Event{
+		Id:          bson.NewObjectID(),
+		Type:        eventType,
+		AggregateId: aggregateId,
+		Payload:     raw,
+		Status:      StatusPending,
+		CreatedAt:   now,
+		AvailableAt: now,
+	}, nil
+}
+
+// Decode unmarshal the payload into v
+func (e Event) Decode(v any) error {
+	return bson.Unmarshal(e.Payload, v)
+}
+
+// Outbox the collection events are staged in
+type Outbox struct {
+	Collection *mongo.Collection
+}
+
+// Append insert events into the outbox. Call it with a transaction context so that the events
+// are only visible once the surrounding writes commit.
+func (o Outbox) Append(ctx context.Context, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	documents := make([]any, len(events))
+	for i, event := range events {
+		documents[i] = event
+	}
+
+	_, err := o.Collection.InsertMany(ctx, documents)
+	return err
+}
+
+// EnsureIndexes create the index the relay claims events with
+func (o Outbox) EnsureIndexes(ctx context.Context) error {
+	_, err := o.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "status", Value: 1}, {Key: "available_at", Value: 1}},
+		Options: options.Index().SetName("status_available_at"),
+	})
+	return err
+}
+
+// Save insert the entity and append its events in one transaction. Transient errors retry the
+// transaction; the entity's domain events are delivered once, after the commit.
+func Save[T base_entity.Entity](ctx context.Context, repo repository.MongoRepository[T], box Outbox, entity T, events ...Event) (*T, error) {
+	var saved *T
+	err := repository.WithTransaction(ctx, repo.Collection.Database().Client(), func(ctx context.Context) error {
+		var err error
+		if saved, err = repo.Save(ctx, entity); err != nil {
+			return err
+		}
+		return box.Append(ctx, events...)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// Update update the entity and append its events in one transaction, retried like Save
+func Update[T base_entity.Entity](ctx context.Context, repo repository.MongoRepository[T], box Outbox, entity T, events ...Event) (*T, error) {
+	var updated *T
+	err := repository.WithTransaction(ctx, repo.Collection.Database().Client(), func(ctx context.Context) error {
+		var err error
+		if updated, err = repo.Update(ctx, entity); err != nil {
+			return err
+		}
+		return box.Append(ctx, events...)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
@@ -0,0 +1,207 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	"github.com/hub1989/mongo-data/v4/repository"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type Order struct {
+	Id    bson.ObjectID `bson:"_id"`
+	Total int           `bson:"total"`
+}
+
+func (o Order) GetId() bson.ObjectID {
+	return o.Id
+}
+
+func (o Order) SetId(id bson.ObjectID) {
+	o.Id = id
+}
+
+type OrderPlaced struct {
+	Total int `bson:"total"`
+}
+
+type OutboxTestSuite struct {
+	suite.Suite
+	repository.MongoRepository[Order]
+	Outbox
+}
+
+// SetupSuite start a single node replica set, which transactions require
+func (s *OutboxTestSuite) SetupSuite() {
+	ctx := context.Background()
+	server := mongotest.Start(s.T(), &mongotest.Options{ReplicaSet: true})
+
+	db := server.Database(s.T())
+	// collections cannot be created implicitly inside transactions on older servers
+	s.Nil(db.CreateCollection(ctx, "orders"))
+	s.Nil(db.CreateCollection(ctx, "outbox"))
+
+	s.MongoRepository = repository.MongoRepository[Order]{Collection: db.Collection("orders")}
+	s.Outbox = Outbox{Collection: db.Collection("outbox")}
+	s.Nil(s.Outbox.EnsureIndexes(ctx))
+}
+
+func (s *OutboxTestSuite) TearDownTest() {
+	ctx := context.Background()
+	if _, err := s.MongoRepository.Collection.DeleteMany(ctx, bson.M{}); err != nil {
+		log.Error(err)
+	}
+	if _, err := s.Outbox.Collection.DeleteMany(ctx, bson.M{}); err != nil {
+		log.Error(err)
+	}
+}
+
+func (s *OutboxTestSuite) placeOrder(total int) (Order, Event) {
+	order := Order{Id: bson.NewObjectID(), Total: total}
+	event, err := NewEvent("order.placed", order.Id, OrderPlaced{Total: total})
+	s.Nil(err)
+	return order, event
+}
+
+func (s *OutboxTestSuite) outboxEvent(id bson.ObjectID) Event {
+	var event Event
+	s.Nil(s.Outbox.Collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&event))
+	return event
+}
+
+func (s *OutboxTestSuite) TestSave_WritesEntityAndEvents() {
+	ctx := context.Background()
+	order, event := s.placeOrder(10)
+
+	_, err := Save(ctx, s.MongoRepository, s.Outbox, order, event)
+	s.Nil(err)
+
+	_, err = s.MongoRepository.FindById(ctx, order.Id)
+	s.Nil(err)
+	s.Equal(StatusPending, s.outboxEvent(event.Id).Status)
+}
+
+func (s *OutboxTestSuite) TestSave_RollsBackEventsOnFailure() {
+	ctx := context.Background()
+	order, event := s.placeOrder(10)
+
+	_, err := s.MongoRepository.Save(ctx, order)
+	s.Nil(err)
+
+	_, err = Save(ctx, s.MongoRepository, s.Outbox, order, event)
+	s.ErrorIs(err, repository.ErrDuplicateKey)
+
+	count, err := s.Outbox.Collection.CountDocuments(ctx, bson.M{})
+	s.Nil(err)
+	s.Equal(int64(0), count)
+}
+
+func (s *OutboxTestSuite) TestUpdate_WritesEntityAndEvents() {
+	ctx := context.Background()
+	order, event := s.placeOrder(10)
+
+	_, err := s.MongoRepository.Save(ctx, order)
+	s.Nil(err)
+
+	order.Total = 20
+	_, err = Update(ctx, s.MongoRepository, s.Outbox, order, event)
+	s.Nil(err)
+
+	fromDB, err := s.MongoRepository.FindById(ctx, order.Id)
+	s.Nil(err)
+	s.Equal(20, fromDB.Total)
+	s.Equal(StatusPending, s.outboxEvent(event.Id).Status)
+}
+
+func (s *OutboxTestSuite) TestRelay_PublishesAndMarksSent() {
+	ctx := context.Background()
+	publisher := &MemoryPublisher{}
+	relay := &Relay{Outbox: s.Outbox, Publisher: publisher}
+
+	for total := 1; total <= 3; total++ {
+		order, event := s.placeOrder(total)
+		_, err := Save(ctx, s.MongoRepository, s.Outbox, order, event)
+		s.Nil(err)
+	}
+
+	claimed, err := relay.RunOnce(ctx)
+	s.Nil(err)
+	s.Equal(3, claimed)
+
+	published := publisher.Events()
+	s.Len(published, 3)
+
+	var payload OrderPlaced
+	s.Nil(published[0].Decode(&payload))
+	s.Equal(1, payload.Total)
+
+	sent := s.outboxEvent(published[0].Id)
+	s.Equal(StatusSent, sent.Status)
+	s.NotNil(sent.SentAt)
+	s.Empty(sent.LeaseOwner)
+
+	claimed, err = relay.RunOnce(ctx)
+	s.Nil(err)
+	s.Equal(0, claimed)
+}
+
+func (s *OutboxTestSuite) TestRelay_RetriesThenFails() {
+	ctx := context.Background()
+	publisher := &MemoryPublisher{Fail: func(Event) error { return errors.New("broker down") }}
+	relay := &Relay{
+		Outbox:      s.Outbox,
+		Publisher:   publisher,
+		MaxAttempts: 2,
+		Backoff:     func(int) time.Duration { return 0 },
+	}
+
+	order, event := s.placeOrder(5)
+	_, err := Save(ctx, s.MongoRepository, s.Outbox, order, event)
+	s.Nil(err)
+
+	_, err = relay.RunOnce(ctx)
+	s.Nil(err)
+
+	retrying := s.outboxEvent(event.Id)
+	s.Equal(StatusPending, retrying.Status)
+	s.Equal(1, retrying.Attempts)
+	s.Equal("broker down", retrying.LastError)
+
+	_, err = relay.RunOnce(ctx)
+	s.Nil(err)
+	s.Equal(StatusFailed, s.outboxEvent(event.Id).Status)
+}
+
+func (s *OutboxTestSuite) TestRelay_RespectsLeases() {
+	ctx := context.Background()
+	order, event := s.placeOrder(5)
+	_, err := Save(ctx, s.MongoRepository, s.Outbox, order, event)
+	s.Nil(err)
+
+	first := &Relay{Outbox: s.Outbox, Publisher: &MemoryPublisher{}, Owner: "first"}
+	first.applyDefaults()
+	claimed, err := first.claim(ctx)
+	s.Nil(err)
+	s.Equal(event.Id, claimed.Id)
+
+	second := &Relay{Outbox: s.Outbox, Publisher: &MemoryPublisher{}, Owner: "second"}
+	count, err := second.RunOnce(ctx)
+	s.Nil(err)
+	s.Equal(0, count)
+}
+
+func TestOutboxTestSuite(t *testing.T) {
+	suite.Run(t, new(OutboxTestSuite))
+}
+
+func TestDefaultBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, DefaultBackoff(1))
+	assert.Equal(t, 4*time.Second, DefaultBackoff(3))
+	assert.Equal(t, 5*time.Minute, DefaultBackoff(20))
+}
@@ -0,0 +1,38 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryPublisher records published events in memory, for tests.
+// Set Fail to make Publish return an error for chosen events.
+type MemoryPublisher struct {
+	Fail func(event Event) error
+
+	mu     sync.Mutex
+	events []Event
+}
+
+// Publish record the event unless Fail rejects it
+func (m *MemoryPublisher) Publish(_ context.Context, event Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Fail != nil {
+		if err := m.Fail(event); err != nil {
+			return err
+		}
+	}
+
+	m.events = append(m.events, event)
+	return nil
+}
+
+// Events the events published so far, in order
+func (m *MemoryPublisher) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Event(nil), m.events...)
+}
@@ -0,0 +1,178 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Publisher delivers outbox events to a broker or any other destination
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Relay claims pending events from an Outbox, publishes them and records the outcome.
+// Claims are leases: an event claimed by a relay that died becomes claimable again once its lease
+// expires, so several relays can run side by side. Delivery is at least once.
+type Relay struct {
+	Outbox    Outbox
+	Publisher Publisher
+	// Owner identifies this relay on its leases. Defaults to a random id.
+	Owner string
+	// BatchSize the maximum number of events claimed per round. Defaults to 100.
+	BatchSize int
+	// LeaseDuration how long a claim holds before other relays may take the event. Defaults to 30s.
+	LeaseDuration time.Duration
+	// PollInterval the wait between rounds that found nothing to publish. Defaults to 1s.
+	PollInterval time.Duration
+	// MaxAttempts after which an event is marked failed. Defaults to 10.
+	MaxAttempts int
+	// Backoff the delay before retrying an event that failed attempt times.
+	// Defaults to doubling from one second up to five minutes.
+	Backoff func(attempt int) time.Duration
+}
+
+// DefaultBackoff double the delay from one second, capped at five minutes
+func DefaultBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 10 {
+		return 5 * time.Minute
+	}
+
+	return min(time.Second<<(attempt-1), 5*time.Minute)
+}
+
+// Run publish events until the context is cancelled
+func (r *Relay) Run(ctx context.Context) error {
+	r.applyDefaults()
+
+	for {
+		claimed, err := r.RunOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.WithError(err).Error("outbox relay round failed")
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if claimed == 0 || err != nil {
+			select {
+			case <-time.After(r.PollInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// RunOnce claim and publish one batch of due events, returning how many were claimed
+func (r *Relay) RunOnce(ctx context.Context) (int, error) {
+	r.applyDefaults()
+
+	claimed := 0
+	for claimed < r.BatchSize {
+		event, err := r.claim(ctx)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return claimed, err
+		}
+
+		claimed++
+		if err := r.dispatch(ctx, event); err != nil {
+			return claimed, err
+		}
+	}
+
+	return claimed, nil
+}
+
+func (r *Relay) claim(ctx context.Context) (Event, error) {
+	now := time.Now().UTC()
+	leaseUntil := now.Add(r.LeaseDuration)
+
+	filter := bson.M{
+		"status":       StatusPending,
+		"available_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"lease_until": bson.M{"$exists": false}},
+			bson.M{"lease_until": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"lease_owner": r.Owner, "lease_until": leaseUntil}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "available_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var event Event
+	err := r.Outbox.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&event)
+	return event, err
+}
+
+// dispatch publish a claimed event and record the outcome.
+// Only errors from recording the outcome are returned; publish errors schedule a retry.
+func (r *Relay) dispatch(ctx context.Context, event Event) error {
+	ownLease := bson.M{"_id": event.Id, "lease_owner": r.Owner}
+	now := time.Now().UTC()
+
+	publishErr := r.Publisher.Publish(ctx, event)
+	if publishErr == nil {
+		_, err := r.Outbox.Collection.UpdateOne(ctx, ownLease, bson.M{
+			"$set":   bson.M{"status": StatusSent, "sent_at": now},
+			"$inc":   bson.M{"attempts": 1},
+			"$unset": bson.M{"lease_owner": "", "lease_until": "", "last_error": ""},
+		})
+		return err
+	}
+
+	attempts := event.Attempts + 1
+	set := bson.M{"last_error": publishErr.Error()}
+	if attempts >= r.MaxAttempts {
+		set["status"] = StatusFailed
+	} else {
+		set["available_at"] = now.Add(r.Backoff(attempts))
+	}
+
+	log.WithError(publishErr).WithFields(log.Fields{
+		"id":       event.Id,
+		"type":     event.Type,
+		"attempts": attempts,
+	}).Warn("could not publish outbox event")
+
+	_, err := r.Outbox.Collection.UpdateOne(ctx, ownLease, bson.M{
+		"$set":   set,
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"lease_owner": "", "lease_until": ""},
+	})
+	return err
+}
+
+func (r *Relay) applyDefaults() {
+	if r.Owner == "" {
+		r.Owner = bson.NewObjectID().Hex()
+	}
+	if r.BatchSize <= 0 {
+		r.BatchSize = 100
+	}
+	if r.LeaseDuration <= 0 {
+		r.LeaseDuration = 30 * time.Second
+	}
+	if r.PollInterval <= 0 {
+		r.PollInterval = time.Second
+	}
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = 10
+	}
+	if r.Backoff == nil {
+		r.Backoff = DefaultBackoff
+	}
+}
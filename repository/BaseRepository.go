@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -15,53 +16,108 @@ import (
 // Repository base repository interface
 type Repository[T base_entity.Entity] interface {
 	Save(ctx context.Context, entity T) (*T, error)
+	Insert(ctx context.Context, entity T) (*T, error)
+	Replace(ctx context.Context, entity T) (*T, error)
+	Upsert(ctx context.Context, entity T) (*base_entity.UpsertResult[T], error)
+	UpsertByFilter(ctx context.Context, filter bson.M, entity T) (*base_entity.UpsertResult[T], error)
 	SaveMany(ctx context.Context, entities []interface{}) ([]string, error)
 	Update(ctx context.Context, entity T) (*T, error)
 	UpdateOne(ctx context.Context, filter bson.M, update bson.M) error
 	UpdateMany(ctx context.Context, entities []T) ([]*T, error)
-	FindById(ctx context.Context, id bson.ObjectID) (*T, error)
+	FindById(ctx context.Context, id bson.ObjectID, opts ...options.Lister[options.FindOneOptions]) (*T, error)
 	Delete(ctx context.Context, id bson.ObjectID) error
 	DeleteMany(ctx context.Context, ids []bson.ObjectID) error
-	FindByIds(ctx context.Context, ids []bson.ObjectID) ([]*T, error)
+	FindByIds(ctx context.Context, ids []bson.ObjectID, opts ...options.Lister[options.FindOptions]) ([]*T, error)
 	FindEntityDocumentsByFilter(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOptions]) ([]*T, error)
-	FindEntityDocumentByFilter(ctx context.Context, filter bson.M) (*T, error)
+	FindEntityDocumentByFilter(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOneOptions]) (*T, error)
 	CountDocumentsInCollected(ctx context.Context) (int64, error)
 	FindAllPageable(request base_entity.PageableDBRequest, ctx context.Context) (*base_entity.PageableDBResponse[T], error)
 
 	HandleResultCursorForObject(records *mongo.Cursor, ctx context.Context, entities []T) ([]T, error)
 	Aggregate(ctx context.Context, pipeline mongo.Pipeline) (*mongo.Cursor, error)
 	AggregateForEntity(ctx context.Context, pipeline mongo.Pipeline) ([]*T, error)
+
+	FindOneAndUpdate(ctx context.Context, filter bson.M, update bson.M, opts ...options.Lister[options.FindOneAndUpdateOptions]) (*T, error)
+	FindOneAndReplace(ctx context.Context, filter bson.M, replacement T, opts ...options.Lister[options.FindOneAndReplaceOptions]) (*T, error)
+	FindOneAndDelete(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOneAndDeleteOptions]) (*T, error)
 }
 
 // MongoRepository Default implementation of the base repository interface
 // You can always supply a custom implementation to suite your needs.
 type MongoRepository[T base_entity.Entity] struct {
 	Collection *mongo.Collection
+
+	// ChangeTracker enables dirty-field tracking when set: Update then only writes the
+	// fields that differ from the last loaded or saved state of the entity.
+	ChangeTracker *ChangeTracker
+
+	// Events receives the domain events of entities implementing base_entity.EventSource
+	// after each successful write.
+	Events *EventDispatcher
+
+	// History records a versioned snapshot of every write in a companion collection when set.
+	History *History
+
+	// Validator checks entities before Save, Insert, Update, Replace, Upsert and SaveMany write
+	// them, e.g. a TagValidator. Entities implementing base_entity.Validatable are checked even
+	// without one. Invalid entities are not written; see SkipValidation to opt a write out.
+	Validator Validator
+
+	// Upgrader upgrades documents of base_entity.SchemaVersioned entities stored at an older
+	// schema version while they are read.
+	Upgrader *Upgrader
 }
 
-// Save create a new document
+// Save create a new document. It is the same as Insert.
 func (p MongoRepository[T]) Save(ctx context.Context, entity T) (*T, error) {
+	return p.Insert(ctx, entity)
+}
+
+// Insert create a new document.
+// Returns ErrDuplicateKey when a document with the same _id or unique key exists.
+func (p MongoRepository[T]) Insert(ctx context.Context, entity T) (*T, error) {
+	entity = withSchemaVersion(entity)
+	if err := p.validate(ctx, entity); err != nil {
+		return nil, err
+	}
+
 	res, err := p.Collection.InsertOne(ctx, entity)
 
 	if err != nil {
 		log.WithError(err).Error(fmt.Sprintf("could not save %s collected entity", p.Collection.Name()))
-		return nil, err
+		return nil, duplicateKey(err)
 	}
 
 	log.WithFields(log.Fields{
 		"id": res.InsertedID,
 	}).Info(fmt.Sprintf("saved %s entity", p.Collection.Name()))
 
+	if err := p.recordHistory(ctx, entity.GetId(), HistoryInsert, entity); err != nil {
+		return nil, err
+	}
+
+	p.snapshot(entity)
+	p.publishEvents(ctx, &entity)
 	return &entity, nil
 }
 
 // SaveMany create many documents
 func (p MongoRepository[T]) SaveMany(ctx context.Context, entities []interface{}) ([]string, error) {
+	versioned := make([]interface{}, len(entities))
+	for i, entity := range entities {
+		versioned[i] = withSchemaVersion(entity)
+	}
+	entities = versioned
+
+	if err := p.validateMany(ctx, entities); err != nil {
+		return nil, err
+	}
+
 	res, err := p.Collection.InsertMany(ctx, entities)
 
 	if err != nil {
 		log.WithError(err).Error(fmt.Sprintf("could not save %s collected entity", p.Collection.Name()))
-		return nil, err
+		return nil, duplicateKey(err)
 	}
 
 	var ids []string
@@ -76,26 +132,94 @@ func (p MongoRepository[T]) SaveMany(ctx context.Context, entities []interface{}
 	return ids, nil
 }
 
-// Update an existing document
+// Update an existing document.
+// Returns ErrNotFound when no document has the entity's _id; use Upsert to create it instead.
+// When change tracking is enabled and the entity has a snapshot, only the changed fields are
+// written and no write happens at all if nothing changed.
 func (p MongoRepository[T]) Update(ctx context.Context, entity T) (*T, error) {
+	entity = withSchemaVersion(entity)
+	if err := p.validate(ctx, entity); err != nil {
+		return nil, err
+	}
 
 	idFilter := bson.M{
 		"_id": entity.GetId(),
 	}
 
+	if p.ChangeTracker != nil {
+		changes, tracked, err := p.ChangeTracker.Changes(entity.GetId(), entity)
+		if err != nil {
+			return nil, err
+		}
+
+		if tracked {
+			return p.updateChanges(ctx, entity, idFilter, changes)
+		}
+	}
+
 	updateFilter := bson.M{
 		"$set": entity,
 	}
 
-	opts := options.UpdateOne().SetUpsert(true)
-	_, err := p.Collection.UpdateOne(ctx, idFilter, updateFilter, opts)
+	res, err := p.Collection.UpdateOne(ctx, idFilter, updateFilter)
 	if err != nil {
 		log.WithError(err).Error("could not update entity with ID: ", entity.GetId())
 		return nil, err
 	}
+
+	if res.MatchedCount == 0 {
+		return nil, fmt.Errorf("could not update entity with ID %s: %w", entity.GetId().Hex(), ErrNotFound)
+	}
+
+	if err := p.recordHistory(ctx, entity.GetId(), HistoryUpdate, entity); err != nil {
+		return nil, err
+	}
+
+	p.snapshot(entity)
+	p.publishEvents(ctx, &entity)
 	return &entity, nil
 }
 
+func (p MongoRepository[T]) updateChanges(ctx context.Context, entity T, idFilter bson.M, changes bson.D) (*T, error) {
+	if len(changes) == 0 {
+		log.WithFields(log.Fields{
+			"id": entity.GetId(),
+		}).Debug(fmt.Sprintf("no changes to write for %s entity", p.Collection.Name()))
+		p.publishEvents(ctx, &entity)
+		return &entity, nil
+	}
+
+	res, err := p.Collection.UpdateOne(ctx, idFilter, changes)
+	if err != nil {
+		log.WithError(err).Error("could not update entity with ID: ", entity.GetId())
+		return nil, err
+	}
+
+	if res.MatchedCount == 0 {
+		p.ChangeTracker.Forget(entity.GetId())
+		return nil, fmt.Errorf("could not update entity with ID %s: %w", entity.GetId().Hex(), ErrNotFound)
+	}
+
+	if err := p.recordHistory(ctx, entity.GetId(), HistoryUpdate, entity); err != nil {
+		return nil, err
+	}
+
+	p.snapshot(entity)
+	p.publishEvents(ctx, &entity)
+	return &entity, nil
+}
+
+// snapshot record the entity's current state when change tracking is enabled
+func (p MongoRepository[T]) snapshot(entity T) {
+	if p.ChangeTracker == nil {
+		return
+	}
+
+	if err := p.ChangeTracker.trackEntity(entity); err != nil {
+		log.WithError(err).Warn("could not snapshot entity with ID: ", entity.GetId())
+	}
+}
+
 // UpdateMany many existing documents
 func (p MongoRepository[T]) UpdateMany(ctx context.Context, entities []T) ([]*T, error) {
 	var result []*T
@@ -112,9 +236,9 @@ func (p MongoRepository[T]) UpdateMany(ctx context.Context, entities []T) ([]*T,
 }
 
 // FindById find by _id
-func (p MongoRepository[T]) FindById(ctx context.Context, id bson.ObjectID) (*T, error) {
+func (p MongoRepository[T]) FindById(ctx context.Context, id bson.ObjectID, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
 	filter := bson.M{"_id": id}
-	return p.FindEntityDocumentByFilter(ctx, filter)
+	return p.FindEntityDocumentByFilter(ctx, filter, opts...)
 }
 
 // Delete an existing document
@@ -124,40 +248,69 @@ func (p MongoRepository[T]) Delete(ctx context.Context, id bson.ObjectID) error
 
 // DeleteMany delete many existing documents
 func (p MongoRepository[T]) DeleteMany(ctx context.Context, ids []bson.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
 	filter := bson.M{
 		"_id": bson.M{
 			"$in": ids,
 		},
 	}
 
+	var existing []bson.ObjectID
+	if p.History != nil {
+		var err error
+		if existing, err = p.existingIds(ctx, filter); err != nil {
+			return err
+		}
+	}
+
 	res, err := p.Collection.DeleteMany(ctx, filter)
 
 	if err != nil {
 		return err
 	}
 
+	for _, id := range existing {
+		if err := p.recordHistory(ctx, id, HistoryDelete, nil); err != nil {
+			return err
+		}
+	}
+
 	log.WithFields(log.Fields{
 		"response": res.DeletedCount,
 	}).Info(fmt.Sprintf("deleted %s entities", p.Collection.Name()))
 
+	if p.ChangeTracker != nil {
+		p.ChangeTracker.Forget(ids...)
+	}
+
 	return nil
 }
 
 // FindByIds find a list of documents by ids
-func (p MongoRepository[T]) FindByIds(ctx context.Context, ids []bson.ObjectID) ([]*T, error) {
+func (p MongoRepository[T]) FindByIds(ctx context.Context, ids []bson.ObjectID, opts ...options.Lister[options.FindOptions]) ([]*T, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
 	filter := bson.M{
 		"_id": bson.M{
 			"$in": ids,
 		},
 	}
 
-	return p.FindEntityDocumentsByFilter(ctx, filter)
+	return p.FindEntityDocumentsByFilter(ctx, filter, opts...)
 }
 
-// FindEntityDocumentsByFilter find a list of documents by filter
+// FindEntityDocumentsByFilter find a list of documents by filter.
+// Projected documents decode with zero values for the fields left out and are not change tracked.
 func (p MongoRepository[T]) FindEntityDocumentsByFilter(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOptions]) ([]*T, error) {
 	var records []*T
 
+	p = p.forRead(ctx, projectsFind(opts))
+
 	reslts, err := p.Collection.Find(ctx, filter, opts...)
 	if err != nil {
 		return nil, err
@@ -170,6 +323,8 @@ func (p MongoRepository[T]) FindEntityDocumentsByFilter(ctx context.Context, fil
 func (p MongoRepository[T]) FindEntityDocumentsByFilterForObject(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOptions]) ([]T, error) {
 	var records []T
 
+	p = p.forRead(ctx, projectsFind(opts))
+
 	reslts, err := p.Collection.Find(ctx, filter, opts...)
 	if err != nil {
 		return nil, err
@@ -178,10 +333,18 @@ func (p MongoRepository[T]) FindEntityDocumentsByFilterForObject(ctx context.Con
 	return p.HandleResultCursorForObject(reslts, ctx, records)
 }
 
-func (p MongoRepository[T]) FindEntityDocumentByFilter(ctx context.Context, filter bson.M) (*T, error) {
+// FindEntityDocumentByFilter find 1 document by filter.
+// Returns ErrNotFound when no document matches.
+func (p MongoRepository[T]) FindEntityDocumentByFilter(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	p = p.forRead(ctx, projectsFindOne(opts))
+
 	var responseType T
-	err := p.Collection.FindOne(ctx, filter).Decode(&responseType)
+	raw, err := p.Collection.FindOne(ctx, filter, opts...).Raw()
 	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if err := p.decode(raw, &responseType); err != nil {
 		return nil, err
 	}
 	return &responseType, nil
@@ -221,6 +384,17 @@ func (p MongoRepository[T]) FindAllPageable(request base_entity.PageableDBReques
 		SetLimit(request.NumberPerPage).
 		SetSort(bson.M{"_id": -1})
 
+	if request.Projection != nil {
+		// the _id is needed for the next page's cursor
+		projection := bson.M{}
+		for key, value := range request.Projection {
+			if key != "_id" {
+				projection[key] = value
+			}
+		}
+		findOptions.SetProjection(projection)
+	}
+
 	response, err := p.FindEntityDocumentsByFilterForObject(ctx, filter, findOptions)
 	if err != nil {
 		return nil, err
@@ -245,7 +419,7 @@ func (p MongoRepository[T]) handleResultCursorForPointer(records *mongo.Cursor,
 	defer records.Close(ctx)
 	for records.Next(ctx) {
 		var entity T
-		if err := records.Decode(&entity); err != nil {
+		if err := p.decode(records.Current, &entity); err != nil {
 			log.WithError(err)
 			return nil, err
 		}
@@ -259,7 +433,7 @@ func (p MongoRepository[T]) HandleResultCursorForObject(records *mongo.Cursor, c
 	defer records.Close(ctx)
 	for records.Next(ctx) {
 		var entity T
-		if err := records.Decode(&entity); err != nil {
+		if err := p.decode(records.Current, &entity); err != nil {
 			log.WithError(err)
 			return nil, err
 		}
@@ -269,12 +443,46 @@ func (p MongoRepository[T]) HandleResultCursorForObject(records *mongo.Cursor, c
 	return entities, nil
 }
 
+// decode unmarshal a raw document into entity, upgrading it first when it is at an older schema
+// version. When change tracking is enabled the entity is snapshotted as T marshals it, so fields T
+// does not map are left alone; an upgraded document is snapshotted as stored, so the next Update
+// writes the upgrade.
+func (p MongoRepository[T]) decode(raw bson.Raw, entity *T) error {
+	upgraded, err := p.upgrade(raw)
+	if err != nil {
+		return err
+	}
+
+	if err := bson.Unmarshal(upgraded, entity); err != nil {
+		return err
+	}
+
+	if p.ChangeTracker == nil {
+		return nil
+	}
+
+	stored := *entity
+	if !bytes.Equal(upgraded, raw) {
+		stored = *new(T)
+		if err := bson.Unmarshal(raw, &stored); err != nil {
+			log.WithError(err).Warn("could not snapshot entity with ID: ", (*entity).GetId())
+			return nil
+		}
+	}
+	p.snapshot(stored)
+
+	return nil
+}
+
 func (p MongoRepository[T]) Aggregate(ctx context.Context, pipeline mongo.Pipeline) (*mongo.Cursor, error) {
 	return p.Collection.Aggregate(ctx, pipeline)
 }
 
 func (p MongoRepository[T]) AggregateForEntity(ctx context.Context, pipeline mongo.Pipeline) ([]*T, error) {
 	var records []*T
+	// aggregation stages may reshape the documents, so they are treated as projected
+	p = p.forRead(ctx, true)
+
 	data, err := p.Collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
@@ -287,7 +495,13 @@ func (p MongoRepository[T]) CountByFilter(ctx context.Context, filter bson.M) (i
 	return p.Collection.CountDocuments(ctx, filter)
 }
 
+// UpdateOne apply update to one document matching filter. The snapshot of the updated document is
+// dropped when change tracking is enabled, as the update cannot be followed.
 func (p MongoRepository[T]) UpdateOne(ctx context.Context, filter bson.M, update bson.M) error {
+	if p.History != nil || p.ChangeTracker != nil {
+		return p.updateOneReturning(ctx, filter, update)
+	}
+
 	res, err := p.Collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
@@ -299,3 +513,63 @@ func (p MongoRepository[T]) UpdateOne(ctx context.Context, filter bson.M, update
 
 	return nil
 }
+
+// updateOneReturning apply the update to one document matching filter with the same matched and
+// modified semantics as UpdateOne, then drop its snapshot and record it as read after the update.
+// The update and that read are two operations: outside a transaction, a concurrent write in between
+// shows up in the recorded state.
+func (p MongoRepository[T]) updateOneReturning(ctx context.Context, filter bson.M, update bson.M) error {
+	selected, err := p.Collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Raw()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errors.New(fmt.Sprintf("could not update for filter: %v", update))
+	}
+	if err != nil {
+		return err
+	}
+
+	id := selected.Lookup("_id")
+	res, err := p.Collection.UpdateOne(ctx, bson.M{"$and": bson.A{filter, bson.M{"_id": id}}}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 || res.ModifiedCount == 0 {
+		return errors.New(fmt.Sprintf("could not update for filter: %v", update))
+	}
+
+	objectId, ok := id.ObjectIDOK()
+	if !ok {
+		return nil
+	}
+
+	if p.ChangeTracker != nil {
+		p.ChangeTracker.Forget(objectId)
+	}
+	if p.History == nil {
+		return nil
+	}
+
+	after, err := p.Collection.FindOne(ctx, bson.M{"_id": objectId}).Raw()
+	if err != nil {
+		return notFound(err)
+	}
+	return p.recordHistory(ctx, objectId, HistoryUpdate, after)
+}
+
+// existingIds list the _ids of the documents matching filter
+func (p MongoRepository[T]) existingIds(ctx context.Context, filter bson.M) ([]bson.ObjectID, error) {
+	cursor, err := p.Collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var ids []bson.ObjectID
+	for cursor.Next(ctx) {
+		if id, ok := cursor.Current.Lookup("_id").ObjectIDOK(); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, cursor.Err()
+}
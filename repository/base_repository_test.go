@@ -2,17 +2,20 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hub1989/mongo-data/v4/base_entity"
-	"github.com/hub1989/mongo-data/v4/configuration"
+	"github.com/hub1989/mongo-data/v4/mongotest"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
-	"github.com/testcontainers/testcontainers-go"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type TestEntity struct {
@@ -28,6 +31,34 @@ func (t TestEntity) SetId(id bson.ObjectID) {
 	t.Id = id
 }
 
+type EntityRenamed struct {
+	Name string
+}
+
+// EventfulEntity records a domain event on every rename
+type EventfulEntity struct {
+	base_entity.Events `bson:"-"`
+	Id                 bson.ObjectID `bson:"_id"`
+	Name               string        `bson:"name"`
+}
+
+func (e EventfulEntity) GetId() bson.ObjectID {
+	return e.Id
+}
+
+func (e EventfulEntity) SetId(id bson.ObjectID) {
+	e.Id = id
+}
+
+func (e *EventfulEntity) Rename(name string) {
+	e.Name = name
+	e.Record(EntityRenamed{Name: name})
+}
+
+type TestEntityName struct {
+	Name string `bson:"name"`
+}
+
 type EntityTestSuite struct {
 	suite.Suite
 	MongoURI string
@@ -43,41 +74,10 @@ func (s *EntityTestSuite) TearDownTest() {
 }
 
 func (s *EntityTestSuite) SetupSuite() {
-	port := "27017/tcp"
-	ctx := context.Background()
-	req := testcontainers.ContainerRequest{
-		Image:        "mongo:6",
-		ExposedPorts: []string{port},
-		Env: map[string]string{
-			"MONGO_INITDB_ROOT_USERNAME": "test",
-			"MONGO_INITDB_ROOT_PASSWORD": "test",
-			"MONGO_INITDB_DATABASE":      "admin",
-		},
-	}
-
-	mongoC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-		ContainerRequest: req,
-		Started:          true,
-	})
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	endpoint, _ := mongoC.Endpoint(ctx, "")
-	s.MongoURI = fmt.Sprintf("mongodb://test:test@%s/", endpoint)
-
-	configService := configuration.DefaultDBConfigService{
-		MongoURI:     s.MongoURI,
-		DatabaseName: "test-db",
-	}
-	client, err := configService.ConnectDB()
-	if err != nil {
-		s.Error(err)
-		return
-	}
+	server := mongotest.Start(s.T())
+	s.MongoURI = server.URI
 
-	collection := client.Database(configService.DatabaseName).Collection("test_entities")
+	collection := server.Database(s.T()).Collection("test_entities")
 	s.Collection = collection
 	repository := MongoRepository[TestEntity]{Collection: collection}
 	s.MongoRepository = repository
@@ -465,6 +465,653 @@ func (s *EntityTestSuite) TestMongoRepository_UpdateOne_NoMatch() {
 	s.Contains(err.Error(), "could not update for filter")
 }
 
+func (s *EntityTestSuite) TestMongoRepository_Update_TrackedWritesOnlyChanges() {
+	ctx := context.Background()
+	tracked := MongoRepository[TestEntity]{Collection: s.Collection, ChangeTracker: NewChangeTracker()}
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "before"}
+	_, err := tracked.Save(ctx, entity)
+	s.Nil(err)
+
+	loaded, err := tracked.FindById(ctx, entity.Id)
+	s.Nil(err)
+
+	// a concurrent writer touches a field the entity does not know about
+	_, err = s.Collection.UpdateOne(ctx, bson.M{"_id": entity.Id}, bson.M{"$set": bson.M{"other": "kept"}})
+	s.Nil(err)
+
+	loaded.Name = "after"
+	_, err = tracked.Update(ctx, *loaded)
+	s.Nil(err)
+
+	var raw bson.M
+	err = s.Collection.FindOne(ctx, bson.M{"_id": entity.Id}).Decode(&raw)
+	s.Nil(err)
+	s.Equal("after", raw["name"])
+	s.Equal("kept", raw["other"])
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Update_TrackedSkipsUnchanged() {
+	ctx := context.Background()
+	tracked := MongoRepository[TestEntity]{Collection: s.Collection, ChangeTracker: NewChangeTracker()}
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "unchanged"}
+	_, err := tracked.Save(ctx, entity)
+	s.Nil(err)
+
+	loaded, err := tracked.FindById(ctx, entity.Id)
+	s.Nil(err)
+
+	_, err = s.Collection.UpdateOne(ctx, bson.M{"_id": entity.Id}, bson.M{"$set": bson.M{"name": "external"}})
+	s.Nil(err)
+
+	_, err = tracked.Update(ctx, *loaded)
+	s.Nil(err)
+
+	fromDB, err := s.MongoRepository.FindById(ctx, entity.Id)
+	s.Nil(err)
+	s.Equal("external", fromDB.Name)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Patch() {
+	ctx := context.Background()
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "before"}
+	_, err := s.MongoRepository.Save(ctx, entity)
+	s.Nil(err)
+
+	name := "patched"
+	patched, err := s.MongoRepository.Patch(ctx, entity.Id, struct {
+		Name *string `bson:"name"`
+	}{Name: &name})
+	s.Nil(err)
+	s.Equal("patched", patched.Name)
+
+	patched, err = s.MongoRepository.Patch(ctx, entity.Id, []byte(`{"name": "merged"}`))
+	s.Nil(err)
+	s.Equal("merged", patched.Name)
+
+	_, err = s.MongoRepository.Patch(ctx, entity.Id, map[string]any{"unknown": 1})
+	s.NotNil(err)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Patch_ValidatesAndRecords() {
+	ctx := context.Background()
+	database := s.Collection.Database()
+	defer database.Collection("validated_entities").Drop(ctx)
+	defer database.Collection("validated_entities_history").Drop(ctx)
+
+	repo := MongoRepository[validatedEntity]{
+		Collection: database.Collection("validated_entities"),
+		Validator:  &TagValidator{},
+		History:    NewHistory(database.Collection("validated_entities_history")),
+	}
+
+	entity := validEntity()
+	entity.Tags = []string{"ab"}
+	_, err := repo.Save(ctx, entity)
+	s.Nil(err)
+
+	var validationError *base_entity.ValidationError
+	_, err = repo.Patch(ctx, entity.Id, []byte(`{"email": "nope"}`))
+	s.True(errors.As(err, &validationError))
+
+	fromDB, err := repo.FindById(ctx, entity.Id)
+	s.Nil(err)
+	s.Equal("a@b.co", fromDB.Email)
+
+	patched, err := repo.Patch(ctx, entity.Id, []byte(`[{"op": "add", "path": "/tags/0", "value": "aa"}]`))
+	s.Nil(err)
+	s.Equal([]string{"aa", "ab"}, patched.Tags)
+
+	history, err := repo.FindHistory(ctx, entity.Id)
+	s.Nil(err)
+	s.Len(history, 2)
+
+	_, err = repo.Patch(ctx, bson.NewObjectID(), []byte(`{"email": "c@d.co"}`))
+	s.ErrorIs(err, ErrNotFound)
+}
+
+func (s *EntityTestSuite) TestFindProjected_UpgradesAndNotFound() {
+	ctx := context.Background()
+	collection := s.Collection.Database().Collection("versioned_entities")
+	defer collection.Drop(ctx)
+
+	repo := MongoRepository[versionedEntity]{Collection: collection, Upgrader: versionedUpgrader()}
+
+	id := bson.NewObjectID()
+	_, err := collection.InsertOne(ctx, bson.M{"_id": id, "name": "Ada Lovelace"})
+	s.Nil(err)
+
+	projected, err := FindOneProjected[versionedEntity, projectedName](repo, ctx, bson.M{"_id": id})
+	s.Nil(err)
+	s.Equal("Ada", projected.FirstName)
+
+	all, err := FindProjected[versionedEntity, projectedName](repo, ctx, bson.M{})
+	s.Nil(err)
+	s.Len(all, 1)
+	s.Equal("Ada", all[0].FirstName)
+
+	_, err = FindOneProjected[versionedEntity, projectedName](repo, ctx, bson.M{"_id": bson.NewObjectID()})
+	s.ErrorIs(err, ErrNotFound)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_FindOneAndUpdate() {
+	ctx := context.Background()
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "before"}
+	_, err := s.MongoRepository.Save(ctx, entity)
+	s.Nil(err)
+
+	filter := bson.M{"_id": entity.Id}
+	update := bson.M{"$set": bson.M{"name": "after"}}
+
+	previous, err := s.MongoRepository.FindOneAndUpdate(ctx, filter, update)
+	s.Nil(err)
+	s.Equal("before", previous.Name)
+
+	update = bson.M{"$set": bson.M{"name": "latest"}}
+	current, err := s.MongoRepository.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After))
+	s.Nil(err)
+	s.Equal("latest", current.Name)
+
+	_, err = s.MongoRepository.FindOneAndUpdate(ctx, bson.M{"_id": bson.NewObjectID()}, update)
+	s.ErrorIs(err, ErrNotFound)
+	s.ErrorIs(err, mongo.ErrNoDocuments)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_FindOneAndUpdate_Upsert() {
+	ctx := context.Background()
+
+	id := bson.NewObjectID()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	upserted, err := s.MongoRepository.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"name": "upserted"}}, opts)
+	s.Nil(err)
+	s.Equal(id, upserted.Id)
+	s.Equal("upserted", upserted.Name)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_FindOneAndReplace() {
+	ctx := context.Background()
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "original"}
+	_, err := s.MongoRepository.Save(ctx, entity)
+	s.Nil(err)
+
+	replacement := TestEntity{Id: entity.Id, Name: "replacement"}
+	replaced, err := s.MongoRepository.FindOneAndReplace(ctx, bson.M{"_id": entity.Id}, replacement, options.FindOneAndReplace().SetReturnDocument(options.After))
+	s.Nil(err)
+	s.Equal("replacement", replaced.Name)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_FindOneAndDelete() {
+	ctx := context.Background()
+
+	first := TestEntity{Id: bson.NewObjectID(), Name: "queued"}
+	second := TestEntity{Id: bson.NewObjectID(), Name: "queued"}
+	_, err := s.MongoRepository.SaveMany(ctx, []interface{}{first, second})
+	s.Nil(err)
+
+	deleted, err := s.MongoRepository.FindOneAndDelete(ctx, bson.M{"name": "queued"}, options.FindOneAndDelete().SetSort(bson.M{"_id": 1}))
+	s.Nil(err)
+	s.Equal(first.Id, deleted.Id)
+
+	_, err = s.MongoRepository.FindById(ctx, first.Id)
+	s.ErrorIs(err, ErrNotFound)
+
+	_, err = s.MongoRepository.FindOneAndDelete(ctx, bson.M{"name": "missing"})
+	s.ErrorIs(err, ErrNotFound)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Insert_Duplicate() {
+	ctx := context.Background()
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "once"}
+	_, err := s.MongoRepository.Insert(ctx, entity)
+	s.Nil(err)
+
+	_, err = s.MongoRepository.Insert(ctx, entity)
+	s.ErrorIs(err, ErrDuplicateKey)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Update_NotFound() {
+	ctx := context.Background()
+
+	_, err := s.MongoRepository.Update(ctx, TestEntity{Id: bson.NewObjectID(), Name: "ghost"})
+	s.ErrorIs(err, ErrNotFound)
+
+	count, err := s.MongoRepository.CountDocumentsInCollected(ctx)
+	s.Nil(err)
+	s.Equal(int64(0), count)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Replace() {
+	ctx := context.Background()
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "before"}
+	_, err := s.MongoRepository.Save(ctx, entity)
+	s.Nil(err)
+
+	_, err = s.Collection.UpdateOne(ctx, bson.M{"_id": entity.Id}, bson.M{"$set": bson.M{"stale": true}})
+	s.Nil(err)
+
+	entity.Name = "after"
+	_, err = s.MongoRepository.Replace(ctx, entity)
+	s.Nil(err)
+
+	var raw bson.M
+	err = s.Collection.FindOne(ctx, bson.M{"_id": entity.Id}).Decode(&raw)
+	s.Nil(err)
+	s.Equal("after", raw["name"])
+	s.NotContains(raw, "stale")
+
+	_, err = s.MongoRepository.Replace(ctx, TestEntity{Id: bson.NewObjectID()})
+	s.ErrorIs(err, ErrNotFound)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Upsert() {
+	ctx := context.Background()
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "first"}
+	result, err := s.MongoRepository.Upsert(ctx, entity)
+	s.Nil(err)
+	s.True(result.Inserted)
+
+	entity.Name = "second"
+	result, err = s.MongoRepository.Upsert(ctx, entity)
+	s.Nil(err)
+	s.False(result.Inserted)
+	s.Equal("second", result.Entity.Name)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_UpsertByFilter() {
+	ctx := context.Background()
+
+	filter := bson.M{"name": "natural-key"}
+	result, err := s.MongoRepository.UpsertByFilter(ctx, filter, TestEntity{Name: "natural-key"})
+	s.Nil(err)
+	s.True(result.Inserted)
+	s.False(result.Entity.Id.IsZero())
+
+	again, err := s.MongoRepository.UpsertByFilter(ctx, filter, TestEntity{Id: bson.NewObjectID(), Name: "natural-key"})
+	s.Nil(err)
+	s.False(again.Inserted)
+	s.Equal(result.Entity.Id, again.Entity.Id)
+
+	count, err := s.MongoRepository.CountByFilter(ctx, filter)
+	s.Nil(err)
+	s.Equal(int64(1), count)
+
+	// the written document is returned even when it no longer matches the filter
+	renamed, err := s.MongoRepository.UpsertByFilter(ctx, filter, TestEntity{Name: "renamed-key"})
+	s.Nil(err)
+	s.False(renamed.Inserted)
+	s.Equal(result.Entity.Id, renamed.Entity.Id)
+	s.Equal("renamed-key", renamed.Entity.Name)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_FindProjected() {
+	ctx := context.Background()
+
+	e1 := TestEntity{Id: bson.NewObjectID(), Name: "projected"}
+	e2 := TestEntity{Id: bson.NewObjectID(), Name: "other"}
+	_, err := s.MongoRepository.SaveMany(ctx, []interface{}{e1, e2})
+	s.Nil(err)
+
+	names, err := FindProjected[TestEntity, TestEntityName](s.MongoRepository, ctx, bson.M{"name": "projected"})
+	s.Nil(err)
+	s.Len(names, 1)
+	s.Equal("projected", names[0].Name)
+
+	name, err := FindOneProjected[TestEntity, TestEntityName](s.MongoRepository, ctx, bson.M{"_id": e2.Id})
+	s.Nil(err)
+	s.Equal("other", name.Name)
+
+	_, err = FindOneProjected[TestEntity, TestEntityName](s.MongoRepository, ctx, bson.M{"_id": bson.NewObjectID()})
+	s.ErrorIs(err, ErrNotFound)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_FindById_Projection() {
+	ctx := context.Background()
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "hidden"}
+	_, err := s.MongoRepository.Save(ctx, entity)
+	s.Nil(err)
+
+	found, err := s.MongoRepository.FindById(ctx, entity.Id, options.FindOne().SetProjection(bson.M{"name": 0}))
+	s.Nil(err)
+	s.Equal(entity.Id, found.Id)
+	s.Empty(found.Name)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_FindAllPageable_Projection() {
+	ctx := context.Background()
+
+	_, err := s.MongoRepository.SaveMany(ctx, []interface{}{
+		TestEntity{Id: bson.NewObjectID(), Name: "page 1"},
+		TestEntity{Id: bson.NewObjectID(), Name: "page 2"},
+	})
+	s.Nil(err)
+
+	page, err := s.MongoRepository.FindAllPageable(base_entity.PageableDBRequest{
+		NumberPerPage: 1,
+		Projection:    bson.M{"_id": 0, "name": 0},
+	}, ctx)
+	s.Nil(err)
+	s.Equal(int64(1), page.NoOfItemsInBatch)
+	s.Empty(page.Data[0].Name)
+	s.NotEmpty(page.LastItemId)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Stream() {
+	ctx := context.Background()
+
+	var entities []interface{}
+	for i := 0; i < 5; i++ {
+		entities = append(entities, TestEntity{Id: bson.NewObjectID(), Name: fmt.Sprintf("stream %d", i)})
+	}
+	_, err := s.MongoRepository.SaveMany(ctx, entities)
+	s.Nil(err)
+
+	var names []string
+	for entity, err := range s.MongoRepository.Stream(ctx, bson.M{}, options.Find().SetBatchSize(2).SetSort(bson.M{"_id": 1})) {
+		s.Nil(err)
+		names = append(names, entity.Name)
+		if len(names) == 3 {
+			break
+		}
+	}
+
+	s.Equal([]string{"stream 0", "stream 1", "stream 2"}, names)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Stream_Cancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := s.MongoRepository.SaveMany(ctx, []interface{}{
+		TestEntity{Id: bson.NewObjectID(), Name: "a"},
+		TestEntity{Id: bson.NewObjectID(), Name: "b"},
+	})
+	s.Nil(err)
+
+	var lastErr error
+	for _, err := range s.MongoRepository.Stream(ctx, bson.M{}) {
+		cancel()
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	s.ErrorIs(lastErr, context.Canceled)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_ForEach() {
+	ctx := context.Background()
+
+	_, err := s.MongoRepository.SaveMany(ctx, []interface{}{
+		TestEntity{Id: bson.NewObjectID(), Name: "each"},
+		TestEntity{Id: bson.NewObjectID(), Name: "each"},
+	})
+	s.Nil(err)
+
+	count := 0
+	err = s.MongoRepository.ForEach(ctx, bson.M{"name": "each"}, func(entity *TestEntity) error {
+		count++
+		return nil
+	})
+	s.Nil(err)
+	s.Equal(2, count)
+
+	stop := fmt.Errorf("stop")
+	err = s.MongoRepository.ForEach(ctx, bson.M{}, func(entity *TestEntity) error {
+		return stop
+	})
+	s.ErrorIs(err, stop)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_ProcessAll() {
+	ctx := context.Background()
+	progress := s.Collection.Database().Collection("test_entities_progress")
+	defer progress.Drop(ctx)
+
+	var entities []interface{}
+	var ids []bson.ObjectID
+	for i := 0; i < 25; i++ {
+		entity := TestEntity{Id: bson.NewObjectID(), Name: fmt.Sprintf("process %d", i)}
+		ids = append(ids, entity.Id)
+		entities = append(entities, entity)
+	}
+	_, err := s.MongoRepository.SaveMany(ctx, entities)
+	s.Nil(err)
+
+	_, err = s.Collection.InsertOne(ctx, bson.M{"_id": "legacy", "name": "not an ObjectID"})
+	s.Nil(err)
+
+	// the handler fails partway through, for the first attempt at the 8th document only
+	failing := ids[7]
+	var mu sync.Mutex
+	seen := map[bson.ObjectID]int{}
+	fn := func(ctx context.Context, entity *TestEntity) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[entity.Id]++
+		if entity.Id == failing && seen[entity.Id] == 1 {
+			return fmt.Errorf("boom")
+		}
+		return nil
+	}
+
+	opts := &ProcessOptions{JobName: "backfill", ProgressCollection: progress}
+	report, err := s.MongoRepository.ProcessAll(ctx, bson.M{}, 3, 4, fn, opts)
+	s.Nil(err)
+	s.Equal(int64(24), report.Processed)
+	s.Equal(int64(1), report.Failed)
+	s.Equal(failing, report.Failures[0].Id)
+	s.Equal(int64(1), report.Skipped)
+	s.Equal([]any{"legacy"}, report.SkippedIds)
+	s.Len(seen, 25)
+	for _, count := range seen {
+		s.Equal(1, count)
+	}
+
+	// the checkpoint holds below the failed document
+	s.Equal(ids[6], report.LastId)
+
+	// a second run resumes there, retrying the failure and the documents after it
+	extra := TestEntity{Id: bson.NewObjectID(), Name: "late"}
+	_, err = s.MongoRepository.Save(ctx, extra)
+	s.Nil(err)
+
+	report, err = s.MongoRepository.ProcessAll(ctx, bson.M{}, 3, 4, fn, opts)
+	s.Nil(err)
+	s.True(report.Resumed)
+	s.Equal(int64(19), report.Processed)
+	s.Equal(int64(0), report.Failed)
+	s.Equal(extra.Id, report.LastId)
+	s.Equal(1, seen[ids[6]])
+	s.Equal(2, seen[failing])
+}
+
+func (s *EntityTestSuite) TestMongoRepository_ProcessAll_MaxFailures() {
+	ctx := context.Background()
+
+	var entities []interface{}
+	for i := 0; i < 10; i++ {
+		entities = append(entities, TestEntity{Id: bson.NewObjectID(), Name: "fail"})
+	}
+	_, err := s.MongoRepository.SaveMany(ctx, entities)
+	s.Nil(err)
+
+	fn := func(ctx context.Context, entity *TestEntity) error {
+		return fmt.Errorf("always")
+	}
+
+	_, err = s.MongoRepository.ProcessAll(ctx, bson.M{"name": "fail"}, 1, 2, fn, &ProcessOptions{MaxFailures: 2})
+	s.ErrorIs(err, ErrTooManyFailures)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_DispatchesDomainEvents() {
+	ctx := context.Background()
+	dispatcher := NewEventDispatcher()
+	repo := MongoRepository[EventfulEntity]{Collection: s.Collection, Events: dispatcher}
+
+	var renamed []string
+	Subscribe(dispatcher, func(ctx context.Context, event EntityRenamed) error {
+		renamed = append(renamed, event.Name)
+		return nil
+	})
+
+	entity := EventfulEntity{Id: bson.NewObjectID()}
+	entity.Rename("created")
+	saved, err := repo.Save(ctx, entity)
+	s.Nil(err)
+	s.Equal([]string{"created"}, renamed)
+
+	// the caller's entity shares its events with the saved copy, so they are not published twice
+	_, err = repo.Update(ctx, entity)
+	s.Nil(err)
+	s.Equal([]string{"created"}, renamed)
+
+	saved.Rename("updated")
+	_, err = repo.UpdateMany(ctx, []EventfulEntity{*saved})
+	s.Nil(err)
+	s.Equal([]string{"created", "updated"}, renamed)
+
+	// a failed write dispatches nothing
+	duplicate := EventfulEntity{Id: entity.Id}
+	duplicate.Rename("duplicate")
+	_, err = repo.Save(ctx, duplicate)
+	s.NotNil(err)
+	s.Equal([]string{"created", "updated"}, renamed)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_History() {
+	ctx := WithActor(context.Background(), "auditor")
+	history := NewHistory(s.Collection)
+	defer history.Collection.Drop(ctx)
+	s.Nil(history.EnsureIndexes(ctx))
+
+	repo := MongoRepository[TestEntity]{Collection: s.Collection, History: history}
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "v1"}
+	_, err := repo.Save(ctx, entity)
+	s.Nil(err)
+
+	time.Sleep(10 * time.Millisecond)
+	afterInsert := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	entity.Name = "v2"
+	_, err = repo.Update(ctx, entity)
+	s.Nil(err)
+
+	err = repo.UpdateOne(ctx, bson.M{"_id": entity.Id}, bson.M{"$set": bson.M{"name": "v3"}})
+	s.Nil(err)
+
+	err = repo.Delete(ctx, entity.Id)
+	s.Nil(err)
+
+	entries, err := repo.FindHistory(ctx, entity.Id)
+	s.Nil(err)
+	s.Len(entries, 4)
+
+	s.Equal([]HistoryOperation{HistoryInsert, HistoryUpdate, HistoryUpdate, HistoryDelete},
+		[]HistoryOperation{entries[0].Operation, entries[1].Operation, entries[2].Operation, entries[3].Operation})
+	s.Equal(int64(4), entries[3].Version)
+	s.Equal("auditor", entries[0].Actor)
+	s.Equal("v3", entries[2].Document.Name)
+	s.Nil(entries[3].Document)
+
+	asOf, err := repo.FindAsOf(ctx, entity.Id, afterInsert)
+	s.Nil(err)
+	s.Equal("v1", asOf.Name)
+
+	_, err = repo.FindAsOf(ctx, entity.Id, time.Now())
+	s.ErrorIs(err, ErrNotFound)
+
+	_, err = repo.FindAsOf(ctx, entity.Id, afterInsert.Add(-time.Hour))
+	s.ErrorIs(err, ErrNotFound)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_History_FindAndModify() {
+	ctx := context.Background()
+	history := NewHistory(s.Collection)
+	defer history.Collection.Drop(ctx)
+	s.Nil(history.EnsureIndexes(ctx))
+
+	repo := MongoRepository[TestEntity]{Collection: s.Collection, History: history}
+
+	// an upsert returning the document before the write inserts and returns nothing
+	id := bson.NewObjectID()
+	_, err := repo.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"name": "v1"}}, options.FindOneAndUpdate().SetUpsert(true))
+	s.ErrorIs(err, ErrNotFound)
+
+	before, err := repo.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"name": "v2"}})
+	s.Nil(err)
+	s.Equal("v1", before.Name)
+
+	_, err = repo.FindOneAndReplace(ctx, bson.M{"_id": id}, TestEntity{Id: id, Name: "v3"}, options.FindOneAndReplace().SetReturnDocument(options.After))
+	s.Nil(err)
+
+	_, err = repo.FindOneAndDelete(ctx, bson.M{"_id": id})
+	s.Nil(err)
+
+	entries, err := repo.FindHistory(ctx, id)
+	s.Nil(err)
+	s.Len(entries, 4)
+
+	s.Equal([]HistoryOperation{HistoryInsert, HistoryUpdate, HistoryReplace, HistoryDelete},
+		[]HistoryOperation{entries[0].Operation, entries[1].Operation, entries[2].Operation, entries[3].Operation})
+	s.Equal("v1", entries[0].Document.Name)
+	s.Equal("v2", entries[1].Document.Name)
+	s.Equal("v3", entries[2].Document.Name)
+	s.Nil(entries[3].Document)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_Upgrader_WriteBack() {
+	ctx := context.Background()
+	upgrader := versionedUpgrader()
+	upgrader.WriteBack = true
+	repo := MongoRepository[versionedEntity]{Collection: s.Collection, Upgrader: upgrader}
+
+	id := bson.NewObjectID()
+	_, err := s.Collection.InsertOne(ctx, bson.M{"_id": id, "name": "Ada Lovelace", "note": "kept"})
+	s.Nil(err)
+
+	entity, err := repo.FindById(ctx, id)
+	s.Nil(err)
+	s.Equal("Lovelace", entity.LastName)
+	upgrader.Wait()
+
+	var stored bson.M
+	s.Nil(s.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&stored))
+	s.Equal("Ada", stored["firstName"])
+	s.Equal("kept", stored["note"])
+	s.Equal(int32(2), stored["_schemaVersion"])
+	s.NotContains(stored, "name")
+
+	// projected reads are upgraded but never written back
+	other := bson.NewObjectID()
+	_, err = s.Collection.InsertOne(ctx, bson.M{"_id": other, "name": "Grace Hopper"})
+	s.Nil(err)
+
+	projected, err := repo.FindEntityDocumentByFilter(ctx, bson.M{"_id": other}, options.FindOne().SetProjection(bson.M{"name": 1}))
+	s.Nil(err)
+	s.Equal("Grace", projected.FirstName)
+	upgrader.Wait()
+
+	count, err := s.Collection.CountDocuments(ctx, bson.M{"_id": other, "_schemaVersion": bson.M{"$exists": false}})
+	s.Nil(err)
+	s.Equal(int64(1), count)
+}
+
+func (s *EntityTestSuite) TestMongoRepository_History_Disabled() {
+	_, err := s.MongoRepository.FindHistory(context.Background(), bson.NewObjectID())
+	s.ErrorIs(err, ErrHistoryDisabled)
+}
+
 func TestEntityTestSuite(t *testing.T) {
 	suite.Run(t, new(EntityTestSuite))
 }
@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// OperationType the kind of change a ChangeEvent describes
+type OperationType string
+
+const (
+	OperationInsert     OperationType = "insert"
+	OperationUpdate     OperationType = "update"
+	OperationReplace    OperationType = "replace"
+	OperationDelete     OperationType = "delete"
+	OperationDrop       OperationType = "drop"
+	OperationRename     OperationType = "rename"
+	OperationInvalidate OperationType = "invalidate"
+)
+
+// ChangeEvent a change stream event with the full document decoded into T
+type ChangeEvent[T base_entity.Entity] struct {
+	OperationType     OperationType      `bson:"operationType"`
+	FullDocument      *T                 `bson:"fullDocument"`
+	DocumentKey       bson.M             `bson:"documentKey"`
+	UpdateDescription *UpdateDescription `bson:"updateDescription"`
+	ClusterTime       bson.Timestamp     `bson:"clusterTime"`
+	// ResumeToken resumes a stream right after this event
+	ResumeToken bson.Raw `bson:"-"`
+}
+
+// UpdateDescription the fields an update event changed
+type UpdateDescription struct {
+	UpdatedFields   bson.M   `bson:"updatedFields"`
+	RemovedFields   []string `bson:"removedFields"`
+	TruncatedArrays []bson.M `bson:"truncatedArrays"`
+}
+
+// ResumeTokenStore persists change stream resume tokens by stream name
+type ResumeTokenStore interface {
+	// LoadResumeToken return the last saved token, or nil when there is none
+	LoadResumeToken(ctx context.Context, name string) (bson.Raw, error)
+	SaveResumeToken(ctx context.Context, name string, token bson.Raw) error
+}
+
+// MongoResumeTokenStore keeps resume tokens in a collection, one document per stream name
+type MongoResumeTokenStore struct {
+	Collection *mongo.Collection
+}
+
+type resumeTokenDocument struct {
+	Name      string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// LoadResumeToken return the last saved token, or nil when there is none
+func (s MongoResumeTokenStore) LoadResumeToken(ctx context.Context, name string) (bson.Raw, error) {
+	var document resumeTokenDocument
+	err := s.Collection.FindOne(ctx, bson.M{"_id": name}).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return document.Token, nil
+}
+
+// SaveResumeToken store the token for the stream name
+func (s MongoResumeTokenStore) SaveResumeToken(ctx context.Context, name string, token bson.Raw) error {
+	document := resumeTokenDocument{Name: name, Token: token, UpdatedAt: time.Now().UTC()}
+	_, err := s.Collection.ReplaceOne(ctx, bson.M{"_id": name}, document, options.Replace().SetUpsert(true))
+	return err
+}
+
+// MemoryResumeTokenStore keeps resume tokens in memory, for tests and single-process consumers
+type MemoryResumeTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]bson.Raw
+}
+
+// NewMemoryResumeTokenStore create an empty in-memory token store
+func NewMemoryResumeTokenStore() *MemoryResumeTokenStore {
+	return &MemoryResumeTokenStore{tokens: make(map[string]bson.Raw)}
+}
+
+// LoadResumeToken return the last saved token, or nil when there is none
+func (s *MemoryResumeTokenStore) LoadResumeToken(_ context.Context, name string) (bson.Raw, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.tokens[name], nil
+}
+
+// SaveResumeToken store the token for the stream name
+func (s *MemoryResumeTokenStore) SaveResumeToken(_ context.Context, name string, token bson.Raw) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[name] = token
+	return nil
+}
+
+// WatchOptions tunes Watch
+type WatchOptions struct {
+	// Name keys the resume token in the TokenStore. Defaults to the collection name.
+	Name string
+	// TokenStore persists the resume token after every event the consumer handled
+	TokenStore ResumeTokenStore
+	// FullDocument defaults to options.UpdateLookup so update events carry the document
+	FullDocument options.FullDocument
+	// MaxRetries caps consecutive resumes after transient errors. Defaults to 5.
+	MaxRetries int
+	// RetryBackoff the wait before the first resume, doubled on each further attempt. Defaults to 500ms.
+	RetryBackoff time.Duration
+	// BatchSize the number of events per server round trip
+	BatchSize int32
+}
+
+// Watch subscribe to changes on the collection, optionally narrowed by a filter on the event
+// fields such as {"operationType": "insert"} or {"fullDocument.name": "x"}.
+// Streams are resumed from the stored token on start and after transient errors. The token is
+// saved once the consumer moves past an event, so delivery is at least once. The iteration ends
+// when the context is cancelled, the stream is invalidated or a non-transient error occurs.
+func (p MongoRepository[T]) Watch(ctx context.Context, filter bson.M, opts ...*WatchOptions) iter.Seq2[*ChangeEvent[T], error] {
+	opt := mergeWatchOptions(opts, p.Collection.Name())
+
+	var pipeline mongo.Pipeline
+	if len(filter) > 0 {
+		pipeline = mongo.Pipeline{{{Key: "$match", Value: filter}}}
+	}
+
+	return func(yield func(*ChangeEvent[T], error) bool) {
+		var token bson.Raw
+		if opt.TokenStore != nil {
+			var err error
+			if token, err = opt.TokenStore.LoadResumeToken(ctx, opt.Name); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		attempts := 0
+		for {
+			streamOptions := options.ChangeStream().SetFullDocument(opt.FullDocument)
+			if opt.BatchSize > 0 {
+				streamOptions.SetBatchSize(opt.BatchSize)
+			}
+			if token != nil {
+				streamOptions.SetResumeAfter(token)
+			}
+
+			stream, err := p.Collection.Watch(ctx, pipeline, streamOptions)
+			if err == nil {
+				var done bool
+				token, done, err = p.consumeChangeStream(ctx, stream, opt, token, &attempts, yield)
+				if done {
+					return
+				}
+			}
+
+			if ctx.Err() != nil {
+				yield(nil, ctx.Err())
+				return
+			}
+
+			if !isTransientStreamError(err) || attempts >= opt.MaxRetries {
+				yield(nil, err)
+				return
+			}
+
+			attempts++
+			log.WithError(err).Warn("resuming change stream on ", p.Collection.Name())
+
+			if !sleepContext(ctx, opt.RetryBackoff<<(attempts-1)) {
+				yield(nil, ctx.Err())
+				return
+			}
+		}
+	}
+}
+
+// consumeChangeStream yield events until the stream stops. done is true when iteration must end
+// without resuming; otherwise err holds the error the stream stopped with.
+func (p MongoRepository[T]) consumeChangeStream(ctx context.Context, stream *mongo.ChangeStream, opt WatchOptions, token bson.Raw, attempts *int, yield func(*ChangeEvent[T], error) bool) (bson.Raw, bool, error) {
+	defer stream.Close(context.WithoutCancel(ctx))
+
+	for stream.Next(ctx) {
+		*attempts = 0
+
+		var event ChangeEvent[T]
+		if err := stream.Decode(&event); err != nil {
+			if !yield(nil, err) {
+				return token, true, nil
+			}
+			continue
+		}
+
+		event.ResumeToken = append(bson.Raw(nil), stream.ResumeToken()...)
+		if !yield(&event, nil) {
+			return token, true, nil
+		}
+
+		token = event.ResumeToken
+		if opt.TokenStore != nil {
+			if err := opt.TokenStore.SaveResumeToken(ctx, opt.Name, token); err != nil {
+				log.WithError(err).Warn("could not save resume token for stream: ", opt.Name)
+			}
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return token, false, err
+	}
+
+	// the stream was invalidated, or closed by the context which the caller reports
+	return token, ctx.Err() == nil, nil
+}
+
+func isTransientStreamError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	var serverError mongo.ServerError
+	return errors.As(err, &serverError) && serverError.HasErrorLabel("ResumableChangeStreamError")
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func mergeWatchOptions(opts []*WatchOptions, collectionName string) WatchOptions {
+	merged := WatchOptions{
+		Name:         collectionName,
+		FullDocument: options.UpdateLookup,
+		MaxRetries:   5,
+		RetryBackoff: 500 * time.Millisecond,
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Name != "" {
+			merged.Name = opt.Name
+		}
+		if opt.TokenStore != nil {
+			merged.TokenStore = opt.TokenStore
+		}
+		if opt.FullDocument != "" {
+			merged.FullDocument = opt.FullDocument
+		}
+		if opt.MaxRetries > 0 {
+			merged.MaxRetries = opt.MaxRetries
+		}
+		if opt.RetryBackoff > 0 {
+			merged.RetryBackoff = opt.RetryBackoff
+		}
+		if opt.BatchSize > 0 {
+			merged.BatchSize = opt.BatchSize
+		}
+	}
+
+	return merged
+}
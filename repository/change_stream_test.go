@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type ChangeStreamTestSuite struct {
+	suite.Suite
+	MongoRepository[TestEntity]
+	*mongo.Collection
+}
+
+// SetupSuite start a single node replica set, which change streams require
+func (s *ChangeStreamTestSuite) SetupSuite() {
+	server := mongotest.Start(s.T(), &mongotest.Options{ReplicaSet: true})
+
+	s.Collection = server.Database(s.T()).Collection("watched_entities")
+	s.MongoRepository = MongoRepository[TestEntity]{Collection: s.Collection}
+}
+
+func (s *ChangeStreamTestSuite) TearDownTest() {
+	_, err := s.Collection.DeleteMany(context.Background(), bson.M{})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// watch collect events from a watch started in the background
+func (s *ChangeStreamTestSuite) watch(ctx context.Context, filter bson.M, opts *WatchOptions) <-chan *ChangeEvent[TestEntity] {
+	events := make(chan *ChangeEvent[TestEntity], 10)
+	go func() {
+		defer close(events)
+		for event, err := range s.MongoRepository.Watch(ctx, filter, opts) {
+			if err != nil {
+				return
+			}
+			events <- event
+		}
+	}()
+
+	// give the stream time to open before writes happen
+	time.Sleep(500 * time.Millisecond)
+	return events
+}
+
+func (s *ChangeStreamTestSuite) receive(events <-chan *ChangeEvent[TestEntity]) *ChangeEvent[TestEntity] {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(10 * time.Second):
+		s.FailNow("no change event received")
+		return nil
+	}
+}
+
+func (s *ChangeStreamTestSuite) TestWatch_TypedEvents() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := s.watch(ctx, nil, nil)
+
+	entity := TestEntity{Id: bson.NewObjectID(), Name: "watched"}
+	_, err := s.MongoRepository.Save(ctx, entity)
+	s.Nil(err)
+
+	err = s.MongoRepository.UpdateOne(ctx, bson.M{"_id": entity.Id}, bson.M{"$set": bson.M{"name": "changed"}})
+	s.Nil(err)
+
+	err = s.MongoRepository.Delete(ctx, entity.Id)
+	s.Nil(err)
+
+	inserted := s.receive(events)
+	s.Equal(OperationInsert, inserted.OperationType)
+	s.Equal("watched", inserted.FullDocument.Name)
+	s.Equal(entity.Id, inserted.DocumentKey["_id"])
+
+	updated := s.receive(events)
+	s.Equal(OperationUpdate, updated.OperationType)
+	s.Equal("changed", updated.UpdateDescription.UpdatedFields["name"])
+	s.Equal("changed", updated.FullDocument.Name)
+
+	deleted := s.receive(events)
+	s.Equal(OperationDelete, deleted.OperationType)
+	s.Nil(deleted.FullDocument)
+}
+
+func (s *ChangeStreamTestSuite) TestWatch_ResumesFromStoredToken() {
+	store := NewMemoryResumeTokenStore()
+	opts := &WatchOptions{Name: "resumable", TokenStore: store}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := s.watch(ctx, bson.M{"operationType": "insert"}, opts)
+
+	first := TestEntity{Id: bson.NewObjectID(), Name: "first"}
+	_, err := s.MongoRepository.Save(context.Background(), first)
+	s.Nil(err)
+	s.Equal(first.Id, s.receive(events).FullDocument.Id)
+
+	// the token is saved once the consumer asks for the next event
+	s.Eventually(func() bool {
+		token, _ := store.LoadResumeToken(context.Background(), "resumable")
+		return token != nil
+	}, 5*time.Second, 100*time.Millisecond)
+	cancel()
+
+	second := TestEntity{Id: bson.NewObjectID(), Name: "second"}
+	_, err = s.MongoRepository.Save(context.Background(), second)
+	s.Nil(err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	resumed := s.watch(ctx, bson.M{"operationType": "insert"}, opts)
+	s.Equal(second.Id, s.receive(resumed).FullDocument.Id)
+}
+
+func TestChangeStreamTestSuite(t *testing.T) {
+	suite.Run(t, new(ChangeStreamTestSuite))
+}
+
+func TestIsTransientStreamError(t *testing.T) {
+	assert.False(t, isTransientStreamError(nil))
+	assert.False(t, isTransientStreamError(fmt.Errorf("boom")))
+	assert.True(t, isTransientStreamError(context.DeadlineExceeded))
+	assert.True(t, isTransientStreamError(mongo.CommandError{Code: 1, Labels: []string{"ResumableChangeStreamError"}}))
+}
+
+func TestMergeWatchOptions(t *testing.T) {
+	merged := mergeWatchOptions([]*WatchOptions{nil, {Name: "orders", MaxRetries: 2}}, "entities")
+
+	assert.Equal(t, "orders", merged.Name)
+	assert.Equal(t, 2, merged.MaxRetries)
+	assert.Equal(t, options.UpdateLookup, merged.FullDocument)
+	assert.Equal(t, 500*time.Millisecond, merged.RetryBackoff)
+}
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ChangeTracker keeps a snapshot of the entities loaded or saved through a MongoRepository
+// so that Update can send only the fields that changed since.
+// Assign one to MongoRepository.ChangeTracker to opt in; a nil tracker disables tracking.
+// Snapshots are the entity as T marshals it, so stored fields T does not map are never unset.
+// Deletes and writes the tracker cannot follow, such as UpdateOne, evict the snapshots they touch;
+// set Limit to bound the memory the rest take, and see SkipTracking to opt reads out.
+// The zero value is ready to use.
+type ChangeTracker struct {
+	// Limit the most snapshots kept, evicting the least recently tracked first. 0 keeps them all.
+	Limit int
+
+	mu        sync.RWMutex
+	snapshots map[bson.ObjectID]*list.Element
+	order     *list.List
+}
+
+// snapshotEntry a snapshot in the tracker's eviction order
+type snapshotEntry struct {
+	id  bson.ObjectID
+	raw bson.Raw
+}
+
+// NewChangeTracker create an empty change tracker
+func NewChangeTracker() *ChangeTracker {
+	return &ChangeTracker{}
+}
+
+// init allocate the snapshot store of a zero tracker. Callers hold the write lock.
+func (c *ChangeTracker) init() {
+	if c.snapshots == nil {
+		c.snapshots = make(map[bson.ObjectID]*list.Element)
+		c.order = list.New()
+	}
+}
+
+// IsTracked reports whether a snapshot exists for the given id
+func (c *ChangeTracker) IsTracked(id bson.ObjectID) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.snapshots[id]
+	return ok
+}
+
+// Len the number of snapshots kept
+func (c *ChangeTracker) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.snapshots)
+}
+
+// Forget drop the snapshots of the given ids
+func (c *ChangeTracker) Forget(ids ...bson.ObjectID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if element, ok := c.snapshots[id]; ok {
+			c.order.Remove(element)
+			delete(c.snapshots, id)
+		}
+	}
+}
+
+// Clear drop every snapshot
+func (c *ChangeTracker) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.snapshots = make(map[bson.ObjectID]*list.Element)
+	c.order = list.New()
+}
+
+// Changes compute the update document that turns the snapshot of id into entity.
+// tracked is false when no snapshot exists; update is empty when nothing changed.
+func (c *ChangeTracker) Changes(id bson.ObjectID, entity any) (update bson.D, tracked bool, err error) {
+	// track replaces the raw of an entry under the write lock, so read it before unlocking
+	var before bson.Raw
+	c.mu.RLock()
+	element, ok := c.snapshots[id]
+	if ok {
+		before = element.Value.(*snapshotEntry).raw
+	}
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil, false, nil
+	}
+
+	after, err := bson.Marshal(entity)
+	if err != nil {
+		return nil, true, err
+	}
+
+	update, err = diffDocuments(before, after)
+	return update, true, err
+}
+
+// track store a snapshot of a marshalled entity keyed by its _id, evicting the least recently
+// tracked snapshot beyond Limit. Documents without an ObjectID _id are ignored.
+func (c *ChangeTracker) track(doc bson.Raw) {
+	id, ok := doc.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return
+	}
+
+	snapshot := make(bson.Raw, len(doc))
+	copy(snapshot, doc)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.init()
+	if element, ok := c.snapshots[id]; ok {
+		element.Value.(*snapshotEntry).raw = snapshot
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.snapshots[id] = c.order.PushFront(&snapshotEntry{id: id, raw: snapshot})
+	for c.Limit > 0 && c.order.Len() > c.Limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.snapshots, oldest.Value.(*snapshotEntry).id)
+	}
+}
+
+// trackEntity store a snapshot of an entity that is known to match the database
+func (c *ChangeTracker) trackEntity(entity any) error {
+	doc, err := bson.Marshal(entity)
+	if err != nil {
+		return err
+	}
+
+	c.track(doc)
+	return nil
+}
+
+type skipTrackingKey struct{}
+
+// SkipTracking opt reads made with the context out of change tracking, e.g. for reports that
+// load many entities without updating them
+func SkipTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipTrackingKey{}, true)
+}
+
+// forRead the repository to decode a read with: reads opted out with SkipTracking are not
+// tracked, and projected reads are neither tracked nor written back after an upgrade
+func (p MongoRepository[T]) forRead(ctx context.Context, projected bool) MongoRepository[T] {
+	if skip, _ := ctx.Value(skipTrackingKey{}).(bool); skip || projected {
+		p.ChangeTracker = nil
+	}
+	if projected {
+		p.Upgrader = p.Upgrader.withoutWriteBack()
+	}
+	return p
+}
+
+// diffDocuments build a $set/$unset update that turns before into after.
+// Embedded documents are compared field by field and arrays of equal length element by element,
+// so only the changed paths are written. Arrays that grew or shrank are replaced as a whole.
+func diffDocuments(before, after bson.Raw) (bson.D, error) {
+	var set, unset bson.D
+	if err := diffInto("", before, after, &set, &unset); err != nil {
+		return nil, err
+	}
+
+	var update bson.D
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	return update, nil
+}
+
+func diffInto(prefix string, before, after bson.Raw, set, unset *bson.D) error {
+	afterElements, err := after.Elements()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]struct{}, len(afterElements))
+	for _, element := range afterElements {
+		key := element.Key()
+		seen[key] = struct{}{}
+
+		if prefix == "" && key == "_id" {
+			continue
+		}
+
+		path := joinPath(prefix, key)
+		newValue := element.Value()
+
+		oldValue, err := before.LookupErr(key)
+		if err != nil {
+			*set = append(*set, bson.E{Key: path, Value: newValue})
+			continue
+		}
+
+		if oldValue.Equal(newValue) {
+			continue
+		}
+
+		oldDoc, oldIsDoc := oldValue.DocumentOK()
+		newDoc, newIsDoc := newValue.DocumentOK()
+		if oldIsDoc && newIsDoc {
+			if err := diffInto(path, oldDoc, newDoc, set, unset); err != nil {
+				return err
+			}
+			continue
+		}
+
+		oldArray, oldIsArray := oldValue.ArrayOK()
+		newArray, newIsArray := newValue.ArrayOK()
+		if oldIsArray && newIsArray && sameLength(oldArray, newArray) {
+			if err := diffInto(path, bson.Raw(oldArray), bson.Raw(newArray), set, unset); err != nil {
+				return err
+			}
+			continue
+		}
+
+		*set = append(*set, bson.E{Key: path, Value: newValue})
+	}
+
+	beforeElements, err := before.Elements()
+	if err != nil {
+		return err
+	}
+
+	for _, element := range beforeElements {
+		if _, ok := seen[element.Key()]; !ok {
+			*unset = append(*unset, bson.E{Key: joinPath(prefix, element.Key()), Value: ""})
+		}
+	}
+
+	return nil
+}
+
+func sameLength(a, b bson.RawArray) bool {
+	aValues, aErr := a.Values()
+	bValues, bErr := b.Values()
+	return aErr == nil && bErr == nil && len(aValues) == len(bValues)
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
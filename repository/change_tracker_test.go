@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type trackedAddress struct {
+	City string `bson:"city"`
+	Zip  string `bson:"zip,omitempty"`
+}
+
+type trackedEntity struct {
+	Id      bson.ObjectID  `bson:"_id"`
+	Name    string         `bson:"name"`
+	Address trackedAddress `bson:"address"`
+	Tags    []string       `bson:"tags"`
+	Note    string         `bson:"note,omitempty"`
+}
+
+func (t trackedEntity) GetId() bson.ObjectID {
+	return t.Id
+}
+
+func (t trackedEntity) SetId(id bson.ObjectID) {
+	t.Id = id
+}
+
+func changesFor(t *testing.T, before, after trackedEntity) bson.M {
+	tracker := NewChangeTracker()
+	assert.Nil(t, tracker.trackEntity(before))
+
+	update, tracked, err := tracker.Changes(before.Id, after)
+	assert.Nil(t, err)
+	assert.True(t, tracked)
+
+	result := bson.M{}
+	if len(update) == 0 {
+		return result
+	}
+
+	raw, err := bson.Marshal(update)
+	assert.Nil(t, err)
+
+	assert.Nil(t, bson.Unmarshal(raw, &result))
+	return result
+}
+
+func TestChangeTracker_NoChanges(t *testing.T) {
+	entity := trackedEntity{Id: bson.NewObjectID(), Name: "a", Tags: []string{"x"}}
+
+	assert.Empty(t, changesFor(t, entity, entity))
+}
+
+func TestChangeTracker_NestedDocumentsAndArrays(t *testing.T) {
+	before := trackedEntity{
+		Id:      bson.NewObjectID(),
+		Name:    "a",
+		Address: trackedAddress{City: "Lagos", Zip: "100001"},
+		Tags:    []string{"x", "y"},
+		Note:    "remove me",
+	}
+
+	after := before
+	after.Address = trackedAddress{City: "Abuja", Zip: "100001"}
+	after.Tags = []string{"x", "z"}
+	after.Note = ""
+
+	changes := changesFor(t, before, after)
+
+	assert.Equal(t, bson.D{{Key: "address.city", Value: "Abuja"}, {Key: "tags.1", Value: "z"}}, changes["$set"])
+	assert.Equal(t, bson.D{{Key: "note", Value: ""}}, changes["$unset"])
+}
+
+func TestChangeTracker_ResizedArrayIsReplaced(t *testing.T) {
+	before := trackedEntity{Id: bson.NewObjectID(), Tags: []string{"x"}}
+
+	after := before
+	after.Tags = []string{"x", "y"}
+
+	changes := changesFor(t, before, after)
+
+	assert.Equal(t, bson.D{{Key: "tags", Value: bson.A{"x", "y"}}}, changes["$set"])
+	assert.Nil(t, changes["$unset"])
+}
+
+func TestChangeTracker_UntrackedAndForgotten(t *testing.T) {
+	entity := trackedEntity{Id: bson.NewObjectID()}
+	tracker := NewChangeTracker()
+
+	_, tracked, err := tracker.Changes(entity.Id, entity)
+	assert.Nil(t, err)
+	assert.False(t, tracked)
+
+	assert.Nil(t, tracker.trackEntity(entity))
+	assert.True(t, tracker.IsTracked(entity.Id))
+
+	tracker.Forget(entity.Id)
+	assert.False(t, tracker.IsTracked(entity.Id))
+}
+
+func TestMongoRepository_DecodeKeepsUnmappedFields(t *testing.T) {
+	tracker := NewChangeTracker()
+	repo := MongoRepository[trackedEntity]{ChangeTracker: tracker}
+
+	id := bson.NewObjectID()
+	raw, _ := bson.Marshal(bson.M{"_id": id, "name": "a", "tags": bson.A{"x"}, "legacy": "kept"})
+
+	var entity trackedEntity
+	assert.Nil(t, repo.decode(raw, &entity))
+
+	entity.Name = "b"
+	update, tracked, err := tracker.Changes(id, entity)
+	assert.Nil(t, err)
+	assert.True(t, tracked)
+	var changes bson.M
+	data, _ := bson.Marshal(update)
+	assert.Nil(t, bson.Unmarshal(data, &changes))
+	assert.Equal(t, bson.M{"$set": bson.D{{Key: "name", Value: "b"}}}, changes)
+}
+
+func TestChangeTracker_Limit(t *testing.T) {
+	tracker := NewChangeTracker()
+	tracker.Limit = 2
+
+	first, second, third := trackedEntity{Id: bson.NewObjectID()}, trackedEntity{Id: bson.NewObjectID()}, trackedEntity{Id: bson.NewObjectID()}
+	assert.Nil(t, tracker.trackEntity(first))
+	assert.Nil(t, tracker.trackEntity(second))
+	// tracking again makes first the most recent
+	assert.Nil(t, tracker.trackEntity(first))
+	assert.Nil(t, tracker.trackEntity(third))
+
+	assert.Equal(t, 2, tracker.Len())
+	assert.True(t, tracker.IsTracked(first.Id))
+	assert.False(t, tracker.IsTracked(second.Id))
+	assert.True(t, tracker.IsTracked(third.Id))
+
+	tracker.Clear()
+	assert.Equal(t, 0, tracker.Len())
+}
+
+func TestMongoRepository_SkipTracking(t *testing.T) {
+	repo := MongoRepository[trackedEntity]{ChangeTracker: NewChangeTracker()}
+
+	assert.NotNil(t, repo.forRead(context.Background(), false).ChangeTracker)
+	assert.Nil(t, repo.forRead(SkipTracking(context.Background()), false).ChangeTracker)
+	assert.Nil(t, repo.forRead(context.Background(), true).ChangeTracker)
+}
+
+func TestChangeTracker_ZeroValue(t *testing.T) {
+	tracker := &ChangeTracker{Limit: 1}
+	entity := trackedEntity{Id: bson.NewObjectID()}
+
+	assert.False(t, tracker.IsTracked(entity.Id))
+	tracker.Forget(entity.Id)
+	tracker.Clear()
+
+	assert.Nil(t, tracker.trackEntity(entity))
+	assert.True(t, tracker.IsTracked(entity.Id))
+	assert.Nil(t, tracker.trackEntity(trackedEntity{Id: bson.NewObjectID()}))
+	assert.Equal(t, 1, tracker.Len())
+}
+
+func TestChangeTracker_ConcurrentTrackAndChanges(t *testing.T) {
+	tracker := NewChangeTracker()
+	entity := trackedEntity{Id: bson.NewObjectID(), Name: "a"}
+	assert.Nil(t, tracker.trackEntity(entity))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Go(func() {
+			for j := 0; j < 100; j++ {
+				_ = tracker.trackEntity(entity)
+				_, _, _ = tracker.Changes(entity.Id, entity)
+			}
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,20 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	"github.com/hub1989/mongo-data/v4/repository"
+	"github.com/hub1989/mongo-data/v4/repositorytest"
+)
+
+// TestConformance run the shared Repository suite against MongoRepository. It lives in an external
+// test package because repositorytest imports repository.
+func TestConformance(t *testing.T) {
+	server := mongotest.Start(t)
+
+	repositorytest.RunConformance(t, func(t *testing.T) repository.Repository[repositorytest.Entity] {
+		collection := server.Database(t).Collection("entities")
+		return repository.MongoRepository[repositorytest.Entity]{Collection: collection}
+	})
+}
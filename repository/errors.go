@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// ErrNotFound returned when no document matches.
+// The driver's mongo.ErrNoDocuments stays in the chain, so errors.Is works with either.
+var ErrNotFound = errors.New("document not found")
+
+// ErrDuplicateKey returned when a write violates the _id or a unique index.
+// The driver error stays in the chain for inspection.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// notFound map the driver's "no documents" error to ErrNotFound, leaving other errors untouched
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
+
+// duplicateKey map duplicate key write errors to ErrDuplicateKey, leaving other errors untouched
+func duplicateKey(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %w", ErrDuplicateKey, err)
+	}
+	return err
+}
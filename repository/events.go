@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	log "github.com/sirupsen/logrus"
+)
+
+// EventDispatcher delivers the domain events pulled from entities to in-process handlers.
+// Assign one to MongoRepository.Events to have Save, Insert, Update, UpdateMany, Replace and Upsert
+// drain entities implementing base_entity.EventSource after a successful write. Inside
+// WithTransaction, delivery waits until the transaction commits and is dropped if it aborts.
+// Writes drain the copy of the entity they were given, which they return: embed base_entity.Events
+// so the caller's entity is drained as well, or carry on with the returned entity.
+type EventDispatcher struct {
+	mu       sync.RWMutex
+	handlers []eventHandler
+}
+
+type eventHandler struct {
+	matches func(event any) bool
+	handle  func(ctx context.Context, event any) error
+}
+
+// NewEventDispatcher create a dispatcher without handlers
+func NewEventDispatcher() *EventDispatcher {
+	return &EventDispatcher{}
+}
+
+// Subscribe register a handler for events of type E. E may be an interface, and Subscribe[any]
+// receives every event. Handlers run synchronously in registration order.
+func Subscribe[E any](d *EventDispatcher, handler func(ctx context.Context, event E) error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.handlers = append(d.handlers, eventHandler{
+		matches: func(event any) bool {
+			_, ok := event.(E)
+			return ok
+		},
+		handle: func(ctx context.Context, event any) error {
+			return handler(ctx, event.(E))
+		},
+	})
+}
+
+// Dispatch deliver events to their handlers, or queue them until commit when ctx belongs to
+// WithTransaction. Every handler runs even if another fails; the failures are joined.
+func (d *EventDispatcher) Dispatch(ctx context.Context, events ...any) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	if pending, ok := ctx.Value(pendingEventsKey{}).(*pendingEvents); ok {
+		pending.add(d, nil, events)
+		return nil
+	}
+
+	return d.deliver(ctx, events)
+}
+
+func (d *EventDispatcher) deliver(ctx context.Context, events []any) error {
+	d.mu.RLock()
+	handlers := append([]eventHandler(nil), d.handlers...)
+	d.mu.RUnlock()
+
+	var errs []error
+	for _, event := range events {
+		for _, handler := range handlers {
+			if !handler.matches(event) {
+				continue
+			}
+			if err := handler.handle(ctx, event); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// publishEvents drain the entity's domain events into the dispatcher after a successful write.
+// entity is the repository's copy, returned to the caller drained; the caller's own entity is
+// drained too only when its events are shared between copies, as with base_entity.Events.
+// Handler failures are logged: the write they follow has already succeeded.
+func (p MongoRepository[T]) publishEvents(ctx context.Context, entity *T) {
+	if p.Events == nil {
+		return
+	}
+
+	var source base_entity.EventSource
+	if s, ok := any(entity).(base_entity.EventSource); ok {
+		source = s
+	} else if s, ok := any(*entity).(base_entity.EventSource); ok {
+		source = s
+	} else {
+		return
+	}
+
+	events := source.PullEvents()
+	if pending, ok := ctx.Value(pendingEventsKey{}).(*pendingEvents); ok {
+		// remember the entity, to put the events back if the transaction does not commit
+		if len(events) > 0 {
+			pending.add(p.Events, source, events)
+		}
+		return
+	}
+
+	if err := p.Events.Dispatch(ctx, events...); err != nil {
+		log.WithError(err).Error("domain event handlers failed for entity with ID: ", (*entity).GetId())
+	}
+}
+
+type pendingEventsKey struct{}
+
+// pendingEvents events queued during a transaction, delivered once it commits
+type pendingEvents struct {
+	mu      sync.Mutex
+	batches []pendingBatch
+}
+
+// pendingBatch events dispatched together, and the entity they were pulled from, if any
+type pendingBatch struct {
+	dispatcher *EventDispatcher
+	source     base_entity.EventSource
+	events     []any
+}
+
+func (p *pendingEvents) add(dispatcher *EventDispatcher, source base_entity.EventSource, events []any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.batches = append(p.batches, pendingBatch{dispatcher: dispatcher, source: source, events: events})
+}
+
+// restore drop the queued events after an aborted attempt, recording the ones pulled from entities
+// back into them: a retried attempt writing the same entity publishes them again, and the caller
+// keeps them when the transaction fails for good. Entities that are not a
+// base_entity.EventRecorder lose them.
+func (p *pendingEvents) restore() {
+	p.mu.Lock()
+	batches := p.batches
+	p.batches = nil
+	p.mu.Unlock()
+
+	for _, batch := range batches {
+		if batch.source == nil {
+			continue
+		}
+
+		recorder, ok := batch.source.(base_entity.EventRecorder)
+		if !ok {
+			log.WithFields(log.Fields{
+				"events": len(batch.events),
+			}).Warn(fmt.Sprintf("could not restore the events of an aborted transaction into %T", batch.source))
+			continue
+		}
+		for _, event := range batch.events {
+			recorder.Record(event)
+		}
+	}
+}
+
+func (p *pendingEvents) flush(ctx context.Context) {
+	p.mu.Lock()
+	batches := p.batches
+	p.batches = nil
+	p.mu.Unlock()
+
+	for _, batch := range batches {
+		if err := batch.dispatcher.deliver(ctx, batch.events); err != nil {
+			log.WithError(err).Error("domain event handlers failed after commit")
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type accountOpened struct {
+	Owner string
+}
+
+type accountClosed struct{}
+
+func TestEventDispatcher_DeliversByType(t *testing.T) {
+	dispatcher := NewEventDispatcher()
+
+	var opened []string
+	var all []any
+	Subscribe(dispatcher, func(ctx context.Context, event accountOpened) error {
+		opened = append(opened, event.Owner)
+		return nil
+	})
+	Subscribe(dispatcher, func(ctx context.Context, event any) error {
+		all = append(all, event)
+		return nil
+	})
+
+	err := dispatcher.Dispatch(context.Background(), accountOpened{Owner: "ada"}, accountClosed{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ada"}, opened)
+	assert.Equal(t, []any{accountOpened{Owner: "ada"}, accountClosed{}}, all)
+}
+
+func TestEventDispatcher_JoinsHandlerErrors(t *testing.T) {
+	dispatcher := NewEventDispatcher()
+	first := errors.New("first")
+
+	calls := 0
+	Subscribe(dispatcher, func(ctx context.Context, event accountClosed) error {
+		calls++
+		return first
+	})
+	Subscribe(dispatcher, func(ctx context.Context, event fmt.Stringer) error {
+		calls++
+		return nil
+	})
+	Subscribe(dispatcher, func(ctx context.Context, event accountClosed) error {
+		calls++
+		return nil
+	})
+
+	err := dispatcher.Dispatch(context.Background(), accountClosed{})
+	assert.ErrorIs(t, err, first)
+	assert.Equal(t, 2, calls)
+}
+
+func TestEventDispatcher_QueuesUntilFlush(t *testing.T) {
+	dispatcher := NewEventDispatcher()
+
+	var delivered []any
+	Subscribe(dispatcher, func(ctx context.Context, event any) error {
+		delivered = append(delivered, event)
+		return nil
+	})
+
+	pending := &pendingEvents{}
+	ctx := context.WithValue(context.Background(), pendingEventsKey{}, pending)
+
+	assert.Nil(t, dispatcher.Dispatch(ctx, accountOpened{Owner: "dropped"}))
+	pending.restore()
+	assert.Nil(t, dispatcher.Dispatch(ctx, accountOpened{Owner: "kept"}))
+	assert.Empty(t, delivered)
+
+	pending.flush(context.Background())
+	assert.Equal(t, []any{accountOpened{Owner: "kept"}}, delivered)
+}
+
+func TestPublishEvents_DrainsTheCallersEntity(t *testing.T) {
+	dispatcher := NewEventDispatcher()
+	repo := MongoRepository[EventfulEntity]{Events: dispatcher}
+
+	var renamed []string
+	Subscribe(dispatcher, func(ctx context.Context, event EntityRenamed) error {
+		renamed = append(renamed, event.Name)
+		return nil
+	})
+
+	entity := EventfulEntity{Id: bson.NewObjectID()}
+	entity.Rename("renamed")
+
+	// writes publish from their own copy of the entity
+	written := entity
+	repo.publishEvents(context.Background(), &written)
+	assert.Equal(t, []string{"renamed"}, renamed)
+	assert.Empty(t, entity.PullEvents())
+}
+
+func TestPendingEvents_RestoresIntoEntities(t *testing.T) {
+	dispatcher := NewEventDispatcher()
+	repo := MongoRepository[EventfulEntity]{Events: dispatcher}
+
+	var renamed []string
+	Subscribe(dispatcher, func(ctx context.Context, event EntityRenamed) error {
+		renamed = append(renamed, event.Name)
+		return nil
+	})
+
+	pending := &pendingEvents{}
+	ctx := context.WithValue(context.Background(), pendingEventsKey{}, pending)
+
+	entity := EventfulEntity{Id: bson.NewObjectID()}
+	entity.Rename("renamed")
+
+	// an attempt drains the entity, then aborts
+	written := entity
+	repo.publishEvents(ctx, &written)
+	pending.restore()
+
+	// the retry publishes the events again
+	written = entity
+	repo.publishEvents(ctx, &written)
+	pending.flush(context.Background())
+	assert.Equal(t, []string{"renamed"}, renamed)
+	assert.Empty(t, entity.PullEvents())
+}
+
+type EventsTestSuite struct {
+	suite.Suite
+	*mongo.Collection
+}
+
+// SetupSuite start a single node replica set, which transactions require
+func (s *EventsTestSuite) SetupSuite() {
+	server := mongotest.Start(s.T(), &mongotest.Options{ReplicaSet: true})
+	s.Collection = server.Database(s.T()).Collection("eventful_entities")
+}
+
+func (s *EventsTestSuite) TearDownTest() {
+	_, err := s.Collection.DeleteMany(context.Background(), bson.M{})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (s *EventsTestSuite) TestWithTransaction_DispatchesEventsAfterCommit() {
+	ctx := context.Background()
+	dispatcher := NewEventDispatcher()
+	repo := MongoRepository[EventfulEntity]{Collection: s.Collection, Events: dispatcher}
+
+	var renamed []string
+	Subscribe(dispatcher, func(ctx context.Context, event EntityRenamed) error {
+		renamed = append(renamed, event.Name)
+		return nil
+	})
+
+	err := WithTransaction(ctx, s.Collection.Database().Client(), func(ctx context.Context) error {
+		entity := EventfulEntity{Id: bson.NewObjectID()}
+		entity.Rename("committed")
+		if _, err := repo.Save(ctx, entity); err != nil {
+			return err
+		}
+
+		s.Empty(renamed)
+		return nil
+	})
+	s.Nil(err)
+	s.Equal([]string{"committed"}, renamed)
+
+	aborted := fmt.Errorf("abort")
+	err = WithTransaction(ctx, s.Collection.Database().Client(), func(ctx context.Context) error {
+		entity := EventfulEntity{Id: bson.NewObjectID()}
+		entity.Rename("aborted")
+		if _, err := repo.Save(ctx, entity); err != nil {
+			return err
+		}
+		return aborted
+	})
+	s.ErrorIs(err, aborted)
+	s.Equal([]string{"committed"}, renamed)
+}
+
+func (s *EventsTestSuite) TestWithTransaction_RetryKeepsEvents() {
+	ctx := context.Background()
+	dispatcher := NewEventDispatcher()
+	repo := MongoRepository[EventfulEntity]{Collection: s.Collection, Events: dispatcher}
+
+	var renamed []string
+	Subscribe(dispatcher, func(ctx context.Context, event EntityRenamed) error {
+		renamed = append(renamed, event.Name)
+		return nil
+	})
+
+	entity := EventfulEntity{Id: bson.NewObjectID()}
+	entity.Rename("retried")
+
+	attempts := 0
+	err := WithTransaction(ctx, s.Collection.Database().Client(), func(ctx context.Context) error {
+		attempts++
+		if _, err := repo.Save(ctx, entity); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			return mongo.CommandError{Code: 112, Name: "WriteConflict", Labels: []string{"TransientTransactionError"}}
+		}
+		return nil
+	})
+	s.Nil(err)
+	s.Equal(2, attempts)
+	s.Equal([]string{"retried"}, renamed)
+
+	// a transaction failing for good leaves the events with the entity
+	failed := EventfulEntity{Id: bson.NewObjectID()}
+	failed.Rename("kept")
+	err = WithTransaction(ctx, s.Collection.Database().Client(), func(ctx context.Context) error {
+		if _, err := repo.Save(ctx, failed); err != nil {
+			return err
+		}
+		return fmt.Errorf("abort")
+	})
+	s.NotNil(err)
+	s.Equal([]any{EntityRenamed{Name: "kept"}}, failed.PullEvents())
+}
+
+func TestEventsTestSuite(t *testing.T) {
+	suite.Run(t, new(EventsTestSuite))
+}
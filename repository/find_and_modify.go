@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// FindOneAndUpdate atomically update one document and return it.
+// The document before the update is returned unless options.After is requested; upsert, sort and
+// projection are set through the options as well. Returns ErrNotFound when nothing matched, which
+// includes an upsert that inserted while the document before the update was requested.
+func (p MongoRepository[T]) FindOneAndUpdate(ctx context.Context, filter bson.M, update bson.M, opts ...options.Lister[options.FindOneAndUpdateOptions]) (*T, error) {
+	result := p.Collection.FindOneAndUpdate(ctx, filter, update, opts...)
+
+	if p.History != nil {
+		resolved := resolveOptions(opts)
+		if err := p.recordModified(ctx, result, filter, HistoryUpdate, resolved.ReturnDocument, resolved.Projection, resolved.Upsert); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.decodeSingleResult(result, "update", filter)
+}
+
+// FindOneAndReplace atomically replace one document and return it.
+// Options work as for FindOneAndUpdate.
+func (p MongoRepository[T]) FindOneAndReplace(ctx context.Context, filter bson.M, replacement T, opts ...options.Lister[options.FindOneAndReplaceOptions]) (*T, error) {
+	replacement = withSchemaVersion(replacement)
+	if err := p.validate(ctx, replacement); err != nil {
+		return nil, err
+	}
+
+	result := p.Collection.FindOneAndReplace(ctx, filter, replacement, opts...)
+
+	if p.History != nil {
+		resolved := resolveOptions(opts)
+		if err := p.recordModified(ctx, result, filter, HistoryReplace, resolved.ReturnDocument, resolved.Projection, resolved.Upsert); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.decodeSingleResult(result, "replace", filter)
+}
+
+// FindOneAndDelete atomically delete one document and return it.
+// Returns ErrNotFound when nothing matched.
+func (p MongoRepository[T]) FindOneAndDelete(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOneAndDeleteOptions]) (*T, error) {
+	p.Upgrader = p.Upgrader.withoutWriteBack()
+
+	raw, err := p.Collection.FindOneAndDelete(ctx, filter, opts...).Raw()
+	if err != nil {
+		return nil, p.logFindAndModifyError(err, "delete", filter)
+	}
+
+	if id, ok := raw.Lookup("_id").ObjectIDOK(); ok {
+		if err := p.recordHistory(ctx, id, HistoryDelete, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	upgraded, err := p.upgrade(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var entity T
+	if err := bson.Unmarshal(upgraded, &entity); err != nil {
+		return nil, err
+	}
+
+	if p.ChangeTracker != nil {
+		p.ChangeTracker.Forget(entity.GetId())
+	}
+
+	return &entity, nil
+}
+
+// decodeSingleResult decode the document a find-and-modify returned
+func (p MongoRepository[T]) decodeSingleResult(result *mongo.SingleResult, operation string, filter bson.M) (*T, error) {
+	raw, err := result.Raw()
+	if err != nil {
+		return nil, p.logFindAndModifyError(err, operation, filter)
+	}
+
+	return p.decodeModified(raw)
+}
+
+// recordModified record the state a find and modify left its document in.
+// The returned document is recorded when it is the whole document after the write; otherwise the
+// document is read again, which outside a transaction may see a concurrent write made in between.
+// An upsert that returned the document before the write and found none inserted one, which is read
+// by filter. With options.After an upsert that inserted cannot be told apart from an update and is
+// recorded as operation.
+func (p MongoRepository[T]) recordModified(ctx context.Context, result *mongo.SingleResult, filter bson.M, operation HistoryOperation, returned *options.ReturnDocument, projection any, upsert *bool) error {
+	raw, err := result.Raw()
+	complete := returned != nil && *returned == options.After && projection == nil
+
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		if upsert == nil || !*upsert || (returned != nil && *returned == options.After) {
+			return nil
+		}
+		if raw, err = p.Collection.FindOne(ctx, filter).Raw(); err != nil {
+			return notFound(err)
+		}
+		operation = HistoryInsert
+	case err != nil:
+		// the error is returned when the result is decoded
+		return nil
+	case !complete:
+		if raw, err = p.Collection.FindOne(ctx, bson.M{"_id": raw.Lookup("_id")}).Raw(); err != nil {
+			return notFound(err)
+		}
+	}
+
+	id, ok := raw.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return nil
+	}
+	return p.recordHistory(ctx, id, operation, raw)
+}
+
+// decodeModified decode a document returned by a write. It is never written back after an upgrade,
+// as the write may have changed it since, and its snapshot is dropped, as it may be the document
+// before the write.
+func (p MongoRepository[T]) decodeModified(raw bson.Raw) (*T, error) {
+	tracker := p.ChangeTracker
+	p.ChangeTracker = nil
+	p.Upgrader = p.Upgrader.withoutWriteBack()
+
+	var entity T
+	if err := p.decode(raw, &entity); err != nil {
+		return nil, err
+	}
+
+	if tracker != nil {
+		tracker.Forget(entity.GetId())
+	}
+
+	return &entity, nil
+}
+
+func (p MongoRepository[T]) logFindAndModifyError(err error, operation string, filter bson.M) error {
+	err = notFound(err)
+	if !errors.Is(err, ErrNotFound) {
+		log.WithError(err).WithFields(log.Fields{
+			"operation": operation,
+			"filter":    filter,
+		}).Error("find and modify failed on ", p.Collection.Name())
+	}
+	return err
+}
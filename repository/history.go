@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ErrHistoryDisabled returned by the history finders of a repository without History
+var ErrHistoryDisabled = errors.New("history is not enabled for this repository")
+
+// HistoryOperation the write a history entry records
+type HistoryOperation string
+
+const (
+	HistoryInsert  HistoryOperation = "insert"
+	HistoryUpdate  HistoryOperation = "update"
+	HistoryReplace HistoryOperation = "replace"
+	HistoryDelete  HistoryOperation = "delete"
+)
+
+// HistoryEntry one version of an entity. Document holds the state after the operation and is
+// nil for deletes.
+type HistoryEntry[T base_entity.Entity] struct {
+	Id        bson.ObjectID    `bson:"_id"`
+	EntityId  bson.ObjectID    `bson:"entity_id"`
+	Version   int64            `bson:"version"`
+	Operation HistoryOperation `bson:"operation"`
+	Actor     string           `bson:"actor,omitempty"`
+	Timestamp time.Time        `bson:"timestamp"`
+	Document  *T               `bson:"document"`
+}
+
+type historyRecord struct {
+	Id        bson.ObjectID    `bson:"_id"`
+	EntityId  bson.ObjectID    `bson:"entity_id"`
+	Version   int64            `bson:"version"`
+	Operation HistoryOperation `bson:"operation"`
+	Actor     string           `bson:"actor,omitempty"`
+	Timestamp time.Time        `bson:"timestamp"`
+	// Document is left out for deletes, as an empty bson.Raw cannot be marshalled
+	Document bson.Raw `bson:"document,omitempty"`
+}
+
+// History keeps a versioned snapshot of every write in a companion collection.
+// Assign one to MongoRepository.History to opt in. A failure to record history is returned from
+// the write that caused it; run writes inside WithTransaction to roll both back together.
+type History struct {
+	Collection *mongo.Collection
+}
+
+// NewHistory create a history backed by the <collection>_history companion collection
+func NewHistory(collection *mongo.Collection) *History {
+	return &History{Collection: collection.Database().Collection(collection.Name() + "_history")}
+}
+
+// EnsureIndexes create the unique entity_id/version index that orders and guards versions
+func (h *History) EnsureIndexes(ctx context.Context) error {
+	_, err := h.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "entity_id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetName("entity_id_version").SetUnique(true),
+	})
+	return err
+}
+
+type actorKey struct{}
+
+// WithActor attach the actor recorded in history entries for writes made with the context
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext the actor set with WithActor, or "" when none is set
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// record append the next version for the entity, retrying when a concurrent writer took it
+func (h *History) record(ctx context.Context, entityId bson.ObjectID, operation HistoryOperation, document any) error {
+	var raw bson.Raw
+	if document != nil {
+		var err error
+		if raw, err = bson.Marshal(document); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var version int64
+		if version, err = h.nextVersion(ctx, entityId); err != nil {
+			break
+		}
+
+		_, err = h.Collection.InsertOne(ctx, historyRecord{
+			Id:        bson.NewObjectID(),
+			EntityId:  entityId,
+			Version:   version,
+			Operation: operation,
+			Actor:     ActorFromContext(ctx),
+			Timestamp: time.Now().UTC().Truncate(time.Millisecond),
+			Document:  raw,
+		})
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not record history for entity with ID %s: %w", entityId.Hex(), err)
+	}
+	return nil
+}
+
+func (h *History) nextVersion(ctx context.Context, entityId bson.ObjectID) (int64, error) {
+	var last struct {
+		Version int64 `bson:"version"`
+	}
+
+	opts := options.FindOne().
+		SetSort(bson.M{"version": -1}).
+		SetProjection(bson.M{"version": 1})
+
+	err := h.Collection.FindOne(ctx, bson.M{"entity_id": entityId}, opts).Decode(&last)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, err
+	}
+
+	return last.Version + 1, nil
+}
+
+// recordHistory record a write when history is enabled
+func (p MongoRepository[T]) recordHistory(ctx context.Context, entityId bson.ObjectID, operation HistoryOperation, document any) error {
+	if p.History == nil {
+		return nil
+	}
+	return p.History.record(ctx, entityId, operation, document)
+}
+
+// FindHistory list every recorded version of an entity, oldest first
+func (p MongoRepository[T]) FindHistory(ctx context.Context, id bson.ObjectID) ([]*HistoryEntry[T], error) {
+	if p.History == nil {
+		return nil, ErrHistoryDisabled
+	}
+
+	cursor, err := p.History.Collection.Find(ctx, bson.M{"entity_id": id}, options.Find().SetSort(bson.M{"version": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*HistoryEntry[T]
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// FindAsOf reconstruct an entity as it was at the given time.
+// Returns ErrNotFound when it did not exist yet or had been deleted by then.
+func (p MongoRepository[T]) FindAsOf(ctx context.Context, id bson.ObjectID, at time.Time) (*T, error) {
+	if p.History == nil {
+		return nil, ErrHistoryDisabled
+	}
+
+	filter := bson.M{
+		"entity_id": id,
+		"timestamp": bson.M{"$lte": at},
+	}
+
+	var entry HistoryEntry[T]
+	err := p.History.Collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.M{"version": -1})).Decode(&entry)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if entry.Operation == HistoryDelete || entry.Document == nil {
+		return nil, fmt.Errorf("entity with ID %s was deleted at %s: %w", id.Hex(), entry.Timestamp, ErrNotFound)
+	}
+
+	return entry.Document, nil
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestWithActor(t *testing.T) {
+	assert.Equal(t, "", ActorFromContext(context.Background()))
+	assert.Equal(t, "alice", ActorFromContext(WithActor(context.Background(), "alice")))
+}
+
+func TestUpsertOperation(t *testing.T) {
+	assert.Equal(t, HistoryInsert, upsertOperation(1))
+	assert.Equal(t, HistoryReplace, upsertOperation(0))
+}
+
+func TestHistoryRecord_MarshalDelete(t *testing.T) {
+	record := historyRecord{
+		Id:        bson.NewObjectID(),
+		EntityId:  bson.NewObjectID(),
+		Version:   3,
+		Operation: HistoryDelete,
+		Timestamp: time.Now(),
+	}
+
+	raw, err := bson.Marshal(record)
+	assert.Nil(t, err)
+	_, err = bson.Raw(raw).LookupErr("document")
+	assert.NotNil(t, err)
+
+	var entry HistoryEntry[TestEntity]
+	assert.Nil(t, bson.Unmarshal(raw, &entry))
+	assert.Equal(t, HistoryDelete, entry.Operation)
+	assert.Nil(t, entry.Document)
+}
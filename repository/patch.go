@@ -0,0 +1,528 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hub1989/mongo-data/v4/internal/bsonfield"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Optional a patch field that tells "absent" apart from "null".
+// An unset Optional is skipped, a null one removes the field and any other value is written.
+// Decoding JSON into a struct of Optionals yields exactly that: missing keys stay unset.
+type Optional[V any] struct {
+	Value V
+	Set   bool
+	Null  bool
+}
+
+// Some an Optional holding a value
+func Some[V any](value V) Optional[V] {
+	return Optional[V]{Value: value, Set: true}
+}
+
+// Null an Optional that removes the field
+func Null[V any]() Optional[V] {
+	return Optional[V]{Set: true, Null: true}
+}
+
+// UnmarshalJSON mark the Optional as set, and as null for a JSON null
+func (o *Optional[V]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		o.Null = true
+		return nil
+	}
+
+	return json.Unmarshal(data, &o.Value)
+}
+
+func (o Optional[V]) patchValue() (value any, set bool, null bool) {
+	return o.Value, o.Set, o.Null
+}
+
+type optionalField interface {
+	patchValue() (value any, set bool, null bool)
+}
+
+// JSONPatchOperation one operation of an RFC 6902 JSON Patch document.
+// Only add, replace and remove are supported. Adding at an array index inserts before the item
+// there and a trailing "/-" appends; removing an array item by index is not supported, replace
+// the array instead.
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch apply a partial update to the document with the given id and return the updated entity.
+// patch can be
+//   - a struct (or pointer to one) whose nil pointers and unset Optional fields are skipped
+//   - a JSON merge patch (RFC 7396) as []byte, json.RawMessage or map[string]any
+//   - a JSON Patch (RFC 6902) as []JSONPatchOperation, or as []byte holding a JSON array
+//
+// Every path is validated against the bson fields of T before anything is written, and a patch
+// writing both a field and a field nested in it, e.g. address and address.city, is rejected. When entities
+// are validated, the patch is applied to the stored document first and only written if the result
+// is valid and the document has not changed since. Patches are recorded in the history and
+// publish the domain events of the updated entity like Update.
+// Returns ErrNotFound when no document has the id.
+func (p MongoRepository[T]) Patch(ctx context.Context, id bson.ObjectID, patch any) (*T, error) {
+	update, err := buildPatch(reflect.TypeFor[T](), patch)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(update) == 0 {
+		return p.FindById(ctx, id)
+	}
+
+	validating := p.validates(ctx)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	for attempt := 1; ; attempt++ {
+		filter := bson.M{"_id": id}
+		if validating {
+			before, err := p.validatePatch(ctx, id, update)
+			if err != nil {
+				return nil, err
+			}
+			filter["$expr"] = bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": before}}}
+		}
+
+		raw, err := p.Collection.FindOneAndUpdate(ctx, filter, update, opts).Raw()
+		if validating && errors.Is(err, mongo.ErrNoDocuments) {
+			// changed or deleted since it was validated: validate again
+			if attempt < patchAttempts {
+				continue
+			}
+			return nil, fmt.Errorf("could not patch entity with ID %s: it kept changing concurrently", id.Hex())
+		}
+		if err != nil {
+			return nil, p.logFindAndModifyError(err, "patch", filter)
+		}
+
+		entity, err := p.decodeModified(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.recordHistory(ctx, id, HistoryUpdate, raw); err != nil {
+			return nil, err
+		}
+
+		p.snapshot(*entity)
+		p.publishEvents(ctx, entity)
+		return entity, nil
+	}
+}
+
+// patchAttempts how often Patch validates again when the document changes concurrently
+const patchAttempts = 3
+
+// validatePatch validate the entity the update would turn the stored document into, returning the
+// stored document it was validated against
+func (p MongoRepository[T]) validatePatch(ctx context.Context, id bson.ObjectID, update bson.M) (bson.Raw, error) {
+	before, err := p.Collection.FindOne(ctx, bson.M{"_id": id}).Raw()
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	after, err := applyUpdate(before, update)
+	if err != nil {
+		return nil, fmt.Errorf("could not apply patch to entity with ID %s: %w", id.Hex(), err)
+	}
+
+	p.ChangeTracker = nil
+	p.Upgrader = p.Upgrader.withoutWriteBack()
+
+	var entity T
+	if err := p.decode(after, &entity); err != nil {
+		return nil, err
+	}
+
+	return before, p.validate(ctx, entity)
+}
+
+// patchBuilder collect $set, $unset and $push operations, keeping the last operation per path
+// except for pushes, which combine
+type patchBuilder struct {
+	target reflect.Type
+	paths  []string
+	ops    map[string]bson.E
+}
+
+func buildPatch(target reflect.Type, patch any) (bson.M, error) {
+	builder := &patchBuilder{target: target, ops: make(map[string]bson.E)}
+
+	var err error
+	switch value := patch.(type) {
+	case nil:
+		return nil, nil
+	case []JSONPatchOperation:
+		err = builder.jsonPatch(value)
+	case json.RawMessage:
+		err = builder.jsonDocument(value)
+	case []byte:
+		err = builder.jsonDocument(value)
+	case map[string]any:
+		err = builder.mergePatch("", value)
+	default:
+		err = builder.structPatch(reflect.ValueOf(patch))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return builder.update(), nil
+}
+
+func (b *patchBuilder) jsonDocument(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var operations []JSONPatchOperation
+		if err := json.Unmarshal(trimmed, &operations); err != nil {
+			return fmt.Errorf("invalid JSON patch: %w", err)
+		}
+		return b.jsonPatch(operations)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+
+	var document map[string]any
+	if err := decoder.Decode(&document); err != nil {
+		return fmt.Errorf("invalid JSON merge patch: %w", err)
+	}
+
+	return b.mergePatch("", document)
+}
+
+func (b *patchBuilder) structPatch(value reflect.Value) error {
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported patch type %s", value.Type())
+	}
+
+	for _, field := range bsonfield.Fields(value.Type()) {
+		fieldValue := value.FieldByIndex(field.Index)
+
+		if optional, ok := fieldValue.Interface().(optionalField); ok {
+			optionalValue, set, null := optional.patchValue()
+			switch {
+			case !set:
+				continue
+			case null:
+				if err := b.unset(field.Name); err != nil {
+					return err
+				}
+			default:
+				if err := b.set(field.Name, optionalValue); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if fieldValue.Kind() == reflect.Pointer {
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+		} else if field.OmitEmpty && fieldValue.IsZero() {
+			continue
+		}
+
+		if err := b.set(field.Name, fieldValue.Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *patchBuilder) mergePatch(prefix string, document map[string]any) error {
+	for _, key := range slices.Sorted(maps.Keys(document)) {
+		value := document[key]
+		path := joinPath(prefix, key)
+
+		if value == nil {
+			if err := b.unset(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if nested, ok := value.(map[string]any); ok && b.mergesInto(path) {
+			if err := b.mergePatch(path, nested); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := b.setJSON(path, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mergesInto report whether a merge patch object at path merges into the existing value
+// rather than replacing it, which is the case for embedded structs and maps
+func (b *patchBuilder) mergesInto(path string) bool {
+	fieldType, ok := bsonfield.Resolve(b.target, path)
+	if !ok {
+		return false
+	}
+
+	kind := bsonfield.Indirect(fieldType).Kind()
+	return kind == reflect.Struct || kind == reflect.Map
+}
+
+func (b *patchBuilder) jsonPatch(operations []JSONPatchOperation) error {
+	for _, operation := range operations {
+		path, err := pointerToPath(operation.Path)
+		if err != nil {
+			return err
+		}
+
+		switch operation.Op {
+		case "add", "replace":
+			if operation.Value == nil {
+				return fmt.Errorf("JSON patch %s of %q has no value", operation.Op, operation.Path)
+			}
+
+			if array, index, ok := b.arrayIndex(path); ok && operation.Op == "add" {
+				err = b.push(array, &index, operation.Value)
+			} else if strings.HasSuffix(path, ".-") && operation.Op == "add" {
+				err = b.push(strings.TrimSuffix(path, ".-"), nil, operation.Value)
+			} else {
+				err = b.setJSON(path, operation.Value)
+			}
+		case "remove":
+			if array, _, ok := b.arrayIndex(path); ok {
+				// $unset would leave a null in place of the item
+				err = fmt.Errorf("JSON patch cannot remove array item %q, replace %q instead", operation.Path, array)
+			} else {
+				err = b.unset(path)
+			}
+		default:
+			err = fmt.Errorf("unsupported JSON patch operation %q", operation.Op)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setJSON convert a decoded or raw JSON value into the Go type of the target field before writing,
+// so that ObjectIDs, dates and integers keep their BSON types
+func (b *patchBuilder) setJSON(path string, value any) error {
+	fieldType, err := b.validate(path)
+	if err != nil {
+		return err
+	}
+
+	typed, err := convertJSON(value, fieldType)
+	if err != nil {
+		return fmt.Errorf("invalid value for %q: %w", path, err)
+	}
+
+	return b.record(path, bson.E{Key: "$set", Value: typed})
+}
+
+// arrayPush the items pushed to one array, appended when position is nil
+type arrayPush struct {
+	values   bson.A
+	position *int
+}
+
+// push append an item to the array at path, or insert it at position. Several items can be pushed
+// to one array as long as they end up next to each other.
+func (b *patchBuilder) push(path string, position *int, raw json.RawMessage) error {
+	fieldType, err := b.validate(path)
+	if err != nil {
+		return err
+	}
+
+	fieldType = bsonfield.Indirect(fieldType)
+	if fieldType.Kind() != reflect.Slice && fieldType.Kind() != reflect.Array {
+		return fmt.Errorf("cannot append to non-array field %q", path)
+	}
+
+	typed, err := convertJSON(raw, fieldType.Elem())
+	if err != nil {
+		return fmt.Errorf("invalid value for %q: %w", path, err)
+	}
+
+	pushed := &arrayPush{values: bson.A{typed}, position: position}
+	if existing, ok := b.ops[path]; ok && existing.Key == "$push" {
+		previous := existing.Value.(*arrayPush)
+		switch {
+		case previous.position == nil && position == nil:
+		case previous.position != nil && position != nil && *position == *previous.position+len(previous.values):
+		default:
+			return fmt.Errorf("JSON patch inserts into %q at positions that cannot be combined", path)
+		}
+		pushed = &arrayPush{values: append(previous.values, typed), position: previous.position}
+	}
+
+	return b.record(path, bson.E{Key: "$push", Value: pushed})
+}
+
+// arrayIndex split a path ending in an array index into the array's path and the index
+func (b *patchBuilder) arrayIndex(path string) (string, int, bool) {
+	separator := strings.LastIndex(path, ".")
+	if separator < 0 {
+		return "", 0, false
+	}
+
+	array, segment := path[:separator], path[separator+1:]
+	index, err := strconv.Atoi(segment)
+	if err != nil || index < 0 || strconv.Itoa(index) != segment {
+		return "", 0, false
+	}
+
+	fieldType, ok := bsonfield.Resolve(b.target, array)
+	if !ok {
+		return "", 0, false
+	}
+
+	kind := bsonfield.Indirect(fieldType).Kind()
+	return array, index, kind == reflect.Slice || kind == reflect.Array
+}
+
+func (b *patchBuilder) set(path string, value any) error {
+	if _, err := b.validate(path); err != nil {
+		return err
+	}
+
+	return b.record(path, bson.E{Key: "$set", Value: value})
+}
+
+func (b *patchBuilder) unset(path string) error {
+	if _, err := b.validate(path); err != nil {
+		return err
+	}
+
+	return b.record(path, bson.E{Key: "$unset", Value: ""})
+}
+
+// record the operation for path, replacing an earlier one for the same path. Paths nested in one
+// another cannot be written by one update, so the patch is rejected instead of the server refusing it.
+func (b *patchBuilder) record(path string, operation bson.E) error {
+	if _, ok := b.ops[path]; !ok {
+		for _, other := range b.paths {
+			if strings.HasPrefix(path, other+".") || strings.HasPrefix(other, path+".") {
+				return fmt.Errorf("patch writes both %q and %q, which overlap", other, path)
+			}
+		}
+		b.paths = append(b.paths, path)
+	}
+	b.ops[path] = operation
+	return nil
+}
+
+func (b *patchBuilder) validate(path string) (reflect.Type, error) {
+	if path == "_id" || strings.HasPrefix(path, "_id.") {
+		return nil, errors.New("cannot patch _id")
+	}
+
+	fieldType, ok := bsonfield.Resolve(b.target, path)
+	if !ok {
+		return nil, fmt.Errorf("patch field %q is not a field of %s", path, bsonfield.Indirect(b.target).Name())
+	}
+
+	return fieldType, nil
+}
+
+func (b *patchBuilder) update() bson.M {
+	update := bson.M{}
+	for _, path := range b.paths {
+		operation := b.ops[path]
+		if pushed, ok := operation.Value.(*arrayPush); ok {
+			operation.Value = pushed.update()
+		}
+
+		fields, _ := update[operation.Key].(bson.D)
+		update[operation.Key] = append(fields, bson.E{Key: path, Value: operation.Value})
+	}
+
+	return update
+}
+
+// update the $push value of the items: the item itself for a single append
+func (a *arrayPush) update() any {
+	if a.position == nil && len(a.values) == 1 {
+		return a.values[0]
+	}
+
+	push := bson.D{{Key: "$each", Value: a.values}}
+	if a.position != nil {
+		push = append(push, bson.E{Key: "$position", Value: *a.position})
+	}
+	return push
+}
+
+func convertJSON(value any, target reflect.Type) (any, error) {
+	raw, ok := value.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	if target.Kind() == reflect.Interface {
+		var generic any
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&generic); err != nil {
+			return nil, err
+		}
+		return generic, nil
+	}
+
+	typed := reflect.New(target)
+	if err := json.Unmarshal(raw, typed.Interface()); err != nil {
+		return nil, err
+	}
+
+	return typed.Elem().Interface(), nil
+}
+
+// pointerToPath convert an RFC 6901 JSON pointer into a dotted BSON path
+func pointerToPath(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") || pointer == "/" {
+		return "", fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	segments := strings.Split(pointer[1:], "/")
+	for i, segment := range segments {
+		segment = strings.ReplaceAll(segment, "~1", "/")
+		segments[i] = strings.ReplaceAll(segment, "~0", "~")
+	}
+
+	return strings.Join(segments, "."), nil
+}
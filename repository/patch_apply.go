@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// applyUpdate apply the $set, $unset and $push operations of a patch to a document the way the
+// server would, so that the result can be validated before it is written
+func applyUpdate(document bson.Raw, update bson.M) (bson.Raw, error) {
+	root, err := decodeGeneric(document)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, operator := range []string{"$set", "$unset", "$push"} {
+		fields, _ := update[operator].(bson.D)
+		for _, field := range fields {
+			if err := applyOperation(root, operator, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return bson.Marshal(root)
+}
+
+func applyOperation(root bson.M, operator string, field bson.E) error {
+	segments := strings.Split(field.Key, ".")
+
+	switch operator {
+	case "$unset":
+		_, err := updatePath(root, segments, false, func(any, bool) (any, bool, error) {
+			return nil, true, nil
+		})
+		return err
+	case "$set":
+		value, err := normalize(field.Value)
+		if err != nil {
+			return err
+		}
+
+		_, err = updatePath(root, segments, true, func(any, bool) (any, bool, error) {
+			return value, false, nil
+		})
+		return err
+	}
+
+	values, position := bson.A{field.Value}, -1
+	if each, ok := field.Value.(bson.D); ok && len(each) > 0 && each[0].Key == "$each" {
+		values = each[0].Value.(bson.A)
+		if len(each) > 1 {
+			position = each[1].Value.(int)
+		}
+	}
+
+	items, err := normalize(values)
+	if err != nil {
+		return err
+	}
+
+	_, err = updatePath(root, segments, true, func(old any, exists bool) (any, bool, error) {
+		array, ok := old.(bson.A)
+		if exists && !ok {
+			return nil, false, fmt.Errorf("cannot push to non-array field %q", field.Key)
+		}
+
+		if position < 0 || position > len(array) {
+			position = len(array)
+		}
+		return append(array[:position:position], append(items.(bson.A), array[position:]...)...), false, nil
+	})
+	return err
+}
+
+// updatePath replace the value at the path below node with the one fn returns, or remove it.
+// Missing documents on the way are created when create is set, and the update is skipped otherwise.
+func updatePath(node any, segments []string, create bool, fn func(old any, exists bool) (value any, remove bool, err error)) (any, error) {
+	segment, rest := segments[0], segments[1:]
+
+	switch current := node.(type) {
+	case bson.M:
+		old, exists := current[segment]
+		if len(rest) > 0 {
+			if !exists {
+				if !create {
+					return current, nil
+				}
+				old = bson.M{}
+			}
+
+			value, err := updatePath(old, rest, create, fn)
+			if err != nil {
+				return nil, err
+			}
+			current[segment] = value
+			return current, nil
+		}
+
+		value, remove, err := fn(old, exists)
+		if err != nil {
+			return nil, err
+		}
+		if remove {
+			delete(current, segment)
+		} else {
+			current[segment] = value
+		}
+		return current, nil
+	case bson.A:
+		index, err := strconv.Atoi(segment)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("cannot use %q as an array index", segment)
+		}
+
+		exists := index < len(current)
+		var old any
+		if exists {
+			old = current[index]
+		}
+
+		var value any
+		if len(rest) > 0 {
+			if !exists {
+				if !create {
+					return current, nil
+				}
+				old = bson.M{}
+			}
+			if value, err = updatePath(old, rest, create, fn); err != nil {
+				return nil, err
+			}
+		} else {
+			var remove bool
+			if value, remove, err = fn(old, exists); err != nil {
+				return nil, err
+			}
+			// the server unsets array items to null, keeping the positions of the others
+			if remove {
+				if exists {
+					current[index] = nil
+				}
+				return current, nil
+			}
+		}
+
+		// the server pads arrays with nulls up to the index that is set
+		for len(current) <= index {
+			current = append(current, nil)
+		}
+		current[index] = value
+		return current, nil
+	}
+
+	if !create {
+		return node, nil
+	}
+	return nil, fmt.Errorf("cannot create field %q in a %T", segment, node)
+}
+
+// normalize convert a value into the documents and arrays of a decoded document
+func normalize(value any) (any, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "value", Value: value}})
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := decodeGeneric(raw)
+	if err != nil {
+		return nil, err
+	}
+	return document["value"], nil
+}
+
+// decodeGeneric decode a document with nested documents as bson.M
+func decodeGeneric(raw bson.Raw) (bson.M, error) {
+	decoder := bson.NewDecoder(bson.NewDocumentReader(bytes.NewReader(raw)))
+	decoder.DefaultDocumentM()
+
+	var document bson.M
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type patchAddress struct {
+	City string `bson:"city" json:"city"`
+	Zip  string `bson:"zip" json:"zip"`
+}
+
+type patchEntity struct {
+	Id      bson.ObjectID `bson:"_id"`
+	Name    string        `bson:"name"`
+	Age     int           `bson:"age"`
+	Address patchAddress  `bson:"address"`
+	Tags    []string      `bson:"tags"`
+	OwnerId bson.ObjectID `bson:"owner_id"`
+}
+
+func (p patchEntity) GetId() bson.ObjectID {
+	return p.Id
+}
+
+func (p patchEntity) SetId(id bson.ObjectID) {
+	p.Id = id
+}
+
+var patchTarget = reflect.TypeFor[patchEntity]()
+
+func TestBuildPatch_Struct(t *testing.T) {
+	name := "new name"
+	patch := struct {
+		Name *string          `bson:"name"`
+		Age  *int             `bson:"age"`
+		Tags Optional[[]int]  `bson:"tags"`
+		Zip  Optional[string] `bson:"address.zip"`
+	}{
+		Name: &name,
+		Tags: Null[[]int](),
+	}
+
+	update, err := buildPatch(patchTarget, patch)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{
+		"$set":   bson.D{{Key: "name", Value: "new name"}},
+		"$unset": bson.D{{Key: "tags", Value: ""}},
+	}, update)
+}
+
+func TestBuildPatch_StructFromJSON(t *testing.T) {
+	var patch struct {
+		Name Optional[string] `bson:"name" json:"name"`
+		Age  Optional[int]    `bson:"age" json:"age"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(`{"age": null}`), &patch))
+
+	update, err := buildPatch(patchTarget, patch)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$unset": bson.D{{Key: "age", Value: ""}}}, update)
+}
+
+func TestBuildPatch_MergePatch(t *testing.T) {
+	owner := bson.NewObjectID()
+	patch := []byte(`{"age": 42, "address": {"city": "Accra", "zip": null}, "owner_id": "` + owner.Hex() + `"}`)
+
+	update, err := buildPatch(patchTarget, patch)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{
+		"$set": bson.D{
+			{Key: "address.city", Value: "Accra"},
+			{Key: "age", Value: 42},
+			{Key: "owner_id", Value: owner},
+		},
+		"$unset": bson.D{{Key: "address.zip", Value: ""}},
+	}, update)
+}
+
+func TestBuildPatch_JSONPatch(t *testing.T) {
+	patch := []byte(`[
+		{"op": "replace", "path": "/name", "value": "renamed"},
+		{"op": "add", "path": "/tags/-", "value": "new"},
+		{"op": "remove", "path": "/address/zip"},
+		{"op": "replace", "path": "/address/city", "value": "Kumasi"}
+	]`)
+
+	update, err := buildPatch(patchTarget, patch)
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{
+		"$set":   bson.D{{Key: "name", Value: "renamed"}, {Key: "address.city", Value: "Kumasi"}},
+		"$push":  bson.D{{Key: "tags", Value: "new"}},
+		"$unset": bson.D{{Key: "address.zip", Value: ""}},
+	}, update)
+}
+
+func TestBuildPatch_RejectsUnknownFields(t *testing.T) {
+	_, err := buildPatch(patchTarget, map[string]any{"nickname": "x"})
+	assert.ErrorContains(t, err, `"nickname" is not a field of patchEntity`)
+
+	_, err = buildPatch(patchTarget, []byte(`{"address": {"country": "GH"}}`))
+	assert.ErrorContains(t, err, `"address.country"`)
+
+	_, err = buildPatch(patchTarget, map[string]any{"_id": "x"})
+	assert.ErrorContains(t, err, "cannot patch _id")
+
+	_, err = buildPatch(patchTarget, []JSONPatchOperation{{Op: "move", Path: "/name"}})
+	assert.ErrorContains(t, err, "unsupported JSON patch operation")
+}
+
+func TestBuildPatch_JSONPatchArrayIndexes(t *testing.T) {
+	update, err := buildPatch(patchTarget, []byte(`[
+		{"op": "add", "path": "/tags/1", "value": "b"},
+		{"op": "add", "path": "/tags/2", "value": "c"}
+	]`))
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{
+		"$push": bson.D{{Key: "tags", Value: bson.D{{Key: "$each", Value: bson.A{"b", "c"}}, {Key: "$position", Value: 1}}}},
+	}, update)
+
+	update, err = buildPatch(patchTarget, []byte(`[
+		{"op": "add", "path": "/tags/-", "value": "y"},
+		{"op": "add", "path": "/tags/-", "value": "z"}
+	]`))
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$push": bson.D{{Key: "tags", Value: bson.D{{Key: "$each", Value: bson.A{"y", "z"}}}}}}, update)
+
+	_, err = buildPatch(patchTarget, []byte(`[
+		{"op": "add", "path": "/tags/0", "value": "a"},
+		{"op": "add", "path": "/tags/3", "value": "d"}
+	]`))
+	assert.ErrorContains(t, err, "cannot be combined")
+
+	_, err = buildPatch(patchTarget, []byte(`[{"op": "remove", "path": "/tags/0"}]`))
+	assert.ErrorContains(t, err, `cannot remove array item "/tags/0"`)
+}
+
+func TestBuildPatch_JSONPatchRemoveArrayItem(t *testing.T) {
+	_, err := buildPatch(patchTarget, []JSONPatchOperation{{Op: "remove", Path: "/tags/2"}})
+	assert.EqualError(t, err, `JSON patch cannot remove array item "/tags/2", replace "tags" instead`)
+
+	// removing the array itself is fine
+	update, err := buildPatch(patchTarget, []JSONPatchOperation{{Op: "remove", Path: "/tags"}})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$unset": bson.D{{Key: "tags", Value: ""}}}, update)
+}
+
+func TestBuildPatch_RejectsOverlappingPaths(t *testing.T) {
+	_, err := buildPatch(patchTarget, []byte(`[
+		{"op": "replace", "path": "/address", "value": {"city": "Accra"}},
+		{"op": "replace", "path": "/address/city", "value": "Kumasi"}
+	]`))
+	assert.EqualError(t, err, `patch writes both "address" and "address.city", which overlap`)
+
+	_, err = buildPatch(patchTarget, []byte(`[
+		{"op": "add", "path": "/tags/-", "value": "new"},
+		{"op": "replace", "path": "/tags/0", "value": "first"}
+	]`))
+	assert.EqualError(t, err, `patch writes both "tags" and "tags.0", which overlap`)
+
+	// a later operation on the same path replaces the earlier one
+	update, err := buildPatch(patchTarget, []byte(`[
+		{"op": "replace", "path": "/name", "value": "first"},
+		{"op": "remove", "path": "/name"}
+	]`))
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$unset": bson.D{{Key: "name", Value: ""}}}, update)
+}
+
+func TestApplyUpdate(t *testing.T) {
+	id := bson.NewObjectID()
+	document, _ := bson.Marshal(bson.M{"_id": id, "name": "a", "age": 3, "tags": bson.A{"x", "z"}})
+
+	update, err := buildPatch(patchTarget, []byte(`[
+		{"op": "add", "path": "/tags/1", "value": "y"},
+		{"op": "replace", "path": "/address/city", "value": "Accra"},
+		{"op": "remove", "path": "/age"}
+	]`))
+	assert.Nil(t, err)
+
+	applied, err := applyUpdate(document, update)
+	assert.Nil(t, err)
+
+	var entity patchEntity
+	assert.Nil(t, bson.Unmarshal(applied, &entity))
+	assert.Equal(t, patchEntity{Id: id, Name: "a", Address: patchAddress{City: "Accra"}, Tags: []string{"x", "y", "z"}}, entity)
+
+	// like the server, unsetting an array item leaves a null
+	applied, err = applyUpdate(document, bson.M{"$unset": bson.D{{Key: "tags.0", Value: ""}}})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.TypeNull, applied.Lookup("tags", "0").Type)
+}
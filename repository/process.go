@@ -0,0 +1,437 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ErrTooManyFailures returned by ProcessAll once ProcessOptions.MaxFailures is reached
+var ErrTooManyFailures = errors.New("too many failures")
+
+// maxRecordedFailures caps ProcessReport.Failures and ProcessReport.SkippedIds; the Failed and
+// Skipped counters keep counting
+const maxRecordedFailures = 1000
+
+// ProcessOptions tunes ProcessAll
+type ProcessOptions struct {
+	// JobName identifies the run in the progress collection. Without it nothing is checkpointed.
+	JobName string
+	// ProgressCollection stores one checkpoint document per job. Defaults to <collection>_progress.
+	ProgressCollection *mongo.Collection
+	// Restart ignores an existing checkpoint and walks the collection from the start
+	Restart bool
+	// MaxFailures aborts the run once that many documents failed; 0 never aborts
+	MaxFailures int64
+	// OnProgress receives a snapshot of the report after every finished batch
+	OnProgress func(report ProcessReport)
+}
+
+// ProcessFailure a document fn returned an error for
+type ProcessFailure struct {
+	Id  bson.ObjectID
+	Err error
+}
+
+// ProcessReport the outcome of a ProcessAll run
+type ProcessReport struct {
+	Processed int64
+	Failed    int64
+	Failures  []ProcessFailure
+	// Skipped documents matching the filter whose _id is not an ObjectID. The collection is
+	// partitioned by ObjectID, so fn is never called for them.
+	Skipped    int64
+	SkippedIds []any
+	// LastId the checkpoint: every document up to and including it has been processed without
+	// failing. It stays below the first failed document, so the next run retries it.
+	LastId  bson.ObjectID
+	Resumed bool
+	Elapsed time.Duration
+}
+
+// Throughput processed documents per second
+func (r ProcessReport) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Processed+r.Failed) / r.Elapsed.Seconds()
+}
+
+type processProgress struct {
+	Job       string        `bson:"_id"`
+	LastId    bson.ObjectID `bson:"last_id"`
+	Processed int64         `bson:"processed"`
+	Failed    int64         `bson:"failed"`
+	Completed bool          `bson:"completed"`
+	UpdatedAt time.Time     `bson:"updated_at"`
+}
+
+// idRange the documents with from < _id <= to
+type idRange struct {
+	seq      int
+	from, to bson.ObjectID
+}
+
+// ProcessAll walk every document matching filter and call fn for each one on a pool of workers.
+// The collection is partitioned into _id ranges of batchSize documents which the workers claim one
+// at a time. A document fn fails for is recorded in the report without stopping the run.
+// With a JobName, the highest _id below which every document succeeded is checkpointed after each
+// batch and the next run with the same name resumes from there, processing the documents after a
+// failure again. Documents whose _id is not an ObjectID are reported as skipped.
+func (p MongoRepository[T]) ProcessAll(ctx context.Context, filter bson.M, workers int, batchSize int, fn func(ctx context.Context, entity *T) error, opts ...*ProcessOptions) (*ProcessReport, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if batchSize < 1 {
+		batchSize = 100
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	opt := mergeProcessOptions(opts)
+	started := time.Now()
+	report := &ProcessReport{}
+
+	progress, err := p.loadProgress(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if progress.Job != "" && !progress.LastId.IsZero() {
+		report.Resumed = true
+		report.LastId = progress.LastId
+	}
+
+	if err := p.skipped(ctx, filter, report); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		checkpoint = newCheckpointTracker(progress.LastId)
+		ranges     = make(chan idRange)
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for r := range ranges {
+				processed, failures, succeeded, err := p.processRange(ctx, filter, r, batchSize, fn)
+
+				mu.Lock()
+				report.Processed += processed
+				report.Failed += int64(len(failures))
+				for _, failure := range failures {
+					if len(report.Failures) < maxRecordedFailures {
+						report.Failures = append(report.Failures, failure)
+					}
+				}
+
+				if err != nil {
+					mu.Unlock()
+					cancel(err)
+					continue
+				}
+
+				if lastId, advanced := checkpoint.complete(r.seq, succeeded, len(failures) == 0); advanced {
+					report.LastId = lastId
+					if err := p.saveProgress(ctx, opt, progress, report, false); err != nil {
+						log.WithError(err).Warn("could not checkpoint job: ", opt.JobName)
+					}
+				}
+
+				if opt.MaxFailures > 0 && report.Failed >= opt.MaxFailures {
+					cancel(ErrTooManyFailures)
+				}
+
+				snapshot := *report
+				snapshot.Elapsed = time.Since(started)
+				mu.Unlock()
+
+				if opt.OnProgress != nil {
+					opt.OnProgress(snapshot)
+				}
+			}
+		}()
+	}
+
+	partitionErr := p.partition(ctx, filter, progress.LastId, batchSize, ranges)
+	close(ranges)
+	wg.Wait()
+
+	report.Elapsed = time.Since(started)
+
+	if err := context.Cause(ctx); err != nil {
+		return report, err
+	}
+	if partitionErr != nil {
+		return report, partitionErr
+	}
+
+	if err := p.saveProgress(ctx, opt, progress, report, report.Failed == 0); err != nil {
+		log.WithError(err).Warn("could not checkpoint job: ", opt.JobName)
+	}
+
+	log.WithFields(log.Fields{
+		"processed":  report.Processed,
+		"failed":     report.Failed,
+		"throughput": fmt.Sprintf("%.1f/s", report.Throughput()),
+	}).Info(fmt.Sprintf("processed %s entities", p.Collection.Name()))
+
+	return report, nil
+}
+
+// partition read the matching _ids in order and send a range every batchSize documents
+func (p MongoRepository[T]) partition(ctx context.Context, filter bson.M, after bson.ObjectID, batchSize int, ranges chan<- idRange) error {
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1}).
+		SetSort(bson.M{"_id": 1}).
+		SetBatchSize(int32(batchSize))
+
+	cursor, err := p.Collection.Find(ctx, rangeFilter(filter, after, bson.NilObjectID), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	current := idRange{from: after}
+	count := 0
+
+	send := func() bool {
+		select {
+		case ranges <- current:
+			current = idRange{seq: current.seq + 1, from: current.to}
+			count = 0
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for cursor.Next(ctx) {
+		// the range filter only matches ObjectIDs, the other _ids are reported by skipped
+		id, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+		if !ok {
+			continue
+		}
+
+		current.to = id
+		count++
+
+		if count == batchSize && !send() {
+			return nil
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		send()
+	}
+
+	return nil
+}
+
+// processRange stream one range through fn, collecting the documents it failed for.
+// succeeded is the _id up to which every document of the range succeeded: the end of the range, or
+// the last document before the first failure.
+func (p MongoRepository[T]) processRange(ctx context.Context, filter bson.M, r idRange, batchSize int, fn func(ctx context.Context, entity *T) error) (processed int64, failures []ProcessFailure, succeeded bson.ObjectID, err error) {
+	opts := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetBatchSize(int32(batchSize))
+
+	succeeded = r.from
+	for entity, err := range p.Stream(ctx, rangeFilter(filter, r.from, r.to), opts) {
+		if err != nil {
+			return processed, failures, succeeded, err
+		}
+
+		if err := fn(ctx, entity); err != nil {
+			failures = append(failures, ProcessFailure{Id: (*entity).GetId(), Err: err})
+			continue
+		}
+
+		processed++
+		if len(failures) == 0 {
+			succeeded = (*entity).GetId()
+		}
+	}
+
+	if len(failures) == 0 {
+		succeeded = r.to
+	}
+	return processed, failures, succeeded, nil
+}
+
+// skipped count the documents matching filter whose _id is not an ObjectID into the report
+func (p MongoRepository[T]) skipped(ctx context.Context, filter bson.M, report *ProcessReport) error {
+	notObjectId := bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$not": bson.M{"$type": "objectId"}}}}}
+
+	cursor, err := p.Collection.Find(ctx, notObjectId, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	for cursor.Next(ctx) {
+		report.Skipped++
+		if len(report.SkippedIds) < maxRecordedFailures {
+			var document struct {
+				Id any `bson:"_id"`
+			}
+			if err := cursor.Decode(&document); err != nil {
+				return err
+			}
+			report.SkippedIds = append(report.SkippedIds, document.Id)
+		}
+	}
+
+	if report.Skipped > 0 {
+		log.WithFields(log.Fields{
+			"skipped": report.Skipped,
+		}).Warn(fmt.Sprintf("skipping %s entities without an ObjectID _id", p.Collection.Name()))
+	}
+	return cursor.Err()
+}
+
+func rangeFilter(filter bson.M, from, to bson.ObjectID) bson.M {
+	bounds := bson.M{"$gt": from}
+	if !to.IsZero() {
+		bounds["$lte"] = to
+	}
+
+	return bson.M{
+		"$and": bson.A{filter, bson.M{"_id": bounds}},
+	}
+}
+
+func (p MongoRepository[T]) progressCollection(opt ProcessOptions) *mongo.Collection {
+	if opt.ProgressCollection != nil {
+		return opt.ProgressCollection
+	}
+	return p.Collection.Database().Collection(p.Collection.Name() + "_progress")
+}
+
+func (p MongoRepository[T]) loadProgress(ctx context.Context, opt ProcessOptions) (processProgress, error) {
+	if opt.JobName == "" {
+		return processProgress{}, nil
+	}
+
+	progress := processProgress{Job: opt.JobName}
+	if opt.Restart {
+		return progress, nil
+	}
+
+	err := p.progressCollection(opt).FindOne(ctx, bson.M{"_id": opt.JobName}).Decode(&progress)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return progress, err
+	}
+
+	return progress, nil
+}
+
+// saveProgress persist the checkpoint, adding this run's counts to the ones it resumed from
+func (p MongoRepository[T]) saveProgress(ctx context.Context, opt ProcessOptions, resumed processProgress, report *ProcessReport, completed bool) error {
+	if opt.JobName == "" {
+		return nil
+	}
+
+	progress := processProgress{
+		Job:       opt.JobName,
+		LastId:    report.LastId,
+		Processed: resumed.Processed + report.Processed,
+		Failed:    resumed.Failed + report.Failed,
+		Completed: completed,
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	_, err := p.progressCollection(opt).ReplaceOne(context.WithoutCancel(ctx), bson.M{"_id": opt.JobName}, progress, options.Replace().SetUpsert(true))
+	return err
+}
+
+func mergeProcessOptions(opts []*ProcessOptions) ProcessOptions {
+	var merged ProcessOptions
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.JobName != "" {
+			merged.JobName = opt.JobName
+		}
+		if opt.ProgressCollection != nil {
+			merged.ProgressCollection = opt.ProgressCollection
+		}
+		if opt.Restart {
+			merged.Restart = true
+		}
+		if opt.MaxFailures > 0 {
+			merged.MaxFailures = opt.MaxFailures
+		}
+		if opt.OnProgress != nil {
+			merged.OnProgress = opt.OnProgress
+		}
+	}
+	return merged
+}
+
+// checkpointTracker find the highest _id below which every document succeeded, as ranges finish
+// out of order across workers. The first range with a failure holds the checkpoint for good.
+type checkpointTracker struct {
+	next int
+	last bson.ObjectID
+	held bool
+	done map[int]completedRange
+}
+
+// completedRange the _id up to which a range succeeded, and whether that is its end
+type completedRange struct {
+	succeeded bson.ObjectID
+	whole     bool
+}
+
+func newCheckpointTracker(start bson.ObjectID) *checkpointTracker {
+	return &checkpointTracker{last: start, done: make(map[int]completedRange)}
+}
+
+// complete record that range seq finished, having succeeded up to the given _id, and return the
+// checkpoint and whether it moved
+func (c *checkpointTracker) complete(seq int, succeeded bson.ObjectID, whole bool) (bson.ObjectID, bool) {
+	if c.held {
+		return c.last, false
+	}
+	c.done[seq] = completedRange{succeeded: succeeded, whole: whole}
+
+	advanced := false
+	for !c.held {
+		completed, ok := c.done[c.next]
+		if !ok {
+			break
+		}
+
+		delete(c.done, c.next)
+		if completed.succeeded != c.last {
+			c.last = completed.succeeded
+			advanced = true
+		}
+		c.held = !completed.whole
+		c.next++
+	}
+
+	return c.last, advanced
+}
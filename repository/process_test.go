@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestCheckpointTracker_OutOfOrder(t *testing.T) {
+	start := bson.NewObjectID()
+	ids := []bson.ObjectID{bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()}
+	tracker := newCheckpointTracker(start)
+
+	last, advanced := tracker.complete(1, ids[1], true)
+	assert.False(t, advanced)
+	assert.Equal(t, start, last)
+
+	last, advanced = tracker.complete(0, ids[0], true)
+	assert.True(t, advanced)
+	assert.Equal(t, ids[1], last)
+
+	last, advanced = tracker.complete(2, ids[2], true)
+	assert.True(t, advanced)
+	assert.Equal(t, ids[2], last)
+}
+
+func TestCheckpointTracker_HoldsAtFailure(t *testing.T) {
+	start := bson.NewObjectID()
+	ids := []bson.ObjectID{bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()}
+	tracker := newCheckpointTracker(start)
+
+	// range 1 succeeded up to ids[1], then a handler failed
+	last, advanced := tracker.complete(1, ids[1], false)
+	assert.False(t, advanced)
+	assert.Equal(t, start, last)
+
+	last, advanced = tracker.complete(2, ids[3], true)
+	assert.False(t, advanced)
+	assert.Equal(t, start, last)
+
+	last, advanced = tracker.complete(0, ids[0], true)
+	assert.True(t, advanced)
+	assert.Equal(t, ids[1], last)
+
+	last, advanced = tracker.complete(3, bson.NewObjectID(), true)
+	assert.False(t, advanced)
+	assert.Equal(t, ids[1], last)
+}
+
+func TestCheckpointTracker_FailsFirstDocument(t *testing.T) {
+	start := bson.NewObjectID()
+	tracker := newCheckpointTracker(start)
+
+	// nothing succeeded in range 0, so the checkpoint stays where it was
+	last, advanced := tracker.complete(0, start, false)
+	assert.False(t, advanced)
+	assert.Equal(t, start, last)
+
+	last, advanced = tracker.complete(1, bson.NewObjectID(), true)
+	assert.False(t, advanced)
+	assert.Equal(t, start, last)
+}
+
+func TestProcessReport_Throughput(t *testing.T) {
+	assert.Equal(t, float64(0), ProcessReport{Processed: 10}.Throughput())
+	assert.Equal(t, float64(6), ProcessReport{Processed: 10, Failed: 2, Elapsed: 2 * time.Second}.Throughput())
+}
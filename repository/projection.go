@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/hub1989/mongo-data/v4/internal/bsonfield"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Projection derive a projection that includes the bson fields of P.
+// _id is excluded unless P declares it.
+func Projection[P any]() bson.D {
+	var projection bson.D
+	includesId := false
+
+	for _, field := range bsonfield.Fields(reflect.TypeFor[P]()) {
+		if field.Name == "_id" {
+			includesId = true
+		}
+		projection = append(projection, bson.E{Key: field.Name, Value: 1})
+	}
+
+	if !includesId {
+		projection = append(projection, bson.E{Key: "_id", Value: 0})
+	}
+
+	return projection
+}
+
+// FindProjected find documents by filter, fetching and decoding only the fields of P.
+// A projection passed in opts takes precedence over the derived one.
+// Documents at an older schema version are upgraded as FindEntityDocumentsByFilter does.
+func FindProjected[T base_entity.Entity, P any](repo MongoRepository[T], ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOptions]) ([]*P, error) {
+	findOptions := append([]options.Lister[options.FindOptions]{options.Find().SetProjection(projectionFor[T, P](repo))}, opts...)
+
+	cursor, err := repo.Collection.Find(ctx, filter, findOptions...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []*P
+	for cursor.Next(ctx) {
+		var result P
+		if err := decodeProjected(ctx, repo, cursor.Current, &result); err != nil {
+			return nil, err
+		}
+		results = append(results, &result)
+	}
+
+	return results, cursor.Err()
+}
+
+// FindOneProjected find 1 document by filter, fetching and decoding only the fields of P.
+// Returns ErrNotFound when no document matches.
+func FindOneProjected[T base_entity.Entity, P any](repo MongoRepository[T], ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOneOptions]) (*P, error) {
+	findOptions := append([]options.Lister[options.FindOneOptions]{options.FindOne().SetProjection(projectionFor[T, P](repo))}, opts...)
+
+	raw, err := repo.Collection.FindOne(ctx, filter, findOptions...).Raw()
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	var result P
+	if err := decodeProjected(ctx, repo, raw, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// projectionFor the projection of P, which also fetches the _id and schema version when documents
+// of T are upgraded, to tell which documents need it
+func projectionFor[T base_entity.Entity, P any](repo MongoRepository[T]) bson.D {
+	projection := Projection[P]()
+	if _, ok := repo.targetVersion(); !ok {
+		return projection
+	}
+
+	projection = slices.DeleteFunc(projection, func(e bson.E) bool {
+		return e.Key == "_id" || e.Key == base_entity.SchemaVersionField
+	})
+	return append(projection, bson.E{Key: "_id", Value: 1}, bson.E{Key: base_entity.SchemaVersionField, Value: 1})
+}
+
+// decodeProjected unmarshal a projected document into result. A document at an older schema version
+// is read whole and upgraded first, as its upgrades may need fields the projection left out; it is
+// neither written back nor change tracked.
+func decodeProjected[T base_entity.Entity, P any](ctx context.Context, repo MongoRepository[T], raw bson.Raw, result *P) error {
+	repo = repo.forRead(ctx, true)
+
+	if target, ok := repo.targetVersion(); ok {
+		if version, err := storedVersion(raw); err != nil || version < target {
+			id, err := raw.LookupErr("_id")
+			if err != nil {
+				return fmt.Errorf("cannot upgrade a projected document without its _id: %w", err)
+			}
+
+			if raw, err = repo.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Raw(); err != nil {
+				return notFound(err)
+			}
+			if raw, err = repo.upgrade(raw); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bson.Unmarshal(raw, result)
+}
+
+// resolveOptions apply option listers to a zero options struct
+func resolveOptions[O any](opts []options.Lister[O]) *O {
+	resolved := new(O)
+	for _, lister := range opts {
+		if lister == nil {
+			continue
+		}
+		for _, set := range lister.List() {
+			_ = set(resolved)
+		}
+	}
+	return resolved
+}
+
+func projectsFind(opts []options.Lister[options.FindOptions]) bool {
+	return resolveOptions(opts).Projection != nil
+}
+
+func projectsFindOne(opts []options.Lister[options.FindOneOptions]) bool {
+	return resolveOptions(opts).Projection != nil
+}
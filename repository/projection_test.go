@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type projectedSummary struct {
+	Name  string `bson:"name"`
+	Email string `bson:"email,omitempty"`
+}
+
+type projectedWithId struct {
+	Id   bson.ObjectID `bson:"_id"`
+	Name string        `bson:"name"`
+}
+
+func TestProjection(t *testing.T) {
+	assert.Equal(t, bson.D{
+		{Key: "name", Value: 1},
+		{Key: "email", Value: 1},
+		{Key: "_id", Value: 0},
+	}, Projection[projectedSummary]())
+
+	assert.Equal(t, bson.D{
+		{Key: "_id", Value: 1},
+		{Key: "name", Value: 1},
+	}, Projection[projectedWithId]())
+}
+
+func TestProjectsFind(t *testing.T) {
+	assert.False(t, projectsFind(nil))
+	assert.False(t, projectsFind([]options.Lister[options.FindOptions]{options.Find().SetLimit(1)}))
+	assert.True(t, projectsFind([]options.Lister[options.FindOptions]{options.Find().SetProjection(bson.M{"name": 1})}))
+	assert.True(t, projectsFindOne([]options.Lister[options.FindOneOptions]{options.FindOne().SetProjection(bson.M{"name": 1})}))
+}
+
+type projectedName struct {
+	FirstName string `bson:"firstName"`
+}
+
+func TestProjectionFor(t *testing.T) {
+	plain := MongoRepository[versionedEntity]{}
+	assert.Equal(t, bson.D{{Key: "firstName", Value: 1}, {Key: "_id", Value: 0}}, projectionFor[versionedEntity, projectedName](plain))
+
+	upgrading := MongoRepository[versionedEntity]{Upgrader: versionedUpgrader()}
+	assert.Equal(t, bson.D{
+		{Key: "firstName", Value: 1},
+		{Key: "_id", Value: 1},
+		{Key: "_schemaVersion", Value: 1},
+	}, projectionFor[versionedEntity, projectedName](upgrading))
+}
+
+func TestDecodeProjected_CurrentVersion(t *testing.T) {
+	repo := MongoRepository[versionedEntity]{Upgrader: versionedUpgrader(), ChangeTracker: NewChangeTracker()}
+
+	id := bson.NewObjectID()
+	raw, _ := bson.Marshal(bson.M{"_id": id, "firstName": "Ada", "_schemaVersion": 2})
+
+	var result projectedName
+	assert.Nil(t, decodeProjected(context.Background(), repo, raw, &result))
+	assert.Equal(t, "Ada", result.FirstName)
+	assert.False(t, repo.ChangeTracker.IsTracked(id))
+
+	// an outdated document needs its _id to be read whole
+	raw, _ = bson.Marshal(bson.M{"firstName": "Ada"})
+	assert.ErrorContains(t, decodeProjected(context.Background(), repo, raw, &result), "without its _id")
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Replace an existing document as a whole, removing fields the entity no longer has.
+// Returns ErrNotFound when no document has the entity's _id.
+func (p MongoRepository[T]) Replace(ctx context.Context, entity T) (*T, error) {
+	entity = withSchemaVersion(entity)
+	if err := p.validate(ctx, entity); err != nil {
+		return nil, err
+	}
+
+	res, err := p.Collection.ReplaceOne(ctx, bson.M{"_id": entity.GetId()}, entity)
+	if err != nil {
+		log.WithError(err).Error("could not replace entity with ID: ", entity.GetId())
+		return nil, duplicateKey(err)
+	}
+
+	if res.MatchedCount == 0 {
+		return nil, fmt.Errorf("could not replace entity with ID %s: %w", entity.GetId().Hex(), ErrNotFound)
+	}
+
+	if err := p.recordHistory(ctx, entity.GetId(), HistoryReplace, entity); err != nil {
+		return nil, err
+	}
+
+	p.snapshot(entity)
+	p.publishEvents(ctx, &entity)
+	return &entity, nil
+}
+
+// Upsert replace the document with the entity's _id, inserting it when absent
+func (p MongoRepository[T]) Upsert(ctx context.Context, entity T) (*base_entity.UpsertResult[T], error) {
+	entity = withSchemaVersion(entity)
+	if err := p.validate(ctx, entity); err != nil {
+		return nil, err
+	}
+
+	opts := options.Replace().SetUpsert(true)
+	res, err := p.Collection.ReplaceOne(ctx, bson.M{"_id": entity.GetId()}, entity, opts)
+	if err != nil {
+		log.WithError(err).Error("could not upsert entity with ID: ", entity.GetId())
+		return nil, duplicateKey(err)
+	}
+
+	log.WithFields(log.Fields{
+		"id":       entity.GetId(),
+		"inserted": res.UpsertedCount > 0,
+	}).Info(fmt.Sprintf("upserted %s entity", p.Collection.Name()))
+
+	if err := p.recordHistory(ctx, entity.GetId(), upsertOperation(res.UpsertedCount), entity); err != nil {
+		return nil, err
+	}
+
+	p.snapshot(entity)
+	p.publishEvents(ctx, &entity)
+	return &base_entity.UpsertResult[T]{Entity: &entity, Inserted: res.UpsertedCount > 0}, nil
+}
+
+// UpsertByFilter replace the document matching a natural key filter, inserting it when absent.
+// The entity's own _id is not written: a matched document keeps its _id and an inserted one gets
+// a new _id from the server, or the one the filter pins with an equality. The returned entity is
+// the document the write left, so that it carries the stored _id. Whether it was inserted is told
+// from a read of the matching _id before the write, which a concurrent upsert of the same key can
+// get wrong outside a transaction; the returned entity is always the one written.
+func (p MongoRepository[T]) UpsertByFilter(ctx context.Context, filter bson.M, entity T) (*base_entity.UpsertResult[T], error) {
+	entity = withSchemaVersion(entity)
+	if err := p.validate(ctx, entity); err != nil {
+		return nil, err
+	}
+
+	replacement, err := withoutId(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := p.Collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Raw()
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After)
+	raw, err := p.Collection.FindOneAndReplace(ctx, filter, replacement, opts).Raw()
+	if err != nil {
+		log.WithError(err).Error(fmt.Sprintf("could not upsert %s entity for filter: %v", p.Collection.Name(), filter))
+		return nil, duplicateKey(err)
+	}
+
+	stored, err := p.decodeModified(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted := existing == nil || !existing.Lookup("_id").Equal(raw.Lookup("_id"))
+	operation := HistoryReplace
+	if inserted {
+		operation = HistoryInsert
+	}
+
+	if err := p.recordHistory(ctx, (*stored).GetId(), operation, raw); err != nil {
+		return nil, err
+	}
+
+	p.snapshot(*stored)
+	p.publishEvents(ctx, &entity)
+	return &base_entity.UpsertResult[T]{Entity: stored, Inserted: inserted}, nil
+}
+
+func upsertOperation(upsertedCount int64) HistoryOperation {
+	if upsertedCount > 0 {
+		return HistoryInsert
+	}
+	return HistoryReplace
+}
+
+// withoutId marshal an entity into a document without its _id field
+func withoutId(entity any) (bson.D, error) {
+	raw, err := bson.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	var document bson.D
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	fields := document[:0]
+	for _, element := range document {
+		if element.Key != "_id" {
+			fields = append(fields, element)
+		}
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"iter"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Stream iterate the documents matching filter, decoding them one at a time from the cursor
+// instead of loading the whole result set into memory.
+// The cursor is closed when the loop ends or breaks early. A cancelled context ends the iteration
+// with the context's error. Use options.Find().SetBatchSize to tune how many documents each
+// round trip fetches. Streamed entities are never change tracked, so memory stays bounded however
+// many documents match.
+func (p MongoRepository[T]) Stream(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOptions]) iter.Seq2[*T, error] {
+	p = p.forRead(ctx, projectsFind(opts))
+	p.ChangeTracker = nil
+
+	return func(yield func(*T, error) bool) {
+		cursor, err := p.Collection.Find(ctx, filter, opts...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer cursor.Close(context.WithoutCancel(ctx))
+
+		for cursor.Next(ctx) {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+
+			var entity T
+			if err := p.decode(cursor.Current, &entity); err != nil {
+				if !yield(nil, err) {
+					return
+				}
+				continue
+			}
+
+			if !yield(&entity, nil) {
+				return
+			}
+		}
+
+		if err := cursor.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// ForEach call fn for every document matching filter, streaming them from the cursor.
+// Iteration stops at the first error, either from the cursor or returned by fn.
+func (p MongoRepository[T]) ForEach(ctx context.Context, filter bson.M, fn func(entity *T) error, opts ...options.Lister[options.FindOptions]) error {
+	for entity, err := range p.Stream(ctx, filter, opts...) {
+		if err != nil {
+			return err
+		}
+
+		if err := fn(entity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// WithTransaction run fn inside a transaction on client, committing when fn returns nil.
+// Repository calls made with the context passed to fn take part in the transaction.
+// When ctx already carries a session, fn joins it instead of starting a nested transaction.
+// Domain events dispatched inside fn are delivered after the commit, and dropped on abort. Events
+// pulled from entities are recorded back into them when the driver retries fn or the transaction
+// fails, so entities created or changed before fn keep them; changes fn makes are made again by the
+// retry.
+func WithTransaction(ctx context.Context, client *mongo.Client, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	pending := &pendingEvents{}
+	ctx = context.WithValue(ctx, pendingEventsKey{}, pending)
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		// the driver retries fn on transient errors, so only the last attempt's events count
+		pending.restore()
+		return nil, fn(ctx)
+	})
+
+	if err != nil {
+		pending.restore()
+		return err
+	}
+
+	pending.flush(context.WithoutCancel(ctx))
+	return nil
+}
@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/hub1989/mongo-data/v4/internal/bsonfield"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// ErrNoUpgrade returned when a document is at a schema version no upgrade is registered for
+var ErrNoUpgrade = errors.New("no upgrade registered")
+
+const defaultWriteBackTimeout = 10 * time.Second
+
+// UpgradeFunc upgrade a raw document by one schema version, changing it in place
+type UpgradeFunc func(document bson.M) error
+
+// Upgrader migrates documents of base_entity.SchemaVersioned entities lazily: documents stored
+// at an older schema version are upgraded while they are read, before they are decoded, so huge
+// collections do not need an offline migration.
+// Assign one to MongoRepository.Upgrader to opt in, and register every upgrade before the
+// repository is used. Entities that are not SchemaVersioned are decoded as they are.
+type Upgrader struct {
+	// WriteBack store upgraded documents in the background. The write only sets the fields the
+	// upgrade changed and is skipped when the stored document moved to another version in between.
+	// Documents read through projections, aggregations and find-and-modify are never written back.
+	WriteBack bool
+	// WriteBackTimeout bounds each write back. Defaults to 10 seconds.
+	WriteBackTimeout time.Duration
+
+	upgrades map[int]UpgradeFunc
+	pending  *sync.WaitGroup
+}
+
+// NewUpgrader create an upgrader without upgrades
+func NewUpgrader() *Upgrader {
+	return &Upgrader{upgrades: make(map[int]UpgradeFunc), pending: &sync.WaitGroup{}}
+}
+
+// Register add the upgrade of documents at version from to version from+1.
+// Registering a version again replaces its upgrade.
+func (u *Upgrader) Register(from int, upgrade UpgradeFunc) *Upgrader {
+	u.upgrades[from] = upgrade
+	return u
+}
+
+// Wait block until the pending write backs are done, e.g. before shutting down
+func (u *Upgrader) Wait() {
+	u.pending.Wait()
+}
+
+// Upgrade run the upgrades that bring a raw document to version to.
+// upgraded is nil when the document is at version to or newer already.
+func (u *Upgrader) Upgrade(raw bson.Raw, to int) (upgraded bson.Raw, err error) {
+	from, err := storedVersion(raw)
+	if err != nil || from >= to {
+		return nil, err
+	}
+
+	var document bson.M
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	for version := from; version < to; version++ {
+		upgrade, ok := u.upgrades[version]
+		if !ok {
+			return nil, fmt.Errorf("%w from schema version %d", ErrNoUpgrade, version)
+		}
+		if err := upgrade(document); err != nil {
+			return nil, fmt.Errorf("could not upgrade from schema version %d: %w", version, err)
+		}
+	}
+	document[base_entity.SchemaVersionField] = to
+
+	return bson.Marshal(document)
+}
+
+// withoutWriteBack a copy of the upgrader that does not write back, for reads whose documents
+// are partial or already gone
+func (u *Upgrader) withoutWriteBack() *Upgrader {
+	if u == nil || !u.WriteBack {
+		return u
+	}
+
+	readOnly := *u
+	readOnly.WriteBack = false
+	return &readOnly
+}
+
+// writeBack store the changes between the document as read and as upgraded in the background,
+// unless its stored version changed since it was read
+func (u *Upgrader) writeBack(collection *mongo.Collection, before, after bson.Raw) {
+	id, err := before.LookupErr("_id")
+	if err != nil {
+		return
+	}
+
+	update, err := diffDocuments(before, after)
+	if err != nil || len(update) == 0 {
+		return
+	}
+
+	filter := bson.D{{Key: "_id", Value: id}}
+	if version, err := before.LookupErr(base_entity.SchemaVersionField); err == nil {
+		filter = append(filter, bson.E{Key: base_entity.SchemaVersionField, Value: version})
+	} else {
+		filter = append(filter, bson.E{Key: base_entity.SchemaVersionField, Value: bson.M{"$exists": false}})
+	}
+
+	timeout := u.WriteBackTimeout
+	if timeout <= 0 {
+		timeout = defaultWriteBackTimeout
+	}
+
+	u.pending.Add(1)
+	go func() {
+		defer u.pending.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+			log.WithError(err).Warn("could not write back upgraded document with ID: ", id)
+			return
+		}
+		log.WithFields(log.Fields{
+			"id": id,
+		}).Debug(fmt.Sprintf("wrote back upgraded %s document", collection.Name()))
+	}()
+}
+
+// storedVersion the schema version of a raw document, 0 when it has none
+func storedVersion(raw bson.Raw) (int, error) {
+	value, err := raw.LookupErr(base_entity.SchemaVersionField)
+	if err != nil {
+		return 0, nil
+	}
+
+	version, ok := value.AsInt64OK()
+	if !ok {
+		return 0, fmt.Errorf("%s is a %s, not a number", base_entity.SchemaVersionField, value.Type)
+	}
+	return int(version), nil
+}
+
+// upgrade bring a raw document up to the schema version of T, writing it back when the Upgrader
+// asks for it. Documents that need no upgrade are returned as they are.
+func (p MongoRepository[T]) upgrade(raw bson.Raw) (bson.Raw, error) {
+	target, ok := p.targetVersion()
+	if !ok {
+		return raw, nil
+	}
+
+	upgraded, err := p.Upgrader.Upgrade(raw, target)
+	if err != nil {
+		return nil, err
+	}
+	if upgraded == nil {
+		return raw, nil
+	}
+
+	if p.Upgrader.WriteBack {
+		p.Upgrader.writeBack(p.Collection, raw, upgraded)
+	}
+	return upgraded, nil
+}
+
+// targetVersion the schema version documents of T are upgraded to, and false when they are not
+// upgraded at all
+func (p MongoRepository[T]) targetVersion() (int, bool) {
+	if p.Upgrader == nil {
+		return 0, false
+	}
+
+	var entity T
+	return schemaVersion(entity)
+}
+
+// schemaVersion the SchemaVersion of an entity, including one whose method has a pointer receiver,
+// and false when it is not base_entity.SchemaVersioned
+func schemaVersion(entity any) (int, bool) {
+	if versioned, ok := entity.(base_entity.SchemaVersioned); ok {
+		return versioned.SchemaVersion(), true
+	}
+	if entity == nil {
+		return 0, false
+	}
+
+	pointer := reflect.New(reflect.TypeOf(entity))
+	pointer.Elem().Set(reflect.ValueOf(entity))
+	if versioned, ok := pointer.Interface().(base_entity.SchemaVersioned); ok {
+		return versioned.SchemaVersion(), true
+	}
+	return 0, false
+}
+
+// withSchemaVersion the entity with its SchemaVersionField field set to its SchemaVersion, so that
+// every write stores documents at the current version and they are not upgraded again on read.
+// A pointer is set in place; entities that are not versioned are returned as they are.
+func withSchemaVersion[E any](entity E) E {
+	version, ok := schemaVersion(entity)
+	if !ok {
+		return entity
+	}
+
+	value := reflect.ValueOf(&entity).Elem()
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return entity
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return entity
+	}
+
+	field, ok := bsonfield.Lookup(value.Type(), base_entity.SchemaVersionField)
+	if !ok {
+		return entity
+	}
+
+	// a struct held in an interface is not addressable, so set a copy and put it back
+	if !value.CanAddr() {
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		if setVersion(copied, field.Index, version) {
+			reflect.ValueOf(&entity).Elem().Set(copied)
+		}
+		return entity
+	}
+
+	setVersion(value, field.Index, version)
+	return entity
+}
+
+func setVersion(value reflect.Value, index []int, version int) bool {
+	field, err := value.FieldByIndexErr(index)
+	if err != nil || !field.CanSet() || !field.CanInt() {
+		return false
+	}
+
+	field.SetInt(int64(version))
+	return true
+}
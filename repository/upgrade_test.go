@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type versionedEntity struct {
+	Id        bson.ObjectID `bson:"_id"`
+	FirstName string        `bson:"firstName"`
+	LastName  string        `bson:"lastName"`
+	Status    string        `bson:"status"`
+	Version   int           `bson:"_schemaVersion"`
+}
+
+func (e versionedEntity) GetId() bson.ObjectID {
+	return e.Id
+}
+
+func (e versionedEntity) SetId(id bson.ObjectID) {
+	e.Id = id
+}
+
+func (versionedEntity) SchemaVersion() int {
+	return 2
+}
+
+// versionedUpgrader splits name in version 1 and adds status in version 2
+func versionedUpgrader() *Upgrader {
+	return NewUpgrader().
+		Register(0, func(document bson.M) error {
+			name, _ := document["name"].(string)
+			first, last, _ := strings.Cut(name, " ")
+			document["firstName"], document["lastName"] = first, last
+			delete(document, "name")
+			return nil
+		}).
+		Register(1, func(document bson.M) error {
+			document["status"] = "active"
+			return nil
+		})
+}
+
+func TestUpgrader_Upgrade(t *testing.T) {
+	upgrader := versionedUpgrader()
+
+	raw, _ := bson.Marshal(bson.M{"_id": bson.NewObjectID(), "name": "Ada Lovelace"})
+	upgraded, err := upgrader.Upgrade(raw, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ada", upgraded.Lookup("firstName").StringValue())
+	assert.Equal(t, "active", upgraded.Lookup("status").StringValue())
+	assert.Equal(t, int64(2), upgraded.Lookup("_schemaVersion").AsInt64())
+	_, err = upgraded.LookupErr("name")
+	assert.NotNil(t, err)
+
+	current, _ := bson.Marshal(bson.M{"_schemaVersion": 2})
+	upgraded, err = upgrader.Upgrade(current, 2)
+	assert.Nil(t, err)
+	assert.Nil(t, upgraded)
+
+	future, _ := bson.Marshal(bson.M{"_schemaVersion": int64(3)})
+	upgraded, err = upgrader.Upgrade(future, 2)
+	assert.Nil(t, err)
+	assert.Nil(t, upgraded)
+
+	_, err = NewUpgrader().Upgrade(raw, 1)
+	assert.ErrorIs(t, err, ErrNoUpgrade)
+
+	broken := NewUpgrader().Register(0, func(bson.M) error { return errors.New("boom") })
+	_, err = broken.Upgrade(raw, 1)
+	assert.ErrorContains(t, err, "could not upgrade from schema version 0: boom")
+
+	invalid, _ := bson.Marshal(bson.M{"_schemaVersion": "two"})
+	_, err = upgrader.Upgrade(invalid, 2)
+	assert.ErrorContains(t, err, "not a number")
+}
+
+func TestMongoRepository_DecodeUpgrades(t *testing.T) {
+	tracker := NewChangeTracker()
+	repo := MongoRepository[versionedEntity]{Upgrader: versionedUpgrader(), ChangeTracker: tracker}
+
+	id := bson.NewObjectID()
+	raw, _ := bson.Marshal(bson.M{"_id": id, "name": "Ada Lovelace"})
+
+	var entity versionedEntity
+	assert.Nil(t, repo.decode(raw, &entity))
+	assert.Equal(t, versionedEntity{Id: id, FirstName: "Ada", LastName: "Lovelace", Status: "active", Version: 2}, entity)
+
+	// the snapshot is the entity as stored, so the next Update writes the upgraded fields
+	update, tracked, err := tracker.Changes(id, entity)
+	assert.Nil(t, err)
+	assert.True(t, tracked)
+	var changes bson.M
+	data, _ := bson.Marshal(update)
+	assert.Nil(t, bson.Unmarshal(data, &changes))
+	assert.Equal(t, bson.M{"$set": bson.D{
+		{Key: "firstName", Value: "Ada"},
+		{Key: "lastName", Value: "Lovelace"},
+		{Key: "status", Value: "active"},
+		{Key: "_schemaVersion", Value: int32(2)},
+	}}, changes)
+
+	plain := MongoRepository[TestEntity]{Upgrader: versionedUpgrader()}
+	raw, _ = bson.Marshal(bson.M{"_id": id, "name": "unversioned"})
+	var untouched TestEntity
+	assert.Nil(t, plain.decode(raw, &untouched))
+	assert.Equal(t, "unversioned", untouched.Name)
+}
+
+func TestUpgrader_WithoutWriteBack(t *testing.T) {
+	var none *Upgrader
+	assert.Nil(t, none.withoutWriteBack())
+
+	upgrader := versionedUpgrader()
+	upgrader.WriteBack = true
+
+	readOnly := upgrader.withoutWriteBack()
+	assert.False(t, readOnly.WriteBack)
+	assert.True(t, upgrader.WriteBack)
+	assert.Len(t, readOnly.upgrades, 2)
+}
+
+func TestWithSchemaVersion(t *testing.T) {
+	assert.Equal(t, 2, withSchemaVersion(versionedEntity{}).Version)
+
+	pointer := &versionedEntity{}
+	assert.Same(t, pointer, withSchemaVersion(pointer))
+	assert.Equal(t, 2, pointer.Version)
+
+	var boxed any = versionedEntity{FirstName: "Ada"}
+	assert.Equal(t, versionedEntity{FirstName: "Ada", Version: 2}, withSchemaVersion(boxed))
+
+	assert.Equal(t, TestEntity{Name: "unversioned"}, withSchemaVersion(TestEntity{Name: "unversioned"}))
+}
@@ -0,0 +1,350 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/hub1989/mongo-data/v4/internal/bsonfield"
+	"github.com/hub1989/mongo-data/v4/internal/validatetag"
+	log "github.com/sirupsen/logrus"
+)
+
+// Validator checks entities before they are written. Validate returns a
+// *base_entity.ValidationError for invalid entities.
+type Validator interface {
+	Validate(entity any) error
+}
+
+// TagValidator validates entities against the rules of their `validate` struct tags, the same
+// rules the schema package turns into $jsonSchema validators. Nested documents and slice items
+// are validated as well. The zero value is ready to use and caches parsed tags, so share one.
+type TagValidator struct {
+	rules sync.Map
+}
+
+type skipValidationKey struct{}
+
+// SkipValidation opt writes made with the context out of validation
+func SkipValidation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipValidationKey{}, true)
+}
+
+// validate check an entity with the repository's Validator and its own Validate method,
+// aggregating every field error into a single *base_entity.ValidationError
+func (p MongoRepository[T]) validate(ctx context.Context, entity any) error {
+	if skip, _ := ctx.Value(skipValidationKey{}).(bool); skip {
+		return nil
+	}
+
+	var fieldErrors []base_entity.FieldError
+	collect := func(err error) error {
+		if err == nil {
+			return nil
+		}
+
+		var validationError *base_entity.ValidationError
+		if errors.As(err, &validationError) {
+			fieldErrors = append(fieldErrors, validationError.Errors...)
+			return nil
+		}
+
+		var fieldError base_entity.FieldError
+		if errors.As(err, &fieldError) {
+			fieldErrors = append(fieldErrors, fieldError)
+			return nil
+		}
+
+		// not a validation failure: a malformed tag, say
+		return err
+	}
+
+	if p.Validator != nil {
+		if err := collect(p.Validator.Validate(entity)); err != nil {
+			return err
+		}
+	}
+
+	if validatable, ok := asValidatable(entity); ok {
+		// any other error of the entity's own is a failure of the entity as a whole
+		if err := collect(validatable.Validate()); err != nil {
+			fieldErrors = append(fieldErrors, base_entity.FieldError{Rule: "validate", Message: err.Error()})
+		}
+	}
+
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"errors": len(fieldErrors),
+	}).Debug(fmt.Sprintf("invalid %s entity", p.Collection.Name()))
+	return &base_entity.ValidationError{Errors: fieldErrors}
+}
+
+// validates whether entities written with the context are validated at all
+func (p MongoRepository[T]) validates(ctx context.Context) bool {
+	if skip, _ := ctx.Value(skipValidationKey{}).(bool); skip {
+		return false
+	}
+	if p.Validator != nil {
+		return true
+	}
+
+	var entity T
+	_, ok := asValidatable(entity)
+	return ok
+}
+
+// validateMany validate every entity, prefixing field paths with the entity's index
+func (p MongoRepository[T]) validateMany(ctx context.Context, entities []interface{}) error {
+	var fieldErrors []base_entity.FieldError
+	for i, entity := range entities {
+		err := p.validate(ctx, entity)
+
+		var validationError *base_entity.ValidationError
+		if !errors.As(err, &validationError) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, fieldError := range validationError.Errors {
+			fieldError.Path = strings.TrimSuffix(fmt.Sprintf("%d.%s", i, fieldError.Path), ".")
+			fieldErrors = append(fieldErrors, fieldError)
+		}
+	}
+
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+	return &base_entity.ValidationError{Errors: fieldErrors}
+}
+
+// asValidatable the entity as a base_entity.Validatable, including one whose Validate method has
+// a pointer receiver
+func asValidatable(entity any) (base_entity.Validatable, bool) {
+	if validatable, ok := entity.(base_entity.Validatable); ok {
+		return validatable, true
+	}
+	if entity == nil {
+		return nil, false
+	}
+
+	pointer := reflect.New(reflect.TypeOf(entity))
+	pointer.Elem().Set(reflect.ValueOf(entity))
+	validatable, ok := pointer.Interface().(base_entity.Validatable)
+	return validatable, ok
+}
+
+// fieldRules the parsed rules of a struct field
+type fieldRules struct {
+	field bsonfield.Field
+	rules validatetag.Rules
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// Validate check the entity against its validate tags
+func (v *TagValidator) Validate(entity any) error {
+	var fieldErrors []base_entity.FieldError
+	if err := v.check(reflect.ValueOf(entity), "", &fieldErrors); err != nil {
+		return err
+	}
+
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+	return &base_entity.ValidationError{Errors: fieldErrors}
+}
+
+// check validate the fields of a struct value and of the documents nested in it
+func (v *TagValidator) check(value reflect.Value, prefix string, fieldErrors *[]base_entity.FieldError) error {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct || value.Type() == timeType {
+		return nil
+	}
+
+	fields, err := v.fieldsOf(value.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		fieldValue, err := value.FieldByIndexErr(f.field.Index)
+		if err != nil {
+			// a field of a nil embedded pointer is not encoded either
+			continue
+		}
+
+		if err := v.checkValue(fieldValue, prefix+f.field.Name, f.rules, fieldErrors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValue validate one value against its rules, then descend into documents and slice items
+func (v *TagValidator) checkValue(value reflect.Value, path string, rules validatetag.Rules, fieldErrors *[]base_entity.FieldError) error {
+	report := func(rule, message string) {
+		*fieldErrors = append(*fieldErrors, base_entity.FieldError{Path: path, Rule: rule, Message: message})
+	}
+
+	if rules.Required && empty(value) {
+		report("required", "is required")
+		return nil
+	}
+
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	if !(rules.OmitEmpty && value.IsZero()) {
+		for _, rule := range rules.Constraints {
+			if message, ok := satisfies(value, rule); !ok {
+				report(rule.Name, message)
+			}
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		return v.check(value, path+".", fieldErrors)
+	case reflect.Slice, reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+
+		items := validatetag.Rules{}
+		if rules.Items != nil {
+			items = *rules.Items
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := v.checkValue(value.Index(i), path+"."+strconv.Itoa(i), items, fieldErrors); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if rules.Items == nil && bsonfield.Indirect(value.Type().Elem()).Kind() != reflect.Struct {
+			return nil
+		}
+
+		items := validatetag.Rules{}
+		if rules.Items != nil {
+			items = *rules.Items
+		}
+		keys := value.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+		for _, key := range keys {
+			if err := v.checkValue(value.MapIndex(key), path+"."+key.String(), items, fieldErrors); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fieldsOf the parsed rules of a struct type's fields, cached per type
+func (v *TagValidator) fieldsOf(t reflect.Type) ([]fieldRules, error) {
+	if cached, ok := v.rules.Load(t); ok {
+		return cached.([]fieldRules), nil
+	}
+
+	var fields []fieldRules
+	for _, field := range bsonfield.Fields(t) {
+		rules, err := validatetag.Parse(field.StructField.Tag.Get(validatetag.Tag))
+		if err != nil {
+			return nil, fmt.Errorf("invalid validate tag on %s.%s: %w", t.Name(), field.StructField.Name, err)
+		}
+		fields = append(fields, fieldRules{field: field, rules: rules})
+	}
+
+	v.rules.Store(t, fields)
+	return fields, nil
+}
+
+// empty whether a value counts as missing for the required rule
+func empty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+		return value.IsNil()
+	}
+	return value.IsZero()
+}
+
+var patterns sync.Map
+
+// satisfies check a value against a rule, returning the message describing the failure
+func satisfies(value reflect.Value, rule validatetag.Rule) (string, bool) {
+	var size float64
+	var unit string
+	switch value.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		size, unit = float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = value.Float()
+	}
+
+	bound := rule.Number()
+	describe := func(comparison string) string {
+		if unit == "" {
+			return fmt.Sprintf("must be %s %s", comparison, rule.Param)
+		}
+		return fmt.Sprintf("must have %s %s%s", comparison, rule.Param, unit)
+	}
+
+	switch rule.Name {
+	case "min", "gte":
+		return describe("at least"), size >= bound
+	case "max", "lte":
+		return describe("at most"), size <= bound
+	case "gt":
+		return describe("more than"), size > bound
+	case "lt":
+		return describe("less than"), size < bound
+	case "len":
+		return describe("exactly"), size == bound
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", strings.Join(rule.Options(), ", ")), slices.Contains(rule.Options(), fmt.Sprint(value.Interface()))
+	case "email", "url", "uuid", "pattern":
+		message := "must be a valid " + rule.Name
+		if rule.Name == "pattern" {
+			message = "must match " + rule.Param
+		}
+		if value.Kind() != reflect.String {
+			return message, false
+		}
+
+		compiled, ok := patterns.Load(rule.Pattern())
+		if !ok {
+			compiled, _ = patterns.LoadOrStore(rule.Pattern(), regexp.MustCompile(rule.Pattern()))
+		}
+		return message, compiled.(*regexp.Regexp).MatchString(value.String())
+	}
+
+	return "", true
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type validatedAddress struct {
+	City string `bson:"city" validate:"required"`
+}
+
+type validatedEntity struct {
+	Id        bson.ObjectID               `bson:"_id"`
+	Email     string                      `bson:"email" validate:"required,email"`
+	Age       int                         `bson:"age" validate:"gte=0,lt=150"`
+	Role      string                      `bson:"role" validate:"omitempty,oneof=admin member"`
+	Tags      []string                    `bson:"tags" validate:"max=2,dive,min=2"`
+	Address   *validatedAddress           `bson:"address,omitempty"`
+	Offices   []validatedAddress          `bson:"offices"`
+	Locations map[string]validatedAddress `bson:"locations"`
+	Nickname  *string                     `bson:"nickname" validate:"required"`
+}
+
+func (e validatedEntity) GetId() bson.ObjectID {
+	return e.Id
+}
+
+func (e validatedEntity) SetId(id bson.ObjectID) {
+	e.Id = id
+}
+
+// Validate a cross-field rule tags cannot express
+func (e *validatedEntity) Validate() error {
+	if e.Role == "admin" && e.Age < 18 {
+		return base_entity.FieldError{Path: "role", Rule: "adult", Message: "requires an adult"}
+	}
+	return nil
+}
+
+type invariantEntity struct {
+	Id bson.ObjectID `bson:"_id"`
+}
+
+func (e invariantEntity) GetId() bson.ObjectID {
+	return e.Id
+}
+
+func (e invariantEntity) SetId(id bson.ObjectID) {
+	e.Id = id
+}
+
+func (e invariantEntity) Validate() error {
+	return errors.New("balance does not add up")
+}
+
+func validEntity() validatedEntity {
+	nickname := "al"
+	return validatedEntity{Id: bson.NewObjectID(), Email: "a@b.co", Age: 30, Nickname: &nickname}
+}
+
+func TestTagValidator(t *testing.T) {
+	validator := &TagValidator{}
+	assert.Nil(t, validator.Validate(validEntity()))
+
+	entity := validatedEntity{
+		Email:     "nope",
+		Age:       200,
+		Role:      "owner",
+		Tags:      []string{"a", "bb", "cc"},
+		Address:   &validatedAddress{},
+		Offices:   []validatedAddress{{City: "Accra"}, {}},
+		Locations: map[string]validatedAddress{"home": {}},
+	}
+
+	err := validator.Validate(&entity)
+
+	var validationError *base_entity.ValidationError
+	assert.True(t, errors.As(err, &validationError))
+	assert.Equal(t, []base_entity.FieldError{
+		{Path: "email", Rule: "email", Message: "must be a valid email"},
+		{Path: "age", Rule: "lt", Message: "must be less than 150"},
+		{Path: "role", Rule: "oneof", Message: "must be one of admin, member"},
+		{Path: "tags", Rule: "max", Message: "must have at most 2 items"},
+		{Path: "tags.0", Rule: "min", Message: "must have at least 2 characters"},
+		{Path: "address.city", Rule: "required", Message: "is required"},
+		{Path: "offices.1.city", Rule: "required", Message: "is required"},
+		{Path: "locations.home.city", Rule: "required", Message: "is required"},
+		{Path: "nickname", Rule: "required", Message: "is required"},
+	}, validationError.Errors)
+}
+
+func TestTagValidator_InvalidTag(t *testing.T) {
+	type malformed struct {
+		Name string `bson:"name" validate:"min=abc"`
+	}
+
+	err := (&TagValidator{}).Validate(malformed{})
+	assert.ErrorContains(t, err, "invalid validate tag on malformed.Name")
+}
+
+func TestValidationBeforeWrites(t *testing.T) {
+	client, err := mongo.Connect(options.Client().ApplyURI("mongodb://localhost:1"))
+	assert.Nil(t, err)
+	defer client.Disconnect(context.Background())
+
+	repo := MongoRepository[validatedEntity]{
+		Collection: client.Database("validation").Collection("entities"),
+		Validator:  &TagValidator{},
+	}
+	ctx := context.Background()
+
+	invalid := validEntity()
+	invalid.Email = ""
+	invalid.Role = "admin"
+	invalid.Age = 12
+
+	var validationError *base_entity.ValidationError
+
+	_, err = repo.Save(ctx, invalid)
+	assert.True(t, errors.As(err, &validationError))
+	assert.Equal(t, []base_entity.FieldError{
+		{Path: "email", Rule: "required", Message: "is required"},
+		{Path: "role", Rule: "adult", Message: "requires an adult"},
+	}, validationError.Errors)
+	assert.EqualError(t, err, "validation failed: email is required; role requires an adult")
+
+	_, err = repo.Update(ctx, invalid)
+	assert.True(t, errors.As(err, &validationError))
+	_, err = repo.Replace(ctx, invalid)
+	assert.True(t, errors.As(err, &validationError))
+	_, err = repo.Upsert(ctx, invalid)
+	assert.True(t, errors.As(err, &validationError))
+	_, err = repo.UpsertByFilter(ctx, bson.M{"email": "x"}, invalid)
+	assert.True(t, errors.As(err, &validationError))
+	_, err = repo.FindOneAndReplace(ctx, bson.M{"email": "x"}, invalid)
+	assert.True(t, errors.As(err, &validationError))
+
+	_, err = repo.SaveMany(ctx, []interface{}{validEntity(), invalid})
+	assert.True(t, errors.As(err, &validationError))
+	assert.Equal(t, "1.email", validationError.Errors[0].Path)
+
+	// Validatable entities are checked without a Validator as well
+	repo.Validator = nil
+	_, err = repo.Insert(ctx, invalid)
+	assert.True(t, errors.As(err, &validationError))
+	assert.Len(t, validationError.Errors, 1)
+
+	// opting out reaches the server, which is not there
+	repo.Validator = &TagValidator{}
+	timeout, cancel := context.WithTimeout(SkipValidation(ctx), 100*time.Millisecond)
+	defer cancel()
+	_, err = repo.Insert(timeout, invalid)
+	assert.NotNil(t, err)
+	assert.False(t, errors.As(err, &validationError))
+}
+
+func TestValidation_PlainError(t *testing.T) {
+	repo := MongoRepository[invariantEntity]{Collection: &mongo.Collection{}}
+
+	err := repo.validate(context.Background(), invariantEntity{})
+
+	var validationError *base_entity.ValidationError
+	assert.True(t, errors.As(err, &validationError))
+	assert.Equal(t, []base_entity.FieldError{
+		{Rule: "validate", Message: "balance does not add up"},
+	}, validationError.Errors)
+	assert.EqualError(t, err, "validation failed: balance does not add up")
+}
@@ -0,0 +1,167 @@
+package repositorymock
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Matcher decides whether an argument is the one an expectation waits for
+type Matcher interface {
+	Match(arg any) bool
+	String() string
+}
+
+type matcher struct {
+	match       func(arg any) bool
+	description string
+}
+
+func (m matcher) Match(arg any) bool { return m.match(arg) }
+
+func (m matcher) String() string { return m.description }
+
+// matcherFor use a Matcher as it is and compare any other value for equality
+func matcherFor(arg any) Matcher {
+	if m, ok := arg.(Matcher); ok {
+		return m
+	}
+	return Eq(arg)
+}
+
+// Any match every argument
+func Any() Matcher {
+	return matcher{match: func(any) bool { return true }, description: "Any"}
+}
+
+// Eq match an argument equal to value. Documents compare regardless of key order and integers
+// regardless of their size, so bson.M{"age": 3} matches bson.D{{"age", int64(3)}}.
+func Eq(value any) Matcher {
+	expected := canonical(value)
+	return matcher{
+		match:       func(arg any) bool { return reflect.DeepEqual(canonical(arg), expected) },
+		description: fmt.Sprintf("%v", value),
+	}
+}
+
+// MatchedBy match an argument of type V fn accepts
+func MatchedBy[V any](fn func(V) bool) Matcher {
+	var zero V
+	return matcher{
+		match: func(arg any) bool {
+			value, ok := arg.(V)
+			return ok && fn(value)
+		},
+		description: fmt.Sprintf("MatchedBy(func(%T) bool)", zero),
+	}
+}
+
+// FilterHas match a filter holding key with a value equal to, or matched by, value
+func FilterHas(key string, value any) Matcher {
+	return FilterContains(bson.M{key: value})
+}
+
+// FilterContains match a filter holding every key of subset with a value equal to, or matched
+// by, the subset's. The filter may hold other keys as well.
+func FilterContains(subset bson.M) Matcher {
+	matchers := make(map[string]Matcher, len(subset))
+	for key, value := range subset {
+		matchers[key] = matcherFor(value)
+	}
+
+	return matcher{
+		match: func(arg any) bool {
+			filter, ok := document(arg)
+			if !ok {
+				return false
+			}
+
+			for key, m := range matchers {
+				value, ok := filter[key]
+				if !ok || !m.Match(value) {
+					return false
+				}
+			}
+			return true
+		},
+		description: fmt.Sprintf("FilterContains(%v)", subset),
+	}
+}
+
+// FilterKeys match a filter holding every one of keys
+func FilterKeys(keys ...string) Matcher {
+	return matcher{
+		match: func(arg any) bool {
+			filter, ok := document(arg)
+			if !ok {
+				return false
+			}
+
+			for _, key := range keys {
+				if _, ok := filter[key]; !ok {
+					return false
+				}
+			}
+			return true
+		},
+		description: fmt.Sprintf("FilterKeys(%s)", strings.Join(keys, ", ")),
+	}
+}
+
+// document a filter or update argument as a map
+func document(arg any) (map[string]any, bool) {
+	switch v := arg.(type) {
+	case bson.M:
+		return v, true
+	case map[string]any:
+		return v, true
+	case bson.D:
+		m := make(map[string]any, len(v))
+		for _, e := range v {
+			m[e.Key] = e.Value
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// canonical a comparable form of value: documents become key sorted bson.D, arrays bson.A and
+// integers int64
+func canonical(value any) any {
+	if m, ok := document(value); ok {
+		d := make(bson.D, 0, len(m))
+		for key, element := range m {
+			d = append(d, bson.E{Key: key, Value: canonical(element)})
+		}
+		slices.SortFunc(d, func(a, b bson.E) int { return cmp.Compare(a.Key, b.Key) })
+		return d
+	}
+
+	switch v := value.(type) {
+	case bson.A:
+		return canonicalArray(v)
+	case []any:
+		return canonicalArray(v)
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	}
+	return value
+}
+
+func canonicalArray(values []any) bson.A {
+	a := make(bson.A, len(values))
+	for i, value := range values {
+		a[i] = canonical(value)
+	}
+	return a
+}
@@ -0,0 +1,314 @@
+package repositorymock
+
+import (
+	"context"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Cursor a cursor over documents, for canned Aggregate results
+func Cursor(documents ...any) (*mongo.Cursor, error) {
+	return mongo.NewCursorFromDocuments(documents, nil, nil)
+}
+
+// Save answers with the entity it was given unless told otherwise
+func (m *Mock[T]) Save(ctx context.Context, entity T) (*T, error) {
+	e, err := m.called("Save", entity)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.Save(ctx, entity)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return entityResult(e, 0, &entity), nil
+}
+
+// Insert answers with the entity it was given unless told otherwise
+func (m *Mock[T]) Insert(ctx context.Context, entity T) (*T, error) {
+	e, err := m.called("Insert", entity)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.Insert(ctx, entity)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return entityResult(e, 0, &entity), nil
+}
+
+// Replace answers with the entity it was given unless told otherwise
+func (m *Mock[T]) Replace(ctx context.Context, entity T) (*T, error) {
+	e, err := m.called("Replace", entity)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.Replace(ctx, entity)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return entityResult(e, 0, &entity), nil
+}
+
+// Upsert answers with the entity it was given, as not inserted, unless told otherwise
+func (m *Mock[T]) Upsert(ctx context.Context, entity T) (*base_entity.UpsertResult[T], error) {
+	e, err := m.called("Upsert", entity)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.Upsert(ctx, entity)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return result(e, 0, &base_entity.UpsertResult[T]{Entity: &entity}), nil
+}
+
+// UpsertByFilter answers with the entity it was given, as not inserted, unless told otherwise
+func (m *Mock[T]) UpsertByFilter(ctx context.Context, filter bson.M, entity T) (*base_entity.UpsertResult[T], error) {
+	e, err := m.called("UpsertByFilter", filter, entity)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.UpsertByFilter(ctx, filter, entity)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return result(e, 0, &base_entity.UpsertResult[T]{Entity: &entity}), nil
+}
+
+// SaveMany answers with the ids of the entities it was given unless told otherwise
+func (m *Mock[T]) SaveMany(ctx context.Context, entities []interface{}) ([]string, error) {
+	e, err := m.called("SaveMany", entities)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.SaveMany(ctx, entities)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	var ids []string
+	for _, entity := range entities {
+		if entity, ok := entity.(base_entity.Entity); ok {
+			ids = append(ids, entity.GetId().Hex())
+		}
+	}
+	return result(e, 0, ids), nil
+}
+
+// Update answers with the entity it was given unless told otherwise
+func (m *Mock[T]) Update(ctx context.Context, entity T) (*T, error) {
+	e, err := m.called("Update", entity)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.Update(ctx, entity)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return entityResult(e, 0, &entity), nil
+}
+
+func (m *Mock[T]) UpdateOne(ctx context.Context, filter bson.M, update bson.M) error {
+	e, err := m.called("UpdateOne", filter, update)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return m.Fallback.UpdateOne(ctx, filter, update)
+	}
+	return e.err
+}
+
+// UpdateMany answers with the entities it was given unless told otherwise
+func (m *Mock[T]) UpdateMany(ctx context.Context, entities []T) ([]*T, error) {
+	e, err := m.called("UpdateMany", entities)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.UpdateMany(ctx, entities)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	updated := make([]*T, len(entities))
+	for i := range entities {
+		updated[i] = &entities[i]
+	}
+	return result(e, 0, updated), nil
+}
+
+func (m *Mock[T]) FindById(ctx context.Context, id bson.ObjectID, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	e, err := m.called("FindById", id, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.FindById(ctx, id, opts...)
+	}
+	return entityResult[T](e, 0, nil), errorOf(e)
+}
+
+func (m *Mock[T]) Delete(ctx context.Context, id bson.ObjectID) error {
+	e, err := m.called("Delete", id)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return m.Fallback.Delete(ctx, id)
+	}
+	return e.err
+}
+
+func (m *Mock[T]) DeleteMany(ctx context.Context, ids []bson.ObjectID) error {
+	e, err := m.called("DeleteMany", ids)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return m.Fallback.DeleteMany(ctx, ids)
+	}
+	return e.err
+}
+
+func (m *Mock[T]) FindByIds(ctx context.Context, ids []bson.ObjectID, opts ...options.Lister[options.FindOptions]) ([]*T, error) {
+	e, err := m.called("FindByIds", ids, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.FindByIds(ctx, ids, opts...)
+	}
+	return result[[]*T](e, 0, nil), errorOf(e)
+}
+
+func (m *Mock[T]) FindEntityDocumentsByFilter(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOptions]) ([]*T, error) {
+	e, err := m.called("FindEntityDocumentsByFilter", filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.FindEntityDocumentsByFilter(ctx, filter, opts...)
+	}
+	return result[[]*T](e, 0, nil), errorOf(e)
+}
+
+func (m *Mock[T]) FindEntityDocumentByFilter(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	e, err := m.called("FindEntityDocumentByFilter", filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.FindEntityDocumentByFilter(ctx, filter, opts...)
+	}
+	return entityResult[T](e, 0, nil), errorOf(e)
+}
+
+func (m *Mock[T]) CountDocumentsInCollected(ctx context.Context) (int64, error) {
+	e, err := m.called("CountDocumentsInCollected")
+	if err != nil {
+		return 0, err
+	}
+	if e == nil {
+		return m.Fallback.CountDocumentsInCollected(ctx)
+	}
+	return result[int64](e, 0, 0), errorOf(e)
+}
+
+func (m *Mock[T]) FindAllPageable(request base_entity.PageableDBRequest, ctx context.Context) (*base_entity.PageableDBResponse[T], error) {
+	e, err := m.called("FindAllPageable", request)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.FindAllPageable(request, ctx)
+	}
+	return result[*base_entity.PageableDBResponse[T]](e, 0, nil), errorOf(e)
+}
+
+// HandleResultCursorForObject answers with the entities it was given unless told otherwise
+func (m *Mock[T]) HandleResultCursorForObject(records *mongo.Cursor, ctx context.Context, entities []T) ([]T, error) {
+	e, err := m.called("HandleResultCursorForObject", records, entities)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.HandleResultCursorForObject(records, ctx, entities)
+	}
+	return result(e, 0, entities), errorOf(e)
+}
+
+// Aggregate answers with a nil cursor unless told otherwise; see Cursor
+func (m *Mock[T]) Aggregate(ctx context.Context, pipeline mongo.Pipeline) (*mongo.Cursor, error) {
+	e, err := m.called("Aggregate", pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.Aggregate(ctx, pipeline)
+	}
+	return result[*mongo.Cursor](e, 0, nil), errorOf(e)
+}
+
+func (m *Mock[T]) AggregateForEntity(ctx context.Context, pipeline mongo.Pipeline) ([]*T, error) {
+	e, err := m.called("AggregateForEntity", pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.AggregateForEntity(ctx, pipeline)
+	}
+	return result[[]*T](e, 0, nil), errorOf(e)
+}
+
+func (m *Mock[T]) FindOneAndUpdate(ctx context.Context, filter bson.M, update bson.M, opts ...options.Lister[options.FindOneAndUpdateOptions]) (*T, error) {
+	e, err := m.called("FindOneAndUpdate", filter, update, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.FindOneAndUpdate(ctx, filter, update, opts...)
+	}
+	return entityResult[T](e, 0, nil), errorOf(e)
+}
+
+func (m *Mock[T]) FindOneAndReplace(ctx context.Context, filter bson.M, replacement T, opts ...options.Lister[options.FindOneAndReplaceOptions]) (*T, error) {
+	e, err := m.called("FindOneAndReplace", filter, replacement, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.FindOneAndReplace(ctx, filter, replacement, opts...)
+	}
+	return entityResult[T](e, 0, nil), errorOf(e)
+}
+
+func (m *Mock[T]) FindOneAndDelete(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOneAndDeleteOptions]) (*T, error) {
+	e, err := m.called("FindOneAndDelete", filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return m.Fallback.FindOneAndDelete(ctx, filter, opts...)
+	}
+	return entityResult[T](e, 0, nil), errorOf(e)
+}
@@ -0,0 +1,321 @@
+// Package repositorymock provides a programmable fake of repository.Repository for unit tests:
+// expectations with canned results and errors, argument matchers for bson.M filters and a record
+// of every call. A compile-time assertion keeps Mock in lockstep with the interface.
+package repositorymock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/hub1989/mongo-data/v4/repository"
+)
+
+// ErrUnexpectedCall returned by a call no expectation matches when the mock has no Fallback
+var ErrUnexpectedCall = errors.New("unexpected call")
+
+// Call a recorded call. Args leave out the context; variadic options are one slice argument.
+type Call struct {
+	Method string
+	Args   []any
+}
+
+func (c Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = fmt.Sprintf("%v", arg)
+	}
+	return fmt.Sprintf("%s(%s)", c.Method, strings.Join(args, ", "))
+}
+
+// Mock a programmable repository.Repository. Calls are matched against the expectations in the
+// order they were added; the first one whose arguments match and that has calls left answers.
+type Mock[T base_entity.Entity] struct {
+	// Fallback answers calls no expectation matches, e.g. an inmemory.Repository. Without one
+	// such calls fail with ErrUnexpectedCall.
+	Fallback repository.Repository[T]
+
+	t            testing.TB
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []Call
+}
+
+var _ repository.Repository[base_entity.Entity] = (*Mock[base_entity.Entity])(nil)
+
+// New create a mock. With a non-nil t, unexpected calls are reported as test errors and the
+// expectations are asserted when the test finishes.
+func New[T base_entity.Entity](t testing.TB) *Mock[T] {
+	m := &Mock[T]{t: t}
+	if t != nil {
+		t.Cleanup(func() {
+			m.AssertExpectations(t)
+		})
+	}
+	return m
+}
+
+// On expect a call of method with arguments matching args. Arguments are given without the
+// context, as values compared for equality or as Matchers; trailing arguments left out match
+// anything. Panics when the method is not part of repository.Repository or takes fewer arguments.
+func (m *Mock[T]) On(method string, args ...any) *Expectation {
+	signature, ok := reflect.TypeFor[repository.Repository[T]]().MethodByName(method)
+	if !ok {
+		panic(fmt.Sprintf("repositorymock: %s is not a Repository method", method))
+	}
+	if want := argumentCount(signature.Type); len(args) > want {
+		panic(fmt.Sprintf("repositorymock: %s takes %d arguments besides the context, got %d", method, want, len(args)))
+	}
+
+	matchers := make([]Matcher, len(args))
+	for i, arg := range args {
+		matchers[i] = matcherFor(arg)
+	}
+
+	e := &Expectation{method: method, args: matchers}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// Calls the recorded calls of method, or of every method when it is empty
+func (m *Mock[T]) Calls(method string) []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var calls []Call
+	for _, call := range m.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// CallCount the number of calls of method
+func (m *Mock[T]) CallCount(method string) int {
+	return len(m.Calls(method))
+}
+
+// Reset drop every expectation and recorded call
+func (m *Mock[T]) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expectations = nil
+	m.calls = nil
+}
+
+// AssertExpectations check every expectation was called: exactly as often as Times asked, or at
+// least once when it did not. Unmet expectations are reported on t.
+func (m *Mock[T]) AssertExpectations(t testing.TB) bool {
+	t.Helper()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ok := true
+	for _, e := range m.expectations {
+		switch {
+		case e.times > 0 && e.calls != e.times:
+			t.Errorf("repositorymock: expected %s to be called %d times, got %d", e, e.times, e.calls)
+			ok = false
+		case e.times == 0 && !e.optional && e.calls == 0:
+			t.Errorf("repositorymock: expected %s to be called", e)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertCalled check method was called at least once with arguments matching args
+func (m *Mock[T]) AssertCalled(t testing.TB, method string, args ...any) bool {
+	t.Helper()
+
+	if m.findCall(method, args) {
+		return true
+	}
+	t.Errorf("repositorymock: expected a call %s%v, calls were %v", method, args, m.Calls(method))
+	return false
+}
+
+// AssertNotCalled check method was never called with arguments matching args
+func (m *Mock[T]) AssertNotCalled(t testing.TB, method string, args ...any) bool {
+	t.Helper()
+
+	if !m.findCall(method, args) {
+		return true
+	}
+	t.Errorf("repositorymock: expected no call %s%v, calls were %v", method, args, m.Calls(method))
+	return false
+}
+
+func (m *Mock[T]) findCall(method string, args []any) bool {
+	matchers := make([]Matcher, len(args))
+	for i, arg := range args {
+		matchers[i] = matcherFor(arg)
+	}
+
+	for _, call := range m.Calls(method) {
+		if matchAll(matchers, call.Args) {
+			return true
+		}
+	}
+	return false
+}
+
+// called record a call and find the expectation answering it. It returns nil with a nil error
+// when the Fallback should answer, and an error when nothing can.
+func (m *Mock[T]) called(method string, args ...any) (*Expectation, error) {
+	call := Call{Method: method, Args: args}
+
+	m.mu.Lock()
+	m.calls = append(m.calls, call)
+
+	var exhausted *Expectation
+	for _, e := range m.expectations {
+		if e.method != method || !matchAll(e.args, args) {
+			continue
+		}
+		if e.times > 0 && e.calls >= e.times {
+			exhausted = e
+			continue
+		}
+
+		e.calls++
+		m.mu.Unlock()
+
+		if e.run != nil {
+			e.run(args)
+		}
+		return e, nil
+	}
+	m.mu.Unlock()
+
+	if m.Fallback != nil {
+		return nil, nil
+	}
+
+	err := fmt.Errorf("repositorymock: %w %s", ErrUnexpectedCall, call)
+	if exhausted != nil {
+		err = fmt.Errorf("repositorymock: %w %s: %s was already called %d times", ErrUnexpectedCall, call, exhausted, exhausted.times)
+	}
+	if m.t != nil {
+		m.t.Errorf("%v", err)
+	}
+	return nil, err
+}
+
+// argumentCount the parameters of a method besides the context
+func argumentCount(method reflect.Type) int {
+	count := 0
+	for i := 0; i < method.NumIn(); i++ {
+		if method.In(i) != reflect.TypeFor[context.Context]() {
+			count++
+		}
+	}
+	return count
+}
+
+func matchAll(matchers []Matcher, args []any) bool {
+	for i, matcher := range matchers {
+		if i >= len(args) || !matcher.Match(args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Expectation an expected call and the results it answers with
+type Expectation struct {
+	method   string
+	args     []Matcher
+	results  []any
+	err      error
+	run      func(args []any)
+	times    int
+	optional bool
+	calls    int
+}
+
+// Return answer with these results, leaving out the error: an entity, a *T, a slice, a count and
+// so on, in the order the method returns them. Without Return write methods echo the entity they
+// were given and other methods return zero values.
+func (e *Expectation) Return(results ...any) *Expectation {
+	e.results = results
+	return e
+}
+
+// ReturnError answer with this error
+func (e *Expectation) ReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Run call fn with the call's arguments before answering
+func (e *Expectation) Run(fn func(args []any)) *Expectation {
+	e.run = fn
+	return e
+}
+
+// Times expect exactly n calls; further calls are unexpected
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// Once expect exactly one call
+func (e *Expectation) Once() *Expectation {
+	return e.Times(1)
+}
+
+// Maybe allow the expectation to go uncalled
+func (e *Expectation) Maybe() *Expectation {
+	e.optional = true
+	return e
+}
+
+func (e *Expectation) String() string {
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", e.method, strings.Join(args, ", "))
+}
+
+// result the i-th canned result as R, or fallback when none was given
+func result[R any](e *Expectation, i int, fallback R) R {
+	if e == nil || i >= len(e.results) || e.results[i] == nil {
+		return fallback
+	}
+
+	value, ok := e.results[i].(R)
+	if !ok {
+		panic(fmt.Sprintf("repositorymock: %s result %d is %T, want %T", e, i, e.results[i], fallback))
+	}
+	return value
+}
+
+// entityResult the i-th canned result as *T, accepting a T as well
+func entityResult[T any](e *Expectation, i int, fallback *T) *T {
+	if e != nil && i < len(e.results) {
+		if value, ok := e.results[i].(T); ok {
+			return &value
+		}
+	}
+	return result(e, i, fallback)
+}
+
+func errorOf(e *Expectation) error {
+	if e == nil {
+		return nil
+	}
+	return e.err
+}
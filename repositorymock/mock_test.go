@@ -0,0 +1,207 @@
+package repositorymock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hub1989/mongo-data/v4/inmemory"
+	"github.com/hub1989/mongo-data/v4/repository"
+	"github.com/hub1989/mongo-data/v4/repositorymock"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type Person struct {
+	Id   bson.ObjectID `bson:"_id"`
+	Name string        `bson:"name"`
+	Age  int           `bson:"age"`
+}
+
+func (p Person) GetId() bson.ObjectID {
+	return p.Id
+}
+
+func (p Person) SetId(id bson.ObjectID) {
+	p.Id = id
+}
+
+var _ repository.Repository[Person] = (*repositorymock.Mock[Person])(nil)
+
+// recorder a testing.TB collecting the errors reported on it
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCannedResults(t *testing.T) {
+	ctx := context.Background()
+	alice := Person{Id: bson.NewObjectID(), Name: "alice", Age: 30}
+
+	mock := repositorymock.New[Person](t)
+	mock.On("FindById", alice.Id).Return(alice).Once()
+	mock.On("FindEntityDocumentsByFilter", bson.M{"age": 30}).Return([]*Person{&alice})
+	mock.On("CountDocumentsInCollected").Return(int64(7))
+
+	found, err := mock.FindById(ctx, alice.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, alice, *found)
+
+	people, err := mock.FindEntityDocumentsByFilter(ctx, bson.M{"age": int64(30)})
+	assert.Nil(t, err)
+	assert.Equal(t, []*Person{&alice}, people)
+
+	count, err := mock.CountDocumentsInCollected(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), count)
+}
+
+func TestWritesEchoTheEntity(t *testing.T) {
+	ctx := context.Background()
+	alice := Person{Id: bson.NewObjectID(), Name: "alice"}
+
+	mock := repositorymock.New[Person](t)
+	mock.On("Save", repositorymock.Any())
+	mock.On("Upsert", alice)
+	mock.On("SaveMany")
+
+	saved, err := mock.Save(ctx, alice)
+	assert.Nil(t, err)
+	assert.Equal(t, alice, *saved)
+
+	upserted, err := mock.Upsert(ctx, alice)
+	assert.Nil(t, err)
+	assert.Equal(t, alice, *upserted.Entity)
+	assert.False(t, upserted.Inserted)
+
+	ids, err := mock.SaveMany(ctx, []interface{}{alice})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{alice.Id.Hex()}, ids)
+}
+
+func TestReturnError(t *testing.T) {
+	ctx := context.Background()
+	id := bson.NewObjectID()
+
+	mock := repositorymock.New[Person](t)
+	mock.On("Delete", id).ReturnError(repository.ErrNotFound)
+	mock.On("Insert").ReturnError(repository.ErrDuplicateKey)
+
+	assert.ErrorIs(t, mock.Delete(ctx, id), repository.ErrNotFound)
+
+	entity, err := mock.Insert(ctx, Person{})
+	assert.Nil(t, entity)
+	assert.ErrorIs(t, err, repository.ErrDuplicateKey)
+}
+
+func TestFilterMatchers(t *testing.T) {
+	ctx := context.Background()
+	alice := Person{Name: "alice"}
+	bob := Person{Name: "bob"}
+
+	mock := repositorymock.New[Person](t)
+	mock.On("FindEntityDocumentByFilter", repositorymock.FilterHas("name", "alice")).Return(alice)
+	mock.On("FindEntityDocumentByFilter", repositorymock.FilterContains(bson.M{
+		"age": repositorymock.MatchedBy(func(condition bson.M) bool { return condition["$gt"] == 18 }),
+	})).Return(&bob)
+	mock.On("UpdateOne", repositorymock.FilterKeys("_id"), bson.M{"$set": bson.M{"name": "carol"}})
+
+	found, err := mock.FindEntityDocumentByFilter(ctx, bson.M{"name": "alice", "deleted": false})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", found.Name)
+
+	found, err = mock.FindEntityDocumentByFilter(ctx, bson.M{"age": bson.M{"$gt": 18}})
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", found.Name)
+
+	assert.Nil(t, mock.UpdateOne(ctx, bson.M{"_id": bson.NewObjectID()}, bson.M{"$set": bson.M{"name": "carol"}}))
+}
+
+func TestUnexpectedCalls(t *testing.T) {
+	ctx := context.Background()
+	recorded := &recorder{}
+
+	mock := repositorymock.New[Person](nil)
+	mock.On("Delete").Once()
+
+	assert.Nil(t, mock.Delete(ctx, bson.NewObjectID()))
+	assert.ErrorIs(t, mock.Delete(ctx, bson.NewObjectID()), repositorymock.ErrUnexpectedCall)
+
+	_, err := mock.FindById(ctx, bson.NewObjectID())
+	assert.ErrorIs(t, err, repositorymock.ErrUnexpectedCall)
+
+	mock.On("Update")
+	mock.On("Replace").Maybe()
+	assert.False(t, mock.AssertExpectations(recorded))
+	assert.Len(t, recorded.errors, 1)
+	assert.Contains(t, recorded.errors[0], "Update")
+}
+
+func TestCallRecording(t *testing.T) {
+	ctx := context.Background()
+	id := bson.NewObjectID()
+
+	mock := repositorymock.New[Person](t)
+	mock.On("UpdateOne").Run(func(args []any) {
+		assert.Equal(t, bson.M{"_id": id}, args[0])
+	}).Times(2)
+
+	assert.Nil(t, mock.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"age": 1}}))
+	assert.Nil(t, mock.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"age": 2}}))
+
+	assert.Equal(t, 2, mock.CallCount("UpdateOne"))
+	assert.Len(t, mock.Calls(""), 2)
+	mock.AssertCalled(t, "UpdateOne", bson.D{{Key: "_id", Value: id}}, repositorymock.FilterHas("$inc", bson.M{"age": 2}))
+	mock.AssertNotCalled(t, "UpdateOne", repositorymock.Any(), bson.M{"$set": bson.M{}})
+	mock.AssertNotCalled(t, "Delete")
+}
+
+func TestFallback(t *testing.T) {
+	ctx := context.Background()
+	alice := Person{Id: bson.NewObjectID(), Name: "alice"}
+
+	mock := repositorymock.New[Person](t)
+	mock.Fallback = inmemory.New[Person]()
+	mock.On("Delete").ReturnError(errors.New("read only"))
+
+	_, err := mock.Save(ctx, alice)
+	assert.Nil(t, err)
+
+	found, err := mock.FindById(ctx, alice.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, alice, *found)
+
+	assert.EqualError(t, mock.Delete(ctx, alice.Id), "read only")
+	assert.Equal(t, 3, len(mock.Calls("")))
+}
+
+func TestCursor(t *testing.T) {
+	ctx := context.Background()
+
+	mock := repositorymock.New[Person](t)
+	cursor, err := repositorymock.Cursor(bson.M{"total": 3})
+	assert.Nil(t, err)
+	mock.On("Aggregate", mongo.Pipeline{}).Return(cursor)
+
+	records, err := mock.Aggregate(ctx, mongo.Pipeline{})
+	assert.Nil(t, err)
+
+	var results []bson.M
+	assert.Nil(t, records.All(ctx, &results))
+	assert.Equal(t, []bson.M{{"total": int32(3)}}, results)
+}
+
+func TestOnPanicsForUnknownMethods(t *testing.T) {
+	mock := repositorymock.New[Person](nil)
+
+	assert.Panics(t, func() { mock.On("FindAll") })
+	assert.Panics(t, func() { mock.On("Delete", bson.NewObjectID(), "extra") })
+}
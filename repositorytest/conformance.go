@@ -0,0 +1,511 @@
+// Package repositorytest holds a conformance suite for implementations of repository.Repository.
+// Custom implementations and decorators run it to prove they behave as MongoRepository does.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hub1989/mongo-data/v4/base_entity"
+	"github.com/hub1989/mongo-data/v4/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Entity the document type the conformance suite stores
+type Entity struct {
+	Id   bson.ObjectID `bson:"_id"`
+	Name string        `bson:"name"`
+	Age  int           `bson:"age"`
+	Tags []string      `bson:"tags,omitempty"`
+}
+
+func (e Entity) GetId() bson.ObjectID {
+	return e.Id
+}
+
+func (e Entity) SetId(id bson.ObjectID) {
+	e.Id = id
+}
+
+// Factory create a repository over empty storage. It is called once per test; register any
+// cleanup with t.Cleanup.
+type Factory func(t *testing.T) repository.Repository[Entity]
+
+// RunConformance run the conformance suite as subtests of t, covering every Repository method
+// including the edge cases callers rely on: empty id lists, invalid page cursors, missing
+// documents and duplicate keys
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo repository.Repository[Entity])
+	}{
+		{"Save", testSave},
+		{"InsertDuplicateKey", testInsertDuplicateKey},
+		{"SaveMany", testSaveMany},
+		{"SaveManyDuplicateKey", testSaveManyDuplicateKey},
+		{"Replace", testReplace},
+		{"ReplaceNotFound", testReplaceNotFound},
+		{"Upsert", testUpsert},
+		{"UpsertByFilter", testUpsertByFilter},
+		{"Update", testUpdate},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"UpdateOne", testUpdateOne},
+		{"UpdateMany", testUpdateMany},
+		{"FindById", testFindById},
+		{"FindByIdNotFound", testFindByIdNotFound},
+		{"Delete", testDelete},
+		{"DeleteMany", testDeleteMany},
+		{"DeleteManyEmptyIds", testDeleteManyEmptyIds},
+		{"FindByIds", testFindByIds},
+		{"FindByIdsEmptyIds", testFindByIdsEmptyIds},
+		{"FindEntityDocumentsByFilter", testFindEntityDocumentsByFilter},
+		{"FindEntityDocumentByFilter", testFindEntityDocumentByFilter},
+		{"CountDocumentsInCollected", testCountDocumentsInCollected},
+		{"FindAllPageable", testFindAllPageable},
+		{"FindAllPageableInvalidLastItemId", testFindAllPageableInvalidLastItemId},
+		{"Aggregate", testAggregate},
+		{"AggregateForEntity", testAggregateForEntity},
+		{"FindOneAndUpdate", testFindOneAndUpdate},
+		{"FindOneAndReplace", testFindOneAndReplace},
+		{"FindOneAndDelete", testFindOneAndDelete},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, factory(t))
+		})
+	}
+}
+
+func seed(t *testing.T, repo repository.Repository[Entity], entities ...Entity) []Entity {
+	for i := range entities {
+		if entities[i].Id.IsZero() {
+			entities[i].Id = bson.NewObjectID()
+		}
+
+		_, err := repo.Insert(context.Background(), entities[i])
+		require.NoError(t, err)
+	}
+	return entities
+}
+
+func names(entities []*Entity) []string {
+	var result []string
+	for _, entity := range entities {
+		result = append(result, entity.Name)
+	}
+	return result
+}
+
+func testSave(t *testing.T, repo repository.Repository[Entity]) {
+	entity := Entity{Id: bson.NewObjectID(), Name: "alice", Age: 30, Tags: []string{"a"}}
+
+	saved, err := repo.Save(context.Background(), entity)
+	require.NoError(t, err)
+	assert.Equal(t, entity, *saved)
+
+	found, err := repo.FindById(context.Background(), entity.Id)
+	require.NoError(t, err)
+	assert.Equal(t, entity, *found)
+}
+
+func testInsertDuplicateKey(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "alice"})
+
+	_, err := repo.Insert(context.Background(), entities[0])
+	assert.True(t, errors.Is(err, repository.ErrDuplicateKey), "want ErrDuplicateKey, got %v", err)
+	assert.True(t, mongo.IsDuplicateKeyError(err))
+}
+
+func testSaveMany(t *testing.T, repo repository.Repository[Entity]) {
+	first, second := Entity{Id: bson.NewObjectID(), Name: "a"}, Entity{Id: bson.NewObjectID(), Name: "b"}
+
+	ids, err := repo.SaveMany(context.Background(), []interface{}{first, second})
+	require.NoError(t, err)
+	assert.Equal(t, []string{first.Id.Hex(), second.Id.Hex()}, ids)
+
+	count, err := repo.CountDocumentsInCollected(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func testSaveManyDuplicateKey(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "a"})
+
+	_, err := repo.SaveMany(context.Background(), []interface{}{entities[0]})
+	assert.True(t, errors.Is(err, repository.ErrDuplicateKey), "want ErrDuplicateKey, got %v", err)
+}
+
+func testReplace(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "alice", Age: 30, Tags: []string{"a"}})
+
+	replacement := Entity{Id: entities[0].Id, Name: "alice", Age: 31}
+	replaced, err := repo.Replace(context.Background(), replacement)
+	require.NoError(t, err)
+	assert.Equal(t, replacement, *replaced)
+
+	found, err := repo.FindById(context.Background(), entities[0].Id)
+	require.NoError(t, err)
+	assert.Equal(t, replacement, *found)
+}
+
+func testReplaceNotFound(t *testing.T, repo repository.Repository[Entity]) {
+	_, err := repo.Replace(context.Background(), Entity{Id: bson.NewObjectID(), Name: "ghost"})
+	assert.True(t, errors.Is(err, repository.ErrNotFound), "want ErrNotFound, got %v", err)
+
+	count, err := repo.CountDocumentsInCollected(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func testUpsert(t *testing.T, repo repository.Repository[Entity]) {
+	entity := Entity{Id: bson.NewObjectID(), Name: "alice", Age: 30}
+
+	inserted, err := repo.Upsert(context.Background(), entity)
+	require.NoError(t, err)
+	assert.True(t, inserted.Inserted)
+
+	entity.Age = 31
+	replaced, err := repo.Upsert(context.Background(), entity)
+	require.NoError(t, err)
+	assert.False(t, replaced.Inserted)
+	assert.Equal(t, entity, *replaced.Entity)
+
+	found, err := repo.FindById(context.Background(), entity.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 31, found.Age)
+}
+
+func testUpsertByFilter(t *testing.T, repo repository.Repository[Entity]) {
+	filter := bson.M{"name": "alice"}
+
+	inserted, err := repo.UpsertByFilter(context.Background(), filter, Entity{Name: "alice", Age: 30})
+	require.NoError(t, err)
+	assert.True(t, inserted.Inserted)
+	assert.False(t, inserted.Entity.Id.IsZero())
+
+	replaced, err := repo.UpsertByFilter(context.Background(), filter, Entity{Id: bson.NewObjectID(), Name: "alice", Age: 31})
+	require.NoError(t, err)
+	assert.False(t, replaced.Inserted)
+	assert.Equal(t, inserted.Entity.Id, replaced.Entity.Id, "a matched document keeps its _id")
+	assert.Equal(t, 31, replaced.Entity.Age)
+
+	count, err := repo.CountDocumentsInCollected(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func testUpdate(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "alice", Age: 30})
+
+	entities[0].Age = 31
+	updated, err := repo.Update(context.Background(), entities[0])
+	require.NoError(t, err)
+	assert.Equal(t, entities[0], *updated)
+
+	found, err := repo.FindById(context.Background(), entities[0].Id)
+	require.NoError(t, err)
+	assert.Equal(t, 31, found.Age)
+}
+
+func testUpdateNotFound(t *testing.T, repo repository.Repository[Entity]) {
+	_, err := repo.Update(context.Background(), Entity{Id: bson.NewObjectID(), Name: "ghost"})
+	assert.True(t, errors.Is(err, repository.ErrNotFound), "want ErrNotFound, got %v", err)
+
+	count, err := repo.CountDocumentsInCollected(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, count, "Update must not create documents")
+}
+
+func testUpdateOne(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "alice", Age: 30})
+
+	err := repo.UpdateOne(context.Background(), bson.M{"name": "alice"}, bson.M{"$inc": bson.M{"age": 1}})
+	require.NoError(t, err)
+
+	found, err := repo.FindById(context.Background(), entities[0].Id)
+	require.NoError(t, err)
+	assert.Equal(t, 31, found.Age)
+
+	err = repo.UpdateOne(context.Background(), bson.M{"name": "alice"}, bson.M{"$set": bson.M{"age": 31}})
+	assert.Error(t, err, "an update that changes nothing fails")
+
+	err = repo.UpdateOne(context.Background(), bson.M{"name": "ghost"}, bson.M{"$set": bson.M{"age": 1}})
+	assert.Error(t, err, "an update that matches nothing fails")
+}
+
+func testUpdateMany(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "a", Age: 1}, Entity{Name: "b", Age: 2})
+
+	entities[0].Age, entities[1].Age = 10, 20
+	updated, err := repo.UpdateMany(context.Background(), entities)
+	require.NoError(t, err)
+	assert.Len(t, updated, 2)
+
+	found, err := repo.FindByIds(context.Background(), []bson.ObjectID{entities[0].Id, entities[1].Id},
+		options.Find().SetSort(bson.M{"age": 1}))
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, 10, found[0].Age)
+	assert.Equal(t, 20, found[1].Age)
+
+	_, err = repo.UpdateMany(context.Background(), []Entity{{Id: bson.NewObjectID()}})
+	assert.True(t, errors.Is(err, repository.ErrNotFound), "want ErrNotFound, got %v", err)
+}
+
+func testFindById(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "alice", Age: 30})
+
+	found, err := repo.FindById(context.Background(), entities[0].Id, options.FindOne().SetProjection(bson.M{"name": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, Entity{Id: entities[0].Id, Name: "alice"}, *found)
+}
+
+func testFindByIdNotFound(t *testing.T, repo repository.Repository[Entity]) {
+	_, err := repo.FindById(context.Background(), bson.NewObjectID())
+	assert.True(t, errors.Is(err, repository.ErrNotFound), "want ErrNotFound, got %v", err)
+	assert.True(t, errors.Is(err, mongo.ErrNoDocuments))
+}
+
+func testDelete(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "alice"})
+
+	require.NoError(t, repo.Delete(context.Background(), entities[0].Id))
+
+	_, err := repo.FindById(context.Background(), entities[0].Id)
+	assert.True(t, errors.Is(err, repository.ErrNotFound))
+
+	assert.NoError(t, repo.Delete(context.Background(), entities[0].Id), "deleting a missing document is not an error")
+}
+
+func testDeleteMany(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "a"}, Entity{Name: "b"}, Entity{Name: "c"})
+
+	require.NoError(t, repo.DeleteMany(context.Background(), []bson.ObjectID{entities[0].Id, entities[2].Id, bson.NewObjectID()}))
+
+	found, err := repo.FindEntityDocumentsByFilter(context.Background(), bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, names(found))
+}
+
+func testDeleteManyEmptyIds(t *testing.T, repo repository.Repository[Entity]) {
+	seed(t, repo, Entity{Name: "a"})
+
+	assert.NoError(t, repo.DeleteMany(context.Background(), nil))
+	assert.NoError(t, repo.DeleteMany(context.Background(), []bson.ObjectID{}))
+
+	count, err := repo.CountDocumentsInCollected(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func testFindByIds(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "a"}, Entity{Name: "b"}, Entity{Name: "c"})
+
+	found, err := repo.FindByIds(context.Background(), []bson.ObjectID{entities[2].Id, entities[0].Id, bson.NewObjectID()},
+		options.Find().SetSort(bson.M{"name": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, names(found))
+}
+
+func testFindByIdsEmptyIds(t *testing.T, repo repository.Repository[Entity]) {
+	seed(t, repo, Entity{Name: "a"})
+
+	found, err := repo.FindByIds(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	found, err = repo.FindByIds(context.Background(), []bson.ObjectID{})
+	require.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+func testFindEntityDocumentsByFilter(t *testing.T, repo repository.Repository[Entity]) {
+	seed(t, repo,
+		Entity{Name: "a", Age: 30, Tags: []string{"x"}},
+		Entity{Name: "b", Age: 20},
+		Entity{Name: "c", Age: 40, Tags: []string{"x", "y"}},
+		Entity{Name: "d", Age: 35},
+	)
+
+	found, err := repo.FindEntityDocumentsByFilter(context.Background(), bson.M{"age": bson.M{"$gte": 30}},
+		options.Find().SetSort(bson.M{"age": -1}).SetSkip(1).SetLimit(2))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "a"}, names(found))
+
+	found, err = repo.FindEntityDocumentsByFilter(context.Background(), bson.M{
+		"tags": "x",
+		"$or":  bson.A{bson.M{"name": bson.M{"$regex": "^C", "$options": "i"}}, bson.M{"age": bson.M{"$lt": 35}}},
+	}, options.Find().SetSort(bson.M{"name": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, names(found))
+
+	found, err = repo.FindEntityDocumentsByFilter(context.Background(), bson.M{"name": "ghost"})
+	require.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+func testFindEntityDocumentByFilter(t *testing.T, repo repository.Repository[Entity]) {
+	seed(t, repo, Entity{Name: "a", Age: 30}, Entity{Name: "b", Age: 40})
+
+	found, err := repo.FindEntityDocumentByFilter(context.Background(), bson.M{"age": bson.M{"$gt": 10}},
+		options.FindOne().SetSort(bson.M{"age": -1}))
+	require.NoError(t, err)
+	assert.Equal(t, "b", found.Name)
+
+	_, err = repo.FindEntityDocumentByFilter(context.Background(), bson.M{"name": "ghost"})
+	assert.True(t, errors.Is(err, repository.ErrNotFound), "want ErrNotFound, got %v", err)
+}
+
+func testCountDocumentsInCollected(t *testing.T, repo repository.Repository[Entity]) {
+	count, err := repo.CountDocumentsInCollected(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	seed(t, repo, Entity{Name: "a"}, Entity{Name: "b"})
+
+	count, err = repo.CountDocumentsInCollected(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func testFindAllPageable(t *testing.T, repo repository.Repository[Entity]) {
+	empty, err := repo.FindAllPageable(base_entity.PageableDBRequest{NumberPerPage: 2}, context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, empty.Total)
+	assert.Empty(t, empty.Data)
+	assert.Empty(t, empty.LastItemId)
+
+	seed(t, repo, Entity{Name: "a"}, Entity{Name: "b"}, Entity{Name: "c"})
+
+	first, err := repo.FindAllPageable(base_entity.PageableDBRequest{NumberPerPage: 2}, context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), first.Total)
+	assert.Equal(t, int64(2), first.NoOfItemsInBatch)
+	assert.Equal(t, int64(2), first.NumberPerPage)
+	require.Len(t, first.Data, 2)
+	assert.Equal(t, "c", first.Data[0].Name, "pages are ordered newest _id first")
+	assert.Equal(t, first.Data[1].Id.Hex(), first.LastItemId)
+
+	second, err := repo.FindAllPageable(base_entity.PageableDBRequest{NumberPerPage: 2, LastItemId: first.LastItemId}, context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), second.Total)
+	require.Len(t, second.Data, 1)
+	assert.Equal(t, "a", second.Data[0].Name)
+
+	projected, err := repo.FindAllPageable(base_entity.PageableDBRequest{NumberPerPage: 1, Projection: bson.M{"_id": 0, "age": 0}}, context.Background())
+	require.NoError(t, err)
+	require.Len(t, projected.Data, 1)
+	assert.False(t, projected.Data[0].Id.IsZero(), "the _id is always kept")
+}
+
+func testFindAllPageableInvalidLastItemId(t *testing.T, repo repository.Repository[Entity]) {
+	_, err := repo.FindAllPageable(base_entity.PageableDBRequest{NumberPerPage: 2, LastItemId: "not-an-id"}, context.Background())
+	assert.Error(t, err)
+}
+
+func testAggregate(t *testing.T, repo repository.Repository[Entity]) {
+	seed(t, repo, Entity{Name: "a", Age: 30}, Entity{Name: "b", Age: 30}, Entity{Name: "c", Age: 40})
+
+	cursor, err := repo.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$age"}, {Key: "count", Value: bson.M{"$sum": 1}}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	require.NoError(t, err)
+
+	var groups []struct {
+		Age   int `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	require.NoError(t, cursor.All(context.Background(), &groups))
+	require.Len(t, groups, 2)
+	assert.Equal(t, 30, groups[0].Age)
+	assert.Equal(t, 2, groups[0].Count)
+
+	cursor, err = repo.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"age": 30}}},
+		{{Key: "$sort", Value: bson.M{"name": -1}}},
+	})
+	require.NoError(t, err)
+
+	entities, err := repo.HandleResultCursorForObject(cursor, context.Background(), nil)
+	require.NoError(t, err)
+	require.Len(t, entities, 2)
+	assert.Equal(t, "b", entities[0].Name)
+}
+
+func testAggregateForEntity(t *testing.T, repo repository.Repository[Entity]) {
+	seed(t, repo, Entity{Name: "a", Age: 30, Tags: []string{"x", "y"}}, Entity{Name: "b", Age: 40})
+
+	entities, err := repo.AggregateForEntity(context.Background(), mongo.Pipeline{
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$project", Value: bson.M{"name": 1, "age": 1}}},
+		{{Key: "$skip", Value: 1}},
+		{{Key: "$limit", Value: 5}},
+	})
+	require.NoError(t, err)
+	require.Len(t, entities, 1)
+	assert.Equal(t, "a", entities[0].Name)
+	assert.Nil(t, entities[0].Tags)
+}
+
+func testFindOneAndUpdate(t *testing.T, repo repository.Repository[Entity]) {
+	seed(t, repo, Entity{Name: "a", Age: 30}, Entity{Name: "b", Age: 40})
+
+	before, err := repo.FindOneAndUpdate(context.Background(), bson.M{}, bson.M{"$inc": bson.M{"age": 1}},
+		options.FindOneAndUpdate().SetSort(bson.M{"age": -1}))
+	require.NoError(t, err)
+	assert.Equal(t, "b", before.Name)
+	assert.Equal(t, 40, before.Age)
+
+	after, err := repo.FindOneAndUpdate(context.Background(), bson.M{"name": "b"}, bson.M{"$inc": bson.M{"age": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, 42, after.Age)
+
+	_, err = repo.FindOneAndUpdate(context.Background(), bson.M{"name": "ghost"}, bson.M{"$set": bson.M{"age": 1}})
+	assert.True(t, errors.Is(err, repository.ErrNotFound), "want ErrNotFound, got %v", err)
+
+	upserted, err := repo.FindOneAndUpdate(context.Background(), bson.M{"name": "c"}, bson.M{"$set": bson.M{"age": 5}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, "c", upserted.Name)
+	assert.Equal(t, 5, upserted.Age)
+	assert.False(t, upserted.Id.IsZero())
+}
+
+func testFindOneAndReplace(t *testing.T, repo repository.Repository[Entity]) {
+	entities := seed(t, repo, Entity{Name: "a", Age: 30, Tags: []string{"x"}})
+
+	replacement := Entity{Id: entities[0].Id, Name: "a", Age: 31}
+	before, err := repo.FindOneAndReplace(context.Background(), bson.M{"name": "a"}, replacement)
+	require.NoError(t, err)
+	assert.Equal(t, entities[0], *before)
+
+	found, err := repo.FindById(context.Background(), entities[0].Id)
+	require.NoError(t, err)
+	assert.Equal(t, replacement, *found)
+
+	_, err = repo.FindOneAndReplace(context.Background(), bson.M{"name": "ghost"}, Entity{Id: bson.NewObjectID()})
+	assert.True(t, errors.Is(err, repository.ErrNotFound), "want ErrNotFound, got %v", err)
+}
+
+func testFindOneAndDelete(t *testing.T, repo repository.Repository[Entity]) {
+	seed(t, repo, Entity{Name: "a", Age: 30}, Entity{Name: "b", Age: 40})
+
+	deleted, err := repo.FindOneAndDelete(context.Background(), bson.M{}, options.FindOneAndDelete().SetSort(bson.M{"age": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, "a", deleted.Name)
+
+	count, err := repo.CountDocumentsInCollected(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	_, err = repo.FindOneAndDelete(context.Background(), bson.M{"name": "a"})
+	assert.True(t, errors.Is(err, repository.ErrNotFound), "want ErrNotFound, got %v", err)
+}
@@ -0,0 +1,133 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Level which documents the server validates
+type Level string
+
+const (
+	// Strict validate every insert and update
+	Strict Level = "strict"
+	// Moderate validate inserts and updates of documents that already pass
+	Moderate Level = "moderate"
+	// Off disable validation
+	Off Level = "off"
+)
+
+// Action what the server does with an invalid document
+type Action string
+
+const (
+	// Error reject the write
+	Error Action = "error"
+	// Warn log the violation and accept the write
+	Warn Action = "warn"
+)
+
+// ApplyOptions configures Apply
+type ApplyOptions struct {
+	// Level defaults to Strict
+	Level Level
+	// Action defaults to Error
+	Action Action
+}
+
+// Apply set the validator of a collection, creating the collection when it does not exist
+func Apply(ctx context.Context, database *mongo.Database, collection string, validator bson.D, opts ...*ApplyOptions) error {
+	opt := mergeApplyOptions(opts)
+
+	names, err := database.ListCollectionNames(ctx, bson.D{{Key: "name", Value: collection}})
+	if err != nil {
+		return fmt.Errorf("could not list collections: %w", err)
+	}
+
+	if len(names) == 0 {
+		create := options.CreateCollection().
+			SetValidator(validator).
+			SetValidationLevel(string(opt.Level)).
+			SetValidationAction(string(opt.Action))
+
+		if err := database.CreateCollection(ctx, collection, create); err != nil {
+			log.WithError(err).Error(fmt.Sprintf("could not create %s collection with validator", collection))
+			return err
+		}
+	} else {
+		collMod := bson.D{
+			{Key: "collMod", Value: collection},
+			{Key: "validator", Value: validator},
+			{Key: "validationLevel", Value: string(opt.Level)},
+			{Key: "validationAction", Value: string(opt.Action)},
+		}
+
+		if err := database.RunCommand(ctx, collMod).Err(); err != nil {
+			log.WithError(err).Error(fmt.Sprintf("could not set validator of %s collection", collection))
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"level":  opt.Level,
+		"action": opt.Action,
+	}).Info(fmt.Sprintf("applied %s validator", collection))
+	return nil
+}
+
+// Report the existing documents that violate a validator
+type Report struct {
+	// Violations the number of violating documents
+	Violations int64
+	// Samples up to the requested number of violating documents
+	Samples []bson.Raw
+}
+
+// DryRun report the collection's documents that violate the validator without applying it. At
+// most samples violating documents are returned, in _id order.
+func DryRun(ctx context.Context, collection *mongo.Collection, validator bson.D, samples int64) (*Report, error) {
+	filter := bson.D{{Key: "$nor", Value: bson.A{validator}}}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("could not check %s against the validator: %w", collection.Name(), err)
+	}
+
+	report := &Report{Violations: count}
+	if count == 0 || samples <= 0 {
+		return report, nil
+	}
+
+	find := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(samples)
+	cursor, err := collection.Find(ctx, filter, find)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		report.Samples = append(report.Samples, append(bson.Raw(nil), cursor.Current...))
+	}
+	return report, cursor.Err()
+}
+
+func mergeApplyOptions(opts []*ApplyOptions) ApplyOptions {
+	merged := ApplyOptions{Level: Strict, Action: Error}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Level != "" {
+			merged.Level = opt.Level
+		}
+		if opt.Action != "" {
+			merged.Action = opt.Action
+		}
+	}
+	return merged
+}
@@ -0,0 +1,300 @@
+// Package schema generates $jsonSchema validators from entity structs and applies them to
+// collections, so the server enforces the shape the application writes.
+//
+// The schema follows the driver's encoding of each field: its bson name and BSON type, null for
+// pointers, slices and maps, which encode nil as null, and embedded documents. `validate` tag
+// rules become schema keywords:
+//
+//	required            the field is listed as required and may not be null
+//	min, max, len       minimum / maximum, minLength / maxLength or minItems / maxItems
+//	gt, gte, lt, lte    minimum / maximum, exclusive for gt and lt
+//	oneof               enum
+//	email, url, uuid, pattern   pattern
+//	dive                the rules after it apply to the items of a slice
+package schema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/internal/bsonfield"
+	"github.com/hub1989/mongo-data/v4/internal/validatetag"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// For the validator of T's documents: {$jsonSchema: {...}}
+func For[T any]() (bson.D, error) {
+	t := reflect.TypeFor[T]()
+	if bsonfield.Indirect(t).Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct", t)
+	}
+
+	document, err := object(bsonfield.Indirect(t), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", t, err)
+	}
+
+	document = append(bson.D{{Key: "title", Value: bsonfield.Indirect(t).Name()}}, document...)
+	return bson.D{{Key: "$jsonSchema", Value: document}}, nil
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	objectIdType   = reflect.TypeFor[bson.ObjectID]()
+	dateTimeType   = reflect.TypeFor[bson.DateTime]()
+	decimalType    = reflect.TypeFor[bson.Decimal128]()
+	binaryType     = reflect.TypeFor[bson.Binary]()
+	timestampType  = reflect.TypeFor[bson.Timestamp]()
+	regexType      = reflect.TypeFor[bson.Regex]()
+	documentType   = reflect.TypeFor[bson.D]()
+	rawType        = reflect.TypeFor[bson.Raw]()
+	rawValueType   = reflect.TypeFor[bson.RawValue]()
+	javascriptType = reflect.TypeFor[bson.JavaScript]()
+	symbolType     = reflect.TypeFor[bson.Symbol]()
+)
+
+// object the schema of a struct's fields
+func object(t reflect.Type, visiting []reflect.Type) (bson.D, error) {
+	document := bson.D{{Key: "bsonType", Value: "object"}}
+	if slices.Contains(visiting, t) {
+		// a recursive type: leave the nested document unconstrained
+		return document, nil
+	}
+	visiting = append(visiting, t)
+
+	var required bson.A
+	properties := bson.D{}
+	for _, field := range bsonfield.Fields(t) {
+		rules, err := validatetag.Parse(field.StructField.Tag.Get(validatetag.Tag))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+
+		property, err := property(field.Type, rules, visiting)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+
+		if rules.Required {
+			required = append(required, field.Name)
+		}
+		properties = append(properties, bson.E{Key: field.Name, Value: property})
+	}
+
+	if required != nil {
+		document = append(document, bson.E{Key: "required", Value: required})
+	}
+	return append(document, bson.E{Key: "properties", Value: properties}), nil
+}
+
+// property the schema of a value of type t constrained by rules
+func property(t reflect.Type, rules validatetag.Rules, visiting []reflect.Type) (bson.D, error) {
+	nullable := false
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		nullable = !rules.Required
+	}
+	t = bsonfield.Indirect(t)
+
+	document, kind, err := typed(t, rules, visiting)
+	if err != nil {
+		return nil, err
+	}
+
+	if nullable && len(document) > 0 && document[0].Key == "bsonType" {
+		switch bsonType := document[0].Value.(type) {
+		case string:
+			document[0].Value = bson.A{bsonType, "null"}
+		case bson.A:
+			document[0].Value = append(bsonType, "null")
+		}
+	}
+
+	constraints, err := constrain(t, kind, rules.Constraints)
+	if err != nil {
+		return nil, err
+	}
+	if len(constraints) == 0 {
+		return document, nil
+	}
+
+	if rules.OmitEmpty {
+		// an empty value passes; zero numbers and empty strings are written unless bson omits them
+		empty := bson.D{{Key: "enum", Value: bson.A{reflect.Zero(t).Interface()}}}
+		switch kind {
+		case "string":
+			empty = bson.D{{Key: "maxLength", Value: int64(0)}}
+		case "array":
+			empty = bson.D{{Key: "maxItems", Value: int64(0)}}
+		}
+		return append(document, bson.E{Key: "anyOf", Value: bson.A{empty, constraints}}), nil
+	}
+	return append(document, constraints...), nil
+}
+
+// typed the type keywords of a value of type t, and the kind of value rules apply to
+func typed(t reflect.Type, rules validatetag.Rules, visiting []reflect.Type) (bson.D, string, error) {
+	bsonType := func(names ...any) bson.D {
+		if len(names) == 1 {
+			return bson.D{{Key: "bsonType", Value: names[0]}}
+		}
+		return bson.D{{Key: "bsonType", Value: bson.A(names)}}
+	}
+
+	switch t {
+	case timeType, dateTimeType:
+		return bsonType("date"), "", nil
+	case objectIdType:
+		return bsonType("objectId"), "", nil
+	case decimalType:
+		return bsonType("decimal"), "", nil
+	case binaryType:
+		return bsonType("binData"), "", nil
+	case timestampType:
+		return bsonType("timestamp"), "", nil
+	case regexType:
+		return bsonType("regex"), "", nil
+	case javascriptType:
+		return bsonType("javascript"), "", nil
+	case symbolType:
+		return bsonType("symbol"), "", nil
+	case documentType, rawType:
+		return bsonType("object"), "", nil
+	case rawValueType:
+		return bson.D{}, "", nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return bsonType("string"), "string", nil
+	case reflect.Bool:
+		return bsonType("bool"), "", nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return bsonType("int"), "number", nil
+	case reflect.Int64, reflect.Uint32, reflect.Uint64:
+		return bsonType("long"), "number", nil
+	case reflect.Int, reflect.Uint:
+		// encoded as an int32 when the value fits, as an int64 otherwise
+		return bsonType("int", "long"), "number", nil
+	case reflect.Float32, reflect.Float64:
+		return bsonType("double"), "number", nil
+	case reflect.Struct:
+		document, err := object(t, visiting)
+		return document, "", err
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, "", fmt.Errorf("map keys of %s must be strings", t)
+		}
+		values, err := property(t.Elem(), itemRules(rules), visiting)
+		if err != nil {
+			return nil, "", err
+		}
+		return append(bsonType("object"), bson.E{Key: "additionalProperties", Value: values}), "", nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return bsonType("binData"), "", nil
+		}
+		items, err := property(t.Elem(), itemRules(rules), visiting)
+		if err != nil {
+			return nil, "", err
+		}
+		return append(bsonType("array"), bson.E{Key: "items", Value: items}), "array", nil
+	case reflect.Interface:
+		return bson.D{}, "", nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported type %s", t)
+}
+
+func itemRules(rules validatetag.Rules) validatetag.Rules {
+	if rules.Items == nil {
+		return validatetag.Rules{}
+	}
+	return *rules.Items
+}
+
+// constrain the keywords the rules translate to for a kind of value
+func constrain(t reflect.Type, kind string, constraints []validatetag.Rule) (bson.D, error) {
+	var document bson.D
+	for _, rule := range constraints {
+		var keywords bson.D
+		switch kind + " " + rule.Name {
+		case "number min", "number gte":
+			keywords = bson.D{{Key: "minimum", Value: number(rule.Number())}}
+		case "number max", "number lte":
+			keywords = bson.D{{Key: "maximum", Value: number(rule.Number())}}
+		case "number gt":
+			keywords = bson.D{{Key: "minimum", Value: number(rule.Number())}, {Key: "exclusiveMinimum", Value: true}}
+		case "number lt":
+			keywords = bson.D{{Key: "maximum", Value: number(rule.Number())}, {Key: "exclusiveMaximum", Value: true}}
+		case "string min", "string gte":
+			keywords = bson.D{{Key: "minLength", Value: int64(rule.Number())}}
+		case "string max", "string lte":
+			keywords = bson.D{{Key: "maxLength", Value: int64(rule.Number())}}
+		case "string gt":
+			keywords = bson.D{{Key: "minLength", Value: int64(rule.Number()) + 1}}
+		case "string lt":
+			keywords = bson.D{{Key: "maxLength", Value: int64(rule.Number()) - 1}}
+		case "string len":
+			keywords = bson.D{{Key: "minLength", Value: int64(rule.Number())}, {Key: "maxLength", Value: int64(rule.Number())}}
+		case "array min", "array gte":
+			keywords = bson.D{{Key: "minItems", Value: int64(rule.Number())}}
+		case "array max", "array lte":
+			keywords = bson.D{{Key: "maxItems", Value: int64(rule.Number())}}
+		case "array gt":
+			keywords = bson.D{{Key: "minItems", Value: int64(rule.Number()) + 1}}
+		case "array lt":
+			keywords = bson.D{{Key: "maxItems", Value: int64(rule.Number()) - 1}}
+		case "array len":
+			keywords = bson.D{{Key: "minItems", Value: int64(rule.Number())}, {Key: "maxItems", Value: int64(rule.Number())}}
+		case "string email", "string url", "string uuid", "string pattern":
+			keywords = bson.D{{Key: "pattern", Value: rule.Pattern()}}
+		case "string oneof", "number oneof":
+			options, err := enum(t, rule.Options())
+			if err != nil {
+				return nil, err
+			}
+			keywords = bson.D{{Key: "enum", Value: options}}
+		default:
+			return nil, fmt.Errorf("rule %s does not apply to %s", rule.Name, t)
+		}
+		document = append(document, keywords...)
+	}
+	return document, nil
+}
+
+// enum the oneof options as values of the field's type
+func enum(t reflect.Type, options []string) (bson.A, error) {
+	values := make(bson.A, len(options))
+	for i, option := range options {
+		switch t.Kind() {
+		case reflect.String:
+			values[i] = option
+		case reflect.Float32, reflect.Float64:
+			value, err := strconv.ParseFloat(option, 64)
+			if err != nil {
+				return nil, fmt.Errorf("rule oneof: %q is not a number", option)
+			}
+			values[i] = value
+		default:
+			value, err := strconv.ParseInt(option, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("rule oneof: %q is not an integer", option)
+			}
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
+// number a bound as an integer when it is whole, which reads better in the validator
+func number(value float64) any {
+	if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+		return int64(value)
+	}
+	return value
+}
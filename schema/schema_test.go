@@ -0,0 +1,124 @@
+package schema
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hub1989/mongo-data/v4/mongotest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type Address struct {
+	City string `bson:"city" validate:"required,min=2"`
+}
+
+type User struct {
+	Id       bson.ObjectID     `bson:"_id"`
+	Email    string            `bson:"email" validate:"required,email"`
+	Age      int               `bson:"age" validate:"gte=0,lt=150"`
+	Role     string            `bson:"role" validate:"oneof=admin member"`
+	Nickname string            `bson:"nickname" validate:"omitempty,len=4"`
+	Score    float64           `bson:"score"`
+	Joined   time.Time         `bson:"joined"`
+	Address  *Address          `bson:"address,omitempty"`
+	Tags     []string          `bson:"tags" validate:"max=3,dive,min=1"`
+	Labels   map[string]string `bson:"labels"`
+	Parent   *User             `bson:"parent,omitempty"`
+	Ignored  string            `bson:"-"`
+}
+
+func TestFor(t *testing.T) {
+	validator, err := For[User]()
+	assert.Nil(t, err)
+
+	expected := bson.D{{Key: "$jsonSchema", Value: bson.D{
+		{Key: "title", Value: "User"},
+		{Key: "bsonType", Value: "object"},
+		{Key: "required", Value: bson.A{"email"}},
+		{Key: "properties", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "bsonType", Value: "objectId"}}},
+			{Key: "email", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "pattern", Value: `^[^@\s]+@[^@\s]+\.[^@\s]+$`}}},
+			{Key: "age", Value: bson.D{
+				{Key: "bsonType", Value: bson.A{"int", "long"}},
+				{Key: "minimum", Value: int64(0)},
+				{Key: "maximum", Value: int64(150)},
+				{Key: "exclusiveMaximum", Value: true},
+			}},
+			{Key: "role", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "enum", Value: bson.A{"admin", "member"}}}},
+			{Key: "nickname", Value: bson.D{
+				{Key: "bsonType", Value: "string"},
+				{Key: "anyOf", Value: bson.A{
+					bson.D{{Key: "maxLength", Value: int64(0)}},
+					bson.D{{Key: "minLength", Value: int64(4)}, {Key: "maxLength", Value: int64(4)}},
+				}},
+			}},
+			{Key: "score", Value: bson.D{{Key: "bsonType", Value: "double"}}},
+			{Key: "joined", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+			{Key: "address", Value: bson.D{
+				{Key: "bsonType", Value: bson.A{"object", "null"}},
+				{Key: "required", Value: bson.A{"city"}},
+				{Key: "properties", Value: bson.D{
+					{Key: "city", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: int64(2)}}},
+				}},
+			}},
+			{Key: "tags", Value: bson.D{
+				{Key: "bsonType", Value: bson.A{"array", "null"}},
+				{Key: "items", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: int64(1)}}},
+				{Key: "maxItems", Value: int64(3)},
+			}},
+			{Key: "labels", Value: bson.D{
+				{Key: "bsonType", Value: bson.A{"object", "null"}},
+				{Key: "additionalProperties", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			}},
+			{Key: "parent", Value: bson.D{{Key: "bsonType", Value: bson.A{"object", "null"}}}},
+		}},
+	}}}
+
+	assert.Equal(t, expected, validator)
+}
+
+func TestFor_InvalidRules(t *testing.T) {
+	type unknown struct {
+		Name string `bson:"name" validate:"requird"`
+	}
+	_, err := For[unknown]()
+	assert.ErrorContains(t, err, `field name: unknown rule "requird"`)
+
+	type mismatched struct {
+		Age int `bson:"age" validate:"email"`
+	}
+	_, err = For[mismatched]()
+	assert.ErrorContains(t, err, "rule email does not apply to int")
+
+	_, err = For[string]()
+	assert.NotNil(t, err)
+}
+
+func TestApplyAndDryRun(t *testing.T) {
+	server := mongotest.Start(t)
+	database := server.Database(t)
+	ctx := context.Background()
+
+	validator, err := For[Address]()
+	assert.Nil(t, err)
+
+	// creates the collection
+	assert.Nil(t, Apply(ctx, database, "addresses", validator))
+	_, err = database.Collection("addresses").InsertOne(ctx, bson.M{"city": "x"})
+	assert.NotNil(t, err, "strict validation rejects the document")
+
+	// modifies the existing collection
+	assert.Nil(t, Apply(ctx, database, "addresses", validator, &ApplyOptions{Action: Warn}))
+	_, err = database.Collection("addresses").InsertOne(ctx, bson.M{"city": "x"})
+	assert.Nil(t, err)
+	_, err = database.Collection("addresses").InsertOne(ctx, bson.M{"city": "Accra"})
+	assert.Nil(t, err)
+
+	report, err := DryRun(ctx, database.Collection("addresses"), validator, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), report.Violations)
+	assert.Len(t, report.Samples, 1)
+	assert.Equal(t, "x", report.Samples[0].Lookup("city").StringValue())
+}